@@ -0,0 +1,22 @@
+package agent
+
+import "github.com/hashicorp/consul/api"
+
+type consulAPIACL interface {
+	Info(id string, q *api.QueryOptions) (*api.ACLEntry, *api.QueryMeta, error)
+	Update(acl *api.ACLEntry, q *api.WriteOptions) (*api.WriteMeta, error)
+}
+
+// ACLToken returns the ACL token with the given ID, read with token, or nil
+// when there is no such token.
+func (c Client) ACLToken(id, token string) (*api.ACLEntry, error) {
+	entry, _, err := c.ConsulAPIACL.Info(id, &api.QueryOptions{Token: token})
+	return entry, err
+}
+
+// SetACLToken creates or updates the ACL token with entry's ID, written with
+// token.
+func (c Client) SetACLToken(entry *api.ACLEntry, token string) error {
+	_, err := c.ConsulAPIACL.Update(entry, &api.WriteOptions{Token: token})
+	return err
+}
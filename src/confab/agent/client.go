@@ -3,10 +3,12 @@ package agent
 import (
 	"crypto/sha1"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
-	"io/ioutil"
+	"fmt"
+	"net"
 	"strings"
+	"sync"
+	"time"
 
 	"code.cloudfoundry.org/lager"
 
@@ -17,6 +19,9 @@ import (
 
 var NoMembersToJoinError = errors.New("no members to join")
 
+// memberStatusAlive is the Status the agent reports for an alive member.
+const memberStatusAlive = 1
+
 type logger interface {
 	Info(action string, data ...lager.Data)
 	Error(action string, err error, data ...lager.Data)
@@ -27,6 +32,7 @@ type consulAPIAgent interface {
 	Join(member string, wan bool) error
 	Self() (map[string]map[string]interface{}, error)
 	Leave() error
+	ServiceDeregister(serviceID string) error
 }
 
 type consulAPIOperator interface {
@@ -34,13 +40,27 @@ type consulAPIOperator interface {
 	KeyringInstall(string, *api.WriteOptions) error
 	KeyringUse(string, *api.WriteOptions) error
 	KeyringRemove(string, *api.WriteOptions) error
+	RaftGetConfiguration(*api.QueryOptions) (*api.RaftConfiguration, error)
+	RaftRemovePeerByAddress(string, *api.WriteOptions) error
 }
 
 type Client struct {
 	ExpectedMembers   []string
+	Datacenter        string
+	MinJoinedServers  int
+	RemoveStalePeers  bool
+	JoinedWAN         bool
 	ConsulAPIAgent    consulAPIAgent
 	ConsulAPIOperator consulAPIOperator
+	ConsulAPIACL      consulAPIACL
+	Join              JoinConfig
+	Sleeper           sleeper
 	Logger            logger
+
+	// LookupHost resolves expected members given as hostnames, such as BOSH
+	// DNS names, to the addresses the agent reports. It defaults to
+	// net.LookupHost.
+	LookupHost func(host string) ([]string, error)
 }
 
 func (c Client) VerifyJoined() error {
@@ -66,20 +86,144 @@ func (c Client) VerifyJoined() error {
 		"members": addresses,
 	})
 
+	var servers []*api.AgentMember
 	for _, member := range members {
 		if member.Tags["role"] == "consul" {
-			c.Logger.Info("agent-client.verify-joined.members.joined")
-			return nil
+			servers = append(servers, member)
 		}
 	}
 
-	err = errors.New("no expected members")
-	c.Logger.Error("agent-client.verify-joined.members.not-joined", err, lager.Data{
-		"wan":     false,
-		"members": addresses,
-	})
+	if len(servers) == 0 {
+		err = errors.New("no expected members")
+		c.Logger.Error("agent-client.verify-joined.members.not-joined", err, lager.Data{
+			"wan":     false,
+			"members": addresses,
+		})
+		return err
+	}
+
+	for _, server := range servers {
+		if datacenter := server.Tags["dc"]; c.Datacenter != "" && datacenter != "" && datacenter != c.Datacenter {
+			err = fmt.Errorf("server %s is in datacenter %q, expected %q", server.Addr, datacenter, c.Datacenter)
+			c.Logger.Error("agent-client.verify-joined.members.datacenter-mismatch", err)
+			return err
+		}
+	}
+
+	if len(c.ExpectedMembers) == 0 {
+		for _, server := range servers {
+			if server.Status == memberStatusAlive {
+				c.Logger.Info("agent-client.verify-joined.members.joined")
+				return nil
+			}
+		}
+
+		err = errors.New("no alive servers")
+		c.Logger.Error("agent-client.verify-joined.members.not-joined", err, lager.Data{
+			"wan":     false,
+			"members": addresses,
+		})
+		return err
+	}
+
+	var (
+		known      int
+		unresolved int
+		alive      []string
+		missing    []string
+	)
+	for _, expected := range c.ExpectedMembers {
+		addresses, err := c.resolve(expected)
+		if err != nil {
+			unresolved++
+			c.Logger.Error("agent-client.verify-joined.members.resolve-failed", err, lager.Data{
+				"member": expected,
+			})
+		}
+
+		server := findServer(servers, expected, addresses)
+		if server != nil {
+			known++
+		}
+
+		if server != nil && server.Status == memberStatusAlive {
+			alive = append(alive, expected)
+		} else {
+			missing = append(missing, expected)
+		}
+	}
+
+	// an agent that only knows servers that were not expected has joined a
+	// different gossip pool, which splits the cluster in two. An expected
+	// server that could not be resolved may still be a member, so this is
+	// only reported when every expected server could be compared.
+	if known == 0 && unresolved == 0 {
+		err = fmt.Errorf("joined a different gossip pool: none of the expected servers %s are members", strings.Join(c.ExpectedMembers, ", "))
+		c.Logger.Error("agent-client.verify-joined.members.different-gossip-pool", err, lager.Data{
+			"wan":     false,
+			"members": addresses,
+		})
+		return err
+	}
+
+	required := c.MinJoinedServers
+	if required < 1 {
+		required = 1
+	}
+	if required > len(c.ExpectedMembers) {
+		required = len(c.ExpectedMembers)
+	}
+
+	if len(alive) < required {
+		err = fmt.Errorf("%d of %d expected servers are alive members, %d required: missing %s",
+			len(alive), len(c.ExpectedMembers), required, strings.Join(missing, ", "))
+		c.Logger.Error("agent-client.verify-joined.members.missing-servers", err, lager.Data{
+			"alive":   alive,
+			"missing": missing,
+		})
+		return err
+	}
+
+	c.Logger.Info("agent-client.verify-joined.members.joined")
+	return nil
+}
+
+// findServer returns the server whose node name matches expected, or whose
+// address is one of the addresses expected resolved to.
+func findServer(servers []*api.AgentMember, expected string, addresses []string) *api.AgentMember {
+	for _, server := range servers {
+		if server.Name == expected || containsAddress(addresses, server.Addr) {
+			return server
+		}
+	}
+
+	return nil
+}
+
+// resolve returns the addresses of an expected member, ignoring any port.
+// Members given as IP addresses are returned as they are.
+func (c Client) resolve(member string) ([]string, error) {
+	host := hostOf(member)
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	lookupHost := c.LookupHost
+	if lookupHost == nil {
+		lookupHost = net.LookupHost
+	}
 
-	return err
+	return lookupHost(host)
+}
+
+func containsAddress(addresses []string, address string) bool {
+	for _, a := range addresses {
+		if a == address {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (c Client) VerifySynced() error {
@@ -115,63 +259,68 @@ func (c Client) VerifySynced() error {
 	return nil
 }
 
-func (c Client) JoinMembers() error {
-	failedToJoinCount := 0
-	for _, member := range c.ExpectedMembers {
-		c.Logger.Info("agent-client.join-members.consul-api-agent.join", lager.Data{"member": member})
-		err := c.ConsulAPIAgent.Join(member, false)
-		if err != nil {
-			if strings.Contains(err.Error(), "connection refused") ||
-				strings.Contains(err.Error(), "no route to host") ||
-				strings.Contains(err.Error(), "i/o timeout") {
-				c.Logger.Info("agent-client.join-members.consul-api-agent.join.unable-to-join", lager.Data{
-					"reason": err.Error(),
-				})
-				failedToJoinCount++
-			} else {
-				c.Logger.Error("agent-client.join-members.consul-api-agent.join.failed", err)
-				return err
-			}
+// JoinMembers joins the expected members in parallel, retrying each one with
+// backoff while it is unreachable. It returns the outcome for every member,
+// and an error when a member fails with anything other than an
+// UnreachableError, or when fewer members were joined than the join policy
+// requires.
+func (c Client) JoinMembers() ([]JoinOutcome, error) {
+	outcomes := make([]JoinOutcome, len(c.ExpectedMembers))
+
+	var wg sync.WaitGroup
+	for i, member := range c.ExpectedMembers {
+		wg.Add(1)
+		go func(i int, member string) {
+			defer wg.Done()
+			outcomes[i] = c.joinMember(member)
+		}(i, member)
+	}
+	wg.Wait()
+
+	joined := 0
+	for _, outcome := range outcomes {
+		if outcome.Err == nil {
+			joined++
+			continue
+		}
+
+		if _, ok := outcome.Err.(*UnreachableError); !ok {
+			c.Logger.Error("agent-client.join-members.consul-api-agent.join.failed", outcome.Err)
+			return outcomes, outcome.Err
 		}
 	}
 
-	if failedToJoinCount == len(c.ExpectedMembers) {
+	required := c.Join.Policy.required(len(c.ExpectedMembers))
+	if joined == 0 && required <= 1 {
 		c.Logger.Info("agent-client.join-members.no-members-to-join")
-		return NoMembersToJoinError
+		return outcomes, NoMembersToJoinError
 	}
 
-	c.Logger.Info("agent-client.join-members.success")
-	return nil
+	if joined < required {
+		err := &InsufficientMembersError{
+			Policy:   c.Join.Policy,
+			Joined:   joined,
+			Required: required,
+		}
+		c.Logger.Error("agent-client.join-members.insufficient-members", err)
+		return outcomes, err
+	}
+
+	c.Logger.Info("agent-client.join-members.success", lager.Data{
+		"joined": joined,
+	})
+	return outcomes, nil
 }
 
 func (c Client) Members(wan bool) ([]*api.AgentMember, error) {
 	return c.ConsulAPIAgent.Members(wan)
 }
 
-func (c Client) localKeyringMatchesEncryptKeys(keyringFile string, encryptedKeys []string) bool {
-	keyringData, err := ioutil.ReadFile(keyringFile)
-	if err != nil {
-		return false
-	}
-
-	keyring := make([]string, 0)
-	if err := json.Unmarshal(keyringData, &keyring); err != nil {
-		return false
-	}
-
-	if strings.Join(keyring, "") == strings.Join(encryptedKeys, "") {
-		c.Logger.Info("agent-client.set-keys.existing-keys-match", lager.Data{
-			"keyringFile":  keyringFile,
-			"keys":         encryptedKeys,
-			"existingKeys": keyring,
-		})
-		return true
-	}
-
-	return false
-}
-
-func (c Client) SetKeys(keys []string, keyringFile string) error {
+// SetKeys reconciles the keyring of the agent's pools with keys, making the
+// first key the primary key. Once the keys are installed on every node the
+// reconciled key set is recorded in a state file in dataDir, and later calls
+// do nothing while the agent still reports exactly that set.
+func (c Client) SetKeys(keys []string, dataDir string) error {
 	if keys == nil {
 		err := errors.New("must provide a non-nil slice of keys")
 		c.Logger.Error("agent-client.set-keys.nil-slice", err)
@@ -184,33 +333,32 @@ func (c Client) SetKeys(keys []string, keyringFile string) error {
 		return err
 	}
 
-	var encryptedKeys []string
-	for _, key := range keys {
-		encryptedKey := key
-
-		decodedKey, err := base64.StdEncoding.DecodeString(key)
-		if err != nil || len(decodedKey) != 16 {
-			encryptedKey = base64.StdEncoding.EncodeToString(pbkdf2.Key([]byte(key), []byte(""), 20000, 16, sha1.New))
-		}
-
-		encryptedKeys = append(encryptedKeys, encryptedKey)
-	}
-
-	if c.localKeyringMatchesEncryptKeys(keyringFile, encryptedKeys) {
-		return nil
-	}
+	encryptedKeys := EncryptKeys(keys)
+	pools := c.keyringPools()
 
 	c.Logger.Info("agent-client.set-keys.list-keys.request")
-	existingKeys, err := c.ListKeys()
+	keyring, err := c.ConsulAPIOperator.KeyringList(&api.QueryOptions{})
 	if err != nil {
 		c.Logger.Error("agent-client.set-keys.list-keys.request.failed", err)
 		return err
 	}
 
+	existingKeys := poolKeys(keyring, pools)
+
 	c.Logger.Info("agent-client.set-keys.list-keys.response", lager.Data{
 		"keys": existingKeys,
 	})
 
+	state := c.readKeyringState(dataDir)
+	if state.matches(encryptedKeys, pools) && sameKeys(existingKeys, encryptedKeys) &&
+		KeysInstalled(keyring, encryptedKeys, pools...) == nil {
+		c.Logger.Info("agent-client.set-keys.existing-keys-match", lager.Data{
+			"keys":          encryptedKeys,
+			"reconciled-at": state.ReconciledAt.Format(time.RFC3339),
+		})
+		return nil
+	}
+
 	for _, key := range existingKeys {
 		if !containsString(encryptedKeys, key) {
 			c.Logger.Info("agent-client.set-keys.remove-key.request", lager.Data{
@@ -263,28 +411,38 @@ func (c Client) SetKeys(keys []string, keyringFile string) error {
 		"key": encryptedKeys[0],
 	})
 
+	c.Logger.Info("agent-client.set-keys.verify-keys.request", lager.Data{
+		"pools": pools,
+	})
+
+	// a node that is down or restarting has not received the keys yet and
+	// gossip brings it up to date, so an incomplete install is reported
+	// without failing the start; the keys are set again on the next start
+	if err := c.VerifyKeysInstalled(encryptedKeys, pools...); err != nil {
+		c.Logger.Error("agent-client.set-keys.verify-keys.incomplete", err, lager.Data{
+			"pools": pools,
+		})
+		return nil
+	}
+
+	c.writeKeyringState(dataDir, newKeyringState(encryptedKeys, pools, time.Now().UTC()))
+
 	c.Logger.Info("agent-client.set-keys.success")
 	return nil
 }
 
+// ListKeys returns the keys installed on any node of the LAN pool.
 func (c Client) ListKeys() ([]string, error) {
-	response, err := c.ConsulAPIOperator.KeyringList(&api.QueryOptions{})
-	if err != nil {
-		return nil, err
-	}
-
-	keys := []string{}
-	for _, keyringResponse := range response {
-		if !keyringResponse.WAN {
-			for keyName, _ := range keyringResponse.Keys {
-				keys = append(keys, keyName)
-			}
-		}
-	}
-	return keys, nil
+	return c.ListPoolKeys(KeyringPoolLAN)
+}
 
+// Keyring returns the keyring of every gossip pool, LAN and WAN, with the
+// number of nodes in each pool that have each key installed.
+func (c Client) Keyring() ([]*api.KeyringResponse, error) {
+	return c.ConsulAPIOperator.KeyringList(&api.QueryOptions{})
 }
 
+// InstallKey installs key in the LAN and WAN pools.
 func (c Client) InstallKey(key string) error {
 	err := c.ConsulAPIOperator.KeyringInstall(key, &api.WriteOptions{})
 	if err != nil {
@@ -294,6 +452,7 @@ func (c Client) InstallKey(key string) error {
 	return nil
 }
 
+// UseKey makes key the primary key of the LAN and WAN pools.
 func (c Client) UseKey(key string) error {
 	err := c.ConsulAPIOperator.KeyringUse(key, &api.WriteOptions{})
 	if err != nil {
@@ -303,6 +462,7 @@ func (c Client) UseKey(key string) error {
 	return nil
 }
 
+// RemoveKey removes key from the LAN and WAN pools.
 func (c Client) RemoveKey(key string) error {
 	err := c.ConsulAPIOperator.KeyringRemove(key, &api.WriteOptions{})
 	if err != nil {
@@ -324,6 +484,24 @@ func (c Client) Leave() error {
 	return nil
 }
 
+func (c Client) DeregisterService(serviceID string) error {
+	c.Logger.Info("agent-client.deregister-service.deregister.request", lager.Data{
+		"service": serviceID,
+	})
+
+	if err := c.ConsulAPIAgent.ServiceDeregister(serviceID); err != nil {
+		c.Logger.Error("agent-client.deregister-service.deregister.request.failed", err, lager.Data{
+			"service": serviceID,
+		})
+		return err
+	}
+	c.Logger.Info("agent-client.deregister-service.deregister.response", lager.Data{
+		"service": serviceID,
+	})
+
+	return nil
+}
+
 func (c Client) Self() error {
 	_, err := c.ConsulAPIAgent.Self()
 	if err != nil {
@@ -341,6 +519,58 @@ func (c Client) RaftStats() (map[string]interface{}, error) {
 	return data["Stats"]["raft"].(map[string]interface{}), nil
 }
 
+func (c Client) NodeName() (string, error) {
+	data, err := c.ConsulAPIAgent.Self()
+	if err != nil {
+		return "", err
+	}
+
+	nodeName, ok := data["Config"]["NodeName"].(string)
+	if !ok {
+		return "", errors.New("agent did not report a node name")
+	}
+
+	return nodeName, nil
+}
+
+func (c Client) Address() (string, error) {
+	data, err := c.ConsulAPIAgent.Self()
+	if err != nil {
+		return "", err
+	}
+
+	address, ok := data["Member"]["Addr"].(string)
+	if !ok {
+		return "", errors.New("agent did not report an address")
+	}
+
+	return address, nil
+}
+
+func (c Client) RaftConfiguration() (*api.RaftConfiguration, error) {
+	return c.ConsulAPIOperator.RaftGetConfiguration(&api.QueryOptions{AllowStale: true})
+}
+
+func (c Client) RaftRemovePeer(address string) error {
+	return c.ConsulAPIOperator.RaftRemovePeerByAddress(address, &api.WriteOptions{})
+}
+
+func EncryptKeys(keys []string) []string {
+	var encryptedKeys []string
+	for _, key := range keys {
+		encryptedKey := key
+
+		decodedKey, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(decodedKey) != 16 {
+			encryptedKey = base64.StdEncoding.EncodeToString(pbkdf2.Key([]byte(key), []byte(""), 20000, 16, sha1.New))
+		}
+
+		encryptedKeys = append(encryptedKeys, encryptedKey)
+	}
+
+	return encryptedKeys
+}
+
 func containsString(elems []string, elem string) bool {
 	for _, e := range elems {
 		if elem == e {
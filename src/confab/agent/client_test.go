@@ -1,10 +1,19 @@
 package agent_test
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
 
 	"code.cloudfoundry.org/lager"
 
@@ -21,22 +30,34 @@ var _ = Describe("Client", func() {
 	var (
 		consulAPIAgent    *fakes.FakeconsulAPIAgent
 		consulAPIOperator *fakes.FakeconsulAPIOperator
+		consulAPIACL      *fakes.FakeconsulAPIACL
 		logger            *fakes.Logger
 		client            agent.Client
-		keyringFile       string
+		dataDir           string
 	)
 
 	BeforeEach(func() {
 		consulAPIAgent = &fakes.FakeconsulAPIAgent{}
 		consulAPIOperator = &fakes.FakeconsulAPIOperator{}
+		consulAPIACL = &fakes.FakeconsulAPIACL{}
 		logger = &fakes.Logger{}
 		client = agent.Client{
 			ConsulAPIAgent:    consulAPIAgent,
 			ConsulAPIOperator: consulAPIOperator,
+			ConsulAPIACL:      consulAPIACL,
 			Logger:            logger,
+			LookupHost: func(host string) ([]string, error) {
+				return []string{host}, nil
+			},
 		}
-		f, _ := ioutil.TempFile("", "")
-		keyringFile = f.Name()
+
+		var err error
+		dataDir, err = ioutil.TempDir("", "data")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dataDir)).To(Succeed())
 	})
 
 	Describe("VerifyJoined", func() {
@@ -45,19 +66,22 @@ var _ = Describe("Client", func() {
 				client.ExpectedMembers = []string{"member1", "member2", "member3"}
 				consulAPIAgent.MembersReturns([]*api.AgentMember{
 					&api.AgentMember{
-						Addr: "member1",
+						Addr:   "member1",
+						Status: 1,
 						Tags: map[string]string{
 							"role": "consul",
 						},
 					},
 					&api.AgentMember{
-						Addr: "member2",
+						Addr:   "member2",
+						Status: 1,
 						Tags: map[string]string{
 							"role": "consul",
 						},
 					},
 					&api.AgentMember{
-						Addr: "member3",
+						Addr:   "member3",
+						Status: 1,
 						Tags: map[string]string{
 							"role": "consul",
 						},
@@ -88,6 +112,143 @@ var _ = Describe("Client", func() {
 			})
 		})
 
+		Context("when expected servers are not alive members", func() {
+			BeforeEach(func() {
+				client.ExpectedMembers = []string{"member1", "member2", "member3"}
+				consulAPIAgent.MembersReturns([]*api.AgentMember{
+					{Addr: "member1", Status: 1, Tags: map[string]string{"role": "consul"}},
+					{Addr: "member2", Status: 4, Tags: map[string]string{"role": "consul"}},
+					{Addr: "member4", Status: 1, Tags: map[string]string{"role": "node"}},
+				}, nil)
+			})
+
+			It("succeeds when enough expected servers are alive", func() {
+				Expect(client.VerifyJoined()).To(Succeed())
+			})
+
+			It("returns an error naming the missing servers when too few are alive", func() {
+				client.MinJoinedServers = 2
+
+				err := client.VerifyJoined()
+				Expect(err).To(MatchError("1 of 3 expected servers are alive members, 2 required: missing member2, member3"))
+				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "agent-client.verify-joined.members.missing-servers",
+						Error:  err,
+						Data: []lager.Data{{
+							"alive":   []string{"member1"},
+							"missing": []string{"member2", "member3"},
+						}},
+					},
+				}))
+			})
+
+			It("does not require more servers than are expected", func() {
+				client.ExpectedMembers = []string{"member1"}
+				client.MinJoinedServers = 3
+
+				Expect(client.VerifyJoined()).To(Succeed())
+			})
+
+			It("matches expected servers by node name and ignores ports", func() {
+				client.ExpectedMembers = []string{"consul-0", "member2:8301"}
+				client.MinJoinedServers = 2
+				consulAPIAgent.MembersReturns([]*api.AgentMember{
+					{Name: "consul-0", Addr: "member1", Status: 1, Tags: map[string]string{"role": "consul"}},
+					{Name: "consul-1", Addr: "member2", Status: 1, Tags: map[string]string{"role": "consul"}},
+				}, nil)
+
+				Expect(client.VerifyJoined()).To(Succeed())
+			})
+		})
+
+		Context("when expected servers are hostnames", func() {
+			BeforeEach(func() {
+				client.ExpectedMembers = []string{"consul-0.consul.bosh", "consul-1.consul.bosh"}
+				client.MinJoinedServers = 2
+				client.LookupHost = func(host string) ([]string, error) {
+					switch host {
+					case "consul-0.consul.bosh":
+						return []string{"10.0.0.1"}, nil
+					case "consul-1.consul.bosh":
+						return []string{"10.0.0.2"}, nil
+					}
+					return nil, fmt.Errorf("lookup %s: no such host", host)
+				}
+			})
+
+			It("matches the servers by their resolved addresses", func() {
+				consulAPIAgent.MembersReturns([]*api.AgentMember{
+					{Name: "node-a", Addr: "10.0.0.1", Status: 1, Tags: map[string]string{"role": "consul"}},
+					{Name: "node-b", Addr: "10.0.0.2", Status: 1, Tags: map[string]string{"role": "consul"}},
+				}, nil)
+
+				Expect(client.VerifyJoined()).To(Succeed())
+			})
+
+			It("does not report a different gossip pool when a hostname cannot be resolved", func() {
+				client.ExpectedMembers = []string{"consul-0.consul.bosh", "consul-2.consul.bosh"}
+				client.MinJoinedServers = 1
+				consulAPIAgent.MembersReturns([]*api.AgentMember{
+					{Name: "node-c", Addr: "10.0.0.3", Status: 1, Tags: map[string]string{"role": "consul"}},
+				}, nil)
+
+				err := client.VerifyJoined()
+				Expect(err).To(MatchError("0 of 2 expected servers are alive members, 1 required: missing consul-0.consul.bosh, consul-2.consul.bosh"))
+				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "agent-client.verify-joined.members.resolve-failed",
+						Error:  errors.New("lookup consul-2.consul.bosh: no such host"),
+						Data: []lager.Data{{
+							"member": "consul-2.consul.bosh",
+						}},
+					},
+				}))
+			})
+		})
+
+		Context("when the servers are in another datacenter", func() {
+			It("returns an error", func() {
+				client.ExpectedMembers = []string{"member1"}
+				client.Datacenter = "dc1"
+				consulAPIAgent.MembersReturns([]*api.AgentMember{
+					{Addr: "member1", Status: 1, Tags: map[string]string{"role": "consul", "dc": "dc2"}},
+				}, nil)
+
+				err := client.VerifyJoined()
+				Expect(err).To(MatchError(`server member1 is in datacenter "dc2", expected "dc1"`))
+				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "agent-client.verify-joined.members.datacenter-mismatch",
+						Error:  err,
+					},
+				}))
+			})
+		})
+
+		Context("when the agent joined a different gossip pool", func() {
+			It("returns an error", func() {
+				client.ExpectedMembers = []string{"member1", "member2"}
+				consulAPIAgent.MembersReturns([]*api.AgentMember{
+					{Addr: "member5", Status: 1, Tags: map[string]string{"role": "consul"}},
+					{Addr: "member6", Status: 1, Tags: map[string]string{"role": "consul"}},
+				}, nil)
+
+				err := client.VerifyJoined()
+				Expect(err).To(MatchError("joined a different gossip pool: none of the expected servers member1, member2 are members"))
+				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "agent-client.verify-joined.members.different-gossip-pool",
+						Error:  err,
+						Data: []lager.Data{{
+							"wan":     false,
+							"members": []string{"member5", "member6"},
+						}},
+					},
+				}))
+			})
+		})
+
 		Context("when the members are all strangers", func() {
 			It("returns an error", func() {
 				client.ExpectedMembers = []string{"member1", "member2", "member3"}
@@ -274,45 +435,42 @@ var _ = Describe("Client", func() {
 	})
 
 	Describe("JoinMembers", func() {
+		// consul 0.7.4 reports the errors of a join as the text of an HTTP 500
+		refusedErr := errors.New("Unexpected response code: 500 (1 error(s) occurred:\n\n* Failed to join 10.0.0.1: dial tcp 10.0.0.1:8301: getsockopt: connection refused)")
+
 		BeforeEach(func() {
 			client.ExpectedMembers = []string{"member1", "member2", "member3"}
 		})
 
 		Context("when we are able to successfully join each expected member", func() {
-			It("returns without errors", func() {
-				err := client.JoinMembers()
+			It("returns the outcome for each member", func() {
+				outcomes, err := client.JoinMembers()
 				Expect(err).NotTo(HaveOccurred())
+				Expect(outcomes).To(Equal([]agent.JoinOutcome{
+					{Member: "member1", Attempts: 1},
+					{Member: "member2", Attempts: 1},
+					{Member: "member3", Attempts: 1},
+				}))
 				Expect(consulAPIAgent.JoinCall.CallCount).To(Equal(3))
-				Expect(consulAPIAgent.JoinCall.Receives.Members).To(Equal(client.ExpectedMembers))
+				Expect(consulAPIAgent.JoinCall.Receives.Members).To(ConsistOf(client.ExpectedMembers))
 				Expect(consulAPIAgent.JoinCall.Receives.WAN).To(BeFalse())
-				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
-					{
-						Action: "agent-client.join-members.consul-api-agent.join",
-						Data: []lager.Data{
-							{
-								"member": "member1",
-							},
-						},
-					},
-					{
-						Action: "agent-client.join-members.consul-api-agent.join",
-						Data: []lager.Data{
-							{
-								"member": "member2",
-							},
-						},
-					},
-					{
+
+				for _, member := range client.ExpectedMembers {
+					Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
 						Action: "agent-client.join-members.consul-api-agent.join",
-						Data: []lager.Data{
-							{
-								"member": "member3",
-							},
-						},
-					},
-					{
-						Action: "agent-client.join-members.success",
-					},
+						Data: []lager.Data{{
+							"member":  member,
+							"attempt": 1,
+						}},
+					}))
+				}
+
+				messages := logger.Messages()
+				Expect(messages[len(messages)-1]).To(Equal(fakes.LoggerMessage{
+					Action: "agent-client.join-members.success",
+					Data: []lager.Data{{
+						"joined": 3,
+					}},
 				}))
 			})
 		})
@@ -325,20 +483,20 @@ var _ = Describe("Client", func() {
 					}
 					return nil
 				}
-				err := client.JoinMembers()
+				_, err := client.JoinMembers()
 				Expect(err).NotTo(HaveOccurred())
 				Expect(consulAPIAgent.JoinCall.CallCount).To(Equal(3))
-				Expect(consulAPIAgent.JoinCall.Receives.Members).To(Equal(client.ExpectedMembers))
+				Expect(consulAPIAgent.JoinCall.Receives.Members).To(ConsistOf(client.ExpectedMembers))
 				Expect(consulAPIAgent.JoinCall.Receives.WAN).To(BeFalse())
-				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
-					{
-						Action: "agent-client.join-members.consul-api-agent.join.unable-to-join",
-						Data: []lager.Data{{
-							"reason": "dial tcp 127.0.0.1:8500: i/o timeout",
-						}},
-					},
+				Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+					Action: "agent-client.join-members.consul-api-agent.join.unable-to-join",
+					Data: []lager.Data{{
+						"member": "member2",
+						"reason": "dial tcp 127.0.0.1:8500: i/o timeout",
+					}},
 				}))
 			})
+
 			It("returns without errors when there is a 'no route to host' message", func() {
 				consulAPIAgent.JoinCall.Stub = func(member string, wan bool) error {
 					if member == "member2" {
@@ -346,48 +504,235 @@ var _ = Describe("Client", func() {
 					}
 					return nil
 				}
-				err := client.JoinMembers()
+				_, err := client.JoinMembers()
 				Expect(err).NotTo(HaveOccurred())
 				Expect(consulAPIAgent.JoinCall.CallCount).To(Equal(3))
-				Expect(consulAPIAgent.JoinCall.Receives.Members).To(Equal(client.ExpectedMembers))
+				Expect(consulAPIAgent.JoinCall.Receives.Members).To(ConsistOf(client.ExpectedMembers))
 				Expect(consulAPIAgent.JoinCall.Receives.WAN).To(BeFalse())
-				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
-					{
-						Action: "agent-client.join-members.consul-api-agent.join.unable-to-join",
-						Data: []lager.Data{{
-							"reason": "dial tcp 127.0.0.1:8500: getsockopt: no route to host",
-						}},
-					},
+				Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+					Action: "agent-client.join-members.consul-api-agent.join.unable-to-join",
+					Data: []lager.Data{{
+						"member": "member2",
+						"reason": "dial tcp 127.0.0.1:8500: getsockopt: no route to host",
+					}},
 				}))
 			})
 
-			It("returns without errors", func() {
+			It("reports the unreachable member in its outcome", func() {
 				consulAPIAgent.JoinCall.Stub = func(member string, wan bool) error {
 					if member == "member2" {
-						return errors.New("dial tcp 127.0.0.1:8500: getsockopt: connection refused")
+						return refusedErr
 					}
 					return nil
 				}
-				err := client.JoinMembers()
+				outcomes, err := client.JoinMembers()
 				Expect(err).NotTo(HaveOccurred())
-				Expect(consulAPIAgent.JoinCall.CallCount).To(Equal(3))
-				Expect(consulAPIAgent.JoinCall.Receives.Members).To(Equal(client.ExpectedMembers))
-				Expect(consulAPIAgent.JoinCall.Receives.WAN).To(BeFalse())
-				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
-					{
-						Action: "agent-client.join-members.consul-api-agent.join.unable-to-join",
-						Data: []lager.Data{{
-							"reason": "dial tcp 127.0.0.1:8500: getsockopt: connection refused",
-						}},
+				Expect(outcomes).To(Equal([]agent.JoinOutcome{
+					{Member: "member1", Attempts: 1},
+					{Member: "member2", Attempts: 1, Err: &agent.UnreachableError{Member: "member2", Err: refusedErr}},
+					{Member: "member3", Attempts: 1},
+				}))
+				Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+					Action: "agent-client.join-members.consul-api-agent.join.unable-to-join",
+					Data: []lager.Data{{
+						"member": "member2",
+						"reason": refusedErr.Error(),
+					}},
+				}))
+			})
+		})
+
+		Context("when the api agent cannot be reached", func() {
+			It("reports the local agent as unavailable rather than the member as unreachable", func() {
+				dialErr := &url.Error{
+					Op:  "Put",
+					URL: "http://127.0.0.1:8500/v1/agent/join/member2",
+					Err: &net.OpError{
+						Op:  "dial",
+						Net: "tcp",
+						Err: os.NewSyscallError("connect", syscall.ECONNREFUSED),
 					},
+				}
+				consulAPIAgent.JoinCall.Stub = func(member string, wan bool) error {
+					if member == "member2" {
+						return dialErr
+					}
+					return nil
+				}
+
+				outcomes, err := client.JoinMembers()
+				Expect(err).To(Equal(&agent.AgentUnavailableError{Member: "member2", Err: dialErr}))
+				Expect(consulAPIAgent.JoinCall.CallCount).To(Equal(3))
+
+				Expect(outcomes[1].Err).NotTo(BeAssignableToTypeOf(&agent.UnreachableError{}))
+			})
+		})
+
+		Context("when a member cannot be reached within its join timeout", func() {
+			It("classifies the timeout reported by consul as unreachable", func() {
+				consulAPIAgent.JoinCall.Stub = func(member string, wan bool) error {
+					if member == "member2" {
+						return errors.New("Unexpected response code: 500 (1 error(s) occurred:\n\n* Failed to join 10.0.0.2: dial tcp 10.0.0.2:8301: i/o timeout)")
+					}
+					return nil
+				}
+
+				outcomes, err := client.JoinMembers()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(outcomes[1].Err).To(BeAssignableToTypeOf(&agent.UnreachableError{}))
+			})
+		})
+
+		Context("when members are retried", func() {
+			var clock *fakes.Clock
+
+			BeforeEach(func() {
+				clock = &fakes.Clock{}
+				client.Sleeper = clock
+				client.Join = agent.JoinConfig{
+					Attempts:       3,
+					InitialBackoff: time.Second,
+				}
+			})
+
+			It("retries an unreachable member with exponential backoff", func() {
+				var (
+					mutex    sync.Mutex
+					attempts int
+				)
+				consulAPIAgent.JoinCall.Stub = func(member string, wan bool) error {
+					if member != "member2" {
+						return nil
+					}
+
+					mutex.Lock()
+					defer mutex.Unlock()
+					attempts++
+					if attempts < 3 {
+						return refusedErr
+					}
+					return nil
+				}
+
+				outcomes, err := client.JoinMembers()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(outcomes[1]).To(Equal(agent.JoinOutcome{Member: "member2", Attempts: 3}))
+				Expect(consulAPIAgent.JoinCall.CallCount).To(Equal(5))
+				Expect(clock.SleepCall.Receives.Durations).To(Equal([]time.Duration{time.Second, 2 * time.Second}))
+			})
+
+			It("stops retrying an unreachable member after the configured attempts", func() {
+				consulAPIAgent.JoinCall.Returns.Error = refusedErr
+
+				outcomes, err := client.JoinMembers()
+				Expect(err).To(MatchError(agent.NoMembersToJoinError))
+				Expect(consulAPIAgent.JoinCall.CallCount).To(Equal(9))
+				for _, outcome := range outcomes {
+					Expect(outcome.Attempts).To(Equal(3))
+				}
+			})
+
+			It("does not retry a member that fails for another reason", func() {
+				consulAPIAgent.JoinCall.Returns.Error = errors.New("Unexpected response code: 403 (Permission denied)")
+
+				_, err := client.JoinMembers()
+
+				Expect(err).To(BeAssignableToTypeOf(&agent.ACLDeniedError{}))
+				Expect(consulAPIAgent.JoinCall.CallCount).To(Equal(3))
+				Expect(clock.SleepCall.CallCount).To(Equal(0))
+			})
+
+			It("treats an attempt that takes longer than the attempt timeout as unreachable", func() {
+				client.Join.Attempts = 1
+				client.Join.AttemptTimeout = 10 * time.Millisecond
+
+				blocked := make(chan struct{})
+				defer close(blocked)
+				consulAPIAgent.JoinCall.Stub = func(member string, wan bool) error {
+					if member == "member3" {
+						<-blocked
+					}
+					return nil
+				}
+
+				outcomes, err := client.JoinMembers()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(outcomes[2].Err).To(MatchError("member member3 is unreachable: join timed out after 10ms"))
+			})
+		})
+
+		Context("when a join policy is configured", func() {
+			BeforeEach(func() {
+				client.ExpectedMembers = []string{"member1", "member2", "member3", "member4", "member5"}
+				consulAPIAgent.JoinCall.Stub = func(member string, wan bool) error {
+					if member == "member4" || member == "member5" {
+						return refusedErr
+					}
+					return nil
+				}
+			})
+
+			It("succeeds when a majority of members were joined", func() {
+				client.Join.Policy = agent.JoinPolicyMajority
+
+				_, err := client.JoinMembers()
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns an insufficient members error when a majority of members were not joined", func() {
+				client.Join.Policy = agent.JoinPolicyMajority
+				client.ExpectedMembers = append(client.ExpectedMembers, "member6", "member7")
+				consulAPIAgent.JoinCall.Stub = func(member string, wan bool) error {
+					switch member {
+					case "member1", "member2", "member3":
+						return nil
+					}
+					return refusedErr
+				}
+
+				_, err := client.JoinMembers()
+				Expect(err).To(Equal(&agent.InsufficientMembersError{
+					Policy:   agent.JoinPolicyMajority,
+					Joined:   3,
+					Required: 4,
+				}))
+				Expect(err).To(MatchError(`joined 3 members, join policy "majority" requires 4`))
+				Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+					Action: "agent-client.join-members.insufficient-members",
+					Error:  err,
+				}))
+			})
+
+			It("returns an insufficient members error when not all members were joined", func() {
+				client.Join.Policy = agent.JoinPolicyAll
+
+				_, err := client.JoinMembers()
+				Expect(err).To(Equal(&agent.InsufficientMembersError{
+					Policy:   agent.JoinPolicyAll,
+					Joined:   3,
+					Required: 5,
+				}))
+			})
+
+			It("returns an insufficient members error rather than no members to join", func() {
+				client.Join.Policy = agent.JoinPolicyMajority
+				consulAPIAgent.JoinCall.Stub = nil
+				consulAPIAgent.JoinCall.Returns.Error = refusedErr
+
+				_, err := client.JoinMembers()
+				Expect(err).To(Equal(&agent.InsufficientMembersError{
+					Policy:   agent.JoinPolicyMajority,
+					Joined:   0,
+					Required: 3,
 				}))
 			})
 		})
 
 		Context("when we are unable to join any expected members", func() {
 			It("returns a no members to join error", func() {
-				consulAPIAgent.JoinCall.Returns.Error = errors.New("dial tcp 127.0.0.1:8500: getsockopt: connection refused")
-				err := client.JoinMembers()
+				consulAPIAgent.JoinCall.Returns.Error = refusedErr
+				_, err := client.JoinMembers()
 				Expect(err).To(MatchError(agent.NoMembersToJoinError))
 				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 					{
@@ -398,10 +743,47 @@ var _ = Describe("Client", func() {
 		})
 
 		Context("failure cases", func() {
+			It("returns a tls error when the certificate is not trusted", func() {
+				consulAPIAgent.JoinCall.Returns.Error = &url.Error{
+					Op:  "Put",
+					URL: "https://127.0.0.1:8500/v1/agent/join/member1",
+					Err: x509.UnknownAuthorityError{},
+				}
+
+				_, err := client.JoinMembers()
+
+				Expect(err).To(BeAssignableToTypeOf(&agent.TLSError{}))
+				Expect(err.(*agent.TLSError).Member).To(Equal("member1"))
+			})
+
+			It("returns a gossip key mismatch error when the member cannot decrypt gossip", func() {
+				consulAPIAgent.JoinCall.Returns.Error = errors.New("Unexpected response code: 500 (1 error(s) occurred:\n\n* Failed to join 10.0.0.1: No installed keys could decrypt the message)")
+
+				_, err := client.JoinMembers()
+
+				Expect(err).To(BeAssignableToTypeOf(&agent.GossipKeyMismatchError{}))
+				Expect(err.(*agent.GossipKeyMismatchError).Member).To(Equal("member1"))
+			})
+
+			It("returns an acl denied error when the token may not join", func() {
+				consulAPIAgent.JoinCall.Returns.Error = errors.New("Unexpected response code: 403 (Permission denied)")
+
+				_, err := client.JoinMembers()
+
+				Expect(err).To(BeAssignableToTypeOf(&agent.ACLDeniedError{}))
+				Expect(err).To(MatchError("acl denied joining member member1: Unexpected response code: 403 (Permission denied)"))
+				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "agent-client.join-members.consul-api-agent.join.failed",
+						Error:  err,
+					},
+				}))
+			})
+
 			Context("when client api agent join fails", func() {
 				It("returns an error", func() {
 					consulAPIAgent.JoinCall.Returns.Error = errors.New("failed to join")
-					err := client.JoinMembers()
+					_, err := client.JoinMembers()
 					Expect(err).To(MatchError("failed to join"))
 					Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 						{
@@ -470,7 +852,17 @@ var _ = Describe("Client", func() {
 		encryptedKeyPercent := "OLJdB+hlOnGSUEIR7S6ekA=="
 
 		It("installs the given keys", func() {
-			Expect(client.SetKeys([]string{encryptedKey1, "key2", "key%%"}, "invalid-kerying-file")).To(Succeed())
+			consulAPIOperator.KeyringListCall.Stub = func(*api.QueryOptions) ([]*api.KeyringResponse, error) {
+				if consulAPIOperator.KeyringListCall.CallCount == 1 {
+					return nil, nil
+				}
+
+				return []*api.KeyringResponse{
+					{WAN: false, Datacenter: "dc1", Keys: map[string]int{encryptedKey1: 3, encryptedKey2: 3, encryptedKeyPercent: 3}, NumNodes: 3},
+				}, nil
+			}
+
+			Expect(client.SetKeys([]string{encryptedKey1, "key2", "key%%"}, dataDir)).To(Succeed())
 
 			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 				{
@@ -530,34 +922,92 @@ var _ = Describe("Client", func() {
 						"key": encryptedKey1,
 					}},
 				},
+				{
+					Action: "agent-client.set-keys.verify-keys.request",
+					Data: []lager.Data{{
+						"pools": []agent.KeyringPool{agent.KeyringPoolLAN},
+					}},
+				},
 				{
 					Action: "agent-client.set-keys.success",
 				},
 			}))
 		})
 
-		Context("when the keys match the existing keys in the keyringfile", func() {
+		Context("when the keys were reconciled before", func() {
 			BeforeEach(func() {
-				ioutil.WriteFile(keyringFile, []byte(fmt.Sprintf(`["%s","%s"]`, encryptedKey1, encryptedKey2)), os.ModePerm)
+				consulAPIOperator.KeyringListCall.Returns.KeyringResponse = []*api.KeyringResponse{
+					{WAN: false, Datacenter: "dc1", Keys: map[string]int{encryptedKey1: 3, encryptedKey2: 3}, NumNodes: 3},
+				}
+
+				Expect(client.SetKeys([]string{encryptedKey1, encryptedKey2}, dataDir)).To(Succeed())
+				Expect(consulAPIOperator.KeyringInstallCall.CallCount).To(Equal(2))
 			})
-			It("does not use the API to list or set keys", func() {
-				Expect(client.SetKeys([]string{encryptedKey1, encryptedKey2}, keyringFile)).To(Succeed())
 
-				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
-					{
-						Action: "agent-client.set-keys.existing-keys-match",
-						Data: []lager.Data{{
-							"keyringFile":  keyringFile,
-							"keys":         []string{encryptedKey1, encryptedKey2},
-							"existingKeys": []string{encryptedKey1, encryptedKey2},
-						}},
-					},
+			It("records the hashes of the keys and when they were reconciled", func() {
+				contents, err := ioutil.ReadFile(filepath.Join(dataDir, "keyring.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).NotTo(ContainSubstring(encryptedKey1))
+
+				var state struct {
+					Keys         []string  `json:"keys"`
+					Pools        []string  `json:"pools"`
+					ReconciledAt time.Time `json:"reconciled_at"`
+				}
+				Expect(json.Unmarshal(contents, &state)).To(Succeed())
+				Expect(state.Keys).To(Equal([]string{
+					fmt.Sprintf("%x", sha256.Sum256([]byte(encryptedKey1))),
+					fmt.Sprintf("%x", sha256.Sum256([]byte(encryptedKey2))),
 				}))
+				Expect(state.Pools).To(Equal([]string{"lan"}))
+				Expect(state.ReconciledAt).To(BeTemporally("~", time.Now(), time.Minute))
 			})
-		})
 
-		Context("when there are extra keys", func() {
-			It("removes extra keys", func() {
+			It("does not set the keys again while the agent reports the same keys", func() {
+				Expect(client.SetKeys([]string{encryptedKey1, encryptedKey2}, dataDir)).To(Succeed())
+
+				Expect(consulAPIOperator.KeyringListCall.CallCount).To(Equal(3))
+				Expect(consulAPIOperator.KeyringInstallCall.CallCount).To(Equal(2))
+				Expect(consulAPIOperator.KeyringUseCall.CallCount).To(Equal(1))
+
+				messages := logger.Messages()
+				Expect(messages[len(messages)-1].Action).To(Equal("agent-client.set-keys.existing-keys-match"))
+			})
+
+			It("sets the keys again when the primary key changes", func() {
+				Expect(client.SetKeys([]string{encryptedKey2, encryptedKey1}, dataDir)).To(Succeed())
+
+				Expect(consulAPIOperator.KeyringUseCall.CallCount).To(Equal(2))
+				Expect(consulAPIOperator.KeyringUseCall.Receives.Key).To(Equal(encryptedKey2))
+			})
+
+			It("sets the keys again when the agent reports different keys", func() {
+				consulAPIOperator.KeyringListCall.Returns.KeyringResponse[0].Keys["key3"] = 3
+
+				Expect(client.SetKeys([]string{encryptedKey1, encryptedKey2}, dataDir)).To(Succeed())
+				Expect(consulAPIOperator.KeyringRemoveCall.Receives.Key).To(Equal("key3"))
+				Expect(consulAPIOperator.KeyringInstallCall.CallCount).To(Equal(4))
+			})
+
+			It("sets the keys again and reports a key that is missing from some nodes", func() {
+				Expect(os.Remove(filepath.Join(dataDir, "keyring.json"))).To(Succeed())
+				consulAPIOperator.KeyringListCall.Returns.KeyringResponse[0].Keys[encryptedKey2] = 2
+
+				Expect(client.SetKeys([]string{encryptedKey1, encryptedKey2}, dataDir)).To(Succeed())
+				Expect(consulAPIOperator.KeyringInstallCall.CallCount).To(Equal(4))
+				Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+					Action: "agent-client.set-keys.verify-keys.incomplete",
+					Error:  fmt.Errorf("key %s is installed on 2 of 3 nodes in the lan pool of dc1", encryptedKey2),
+					Data: []lager.Data{{
+						"pools": []agent.KeyringPool{agent.KeyringPoolLAN},
+					}},
+				}))
+				Expect(filepath.Join(dataDir, "keyring.json")).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("when there are extra keys", func() {
+			It("removes extra keys", func() {
 				consulAPIOperator.KeyringListCall.Returns.KeyringResponse = []*api.KeyringResponse{
 					&api.KeyringResponse{
 						WAN: false,
@@ -568,7 +1018,7 @@ var _ = Describe("Client", func() {
 					},
 				}
 
-				Expect(client.SetKeys([]string{"key1", "key2"}, keyringFile)).To(Succeed())
+				Expect(client.SetKeys([]string{"key1", "key2"}, dataDir)).To(Succeed())
 
 				msgs := logger.Messages()
 				Expect(len(msgs)).Should(BeNumerically(">=", 2))
@@ -645,6 +1095,12 @@ var _ = Describe("Client", func() {
 							"key": encryptedKey1,
 						}},
 					},
+					{
+						Action: "agent-client.set-keys.verify-keys.request",
+						Data: []lager.Data{{
+							"pools": []agent.KeyringPool{agent.KeyringPoolLAN},
+						}},
+					},
 					{
 						Action: "agent-client.set-keys.success",
 					},
@@ -652,10 +1108,73 @@ var _ = Describe("Client", func() {
 			})
 		})
 
+		Context("when the agent is a server joined to the WAN pool", func() {
+			BeforeEach(func() {
+				client.JoinedWAN = true
+				consulAPIOperator.KeyringListCall.Returns.KeyringResponse = []*api.KeyringResponse{
+					{WAN: true, Datacenter: "dc1", Keys: map[string]int{encryptedKey1: 3, "wan-key": 3}, NumNodes: 3},
+					{WAN: false, Datacenter: "dc1", Keys: map[string]int{encryptedKey1: 5, "lan-key": 5}, NumNodes: 5},
+				}
+			})
+
+			It("reconciles the keys of both pools", func() {
+				Expect(client.SetKeys([]string{encryptedKey1}, dataDir)).To(Succeed())
+
+				Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+					Action: "agent-client.set-keys.remove-key.request",
+					Data:   []lager.Data{{"key": "wan-key"}},
+				}))
+				Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+					Action: "agent-client.set-keys.remove-key.request",
+					Data:   []lager.Data{{"key": "lan-key"}},
+				}))
+				Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+					Action: "agent-client.set-keys.verify-keys.request",
+					Data: []lager.Data{{
+						"pools": []agent.KeyringPool{agent.KeyringPoolLAN, agent.KeyringPoolWAN},
+					}},
+				}))
+			})
+
+			It("reports a key that is not installed on every node of the WAN pool", func() {
+				consulAPIOperator.KeyringListCall.Returns.KeyringResponse[0].Keys[encryptedKey1] = 2
+
+				Expect(client.SetKeys([]string{encryptedKey1}, dataDir)).To(Succeed())
+				Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+					Action: "agent-client.set-keys.verify-keys.incomplete",
+					Error:  fmt.Errorf("key %s is installed on 2 of 3 nodes in the wan pool of dc1", encryptedKey1),
+					Data: []lager.Data{{
+						"pools": []agent.KeyringPool{agent.KeyringPoolLAN, agent.KeyringPoolWAN},
+					}},
+				}))
+			})
+
+			It("does not verify the WAN pool when the agent is not joined to it", func() {
+				client.JoinedWAN = false
+				consulAPIOperator.KeyringListCall.Returns.KeyringResponse[0].Keys[encryptedKey1] = 2
+
+				Expect(client.SetKeys([]string{encryptedKey1}, dataDir)).To(Succeed())
+				Expect(logger.Messages()).NotTo(ContainElement(fakes.LoggerMessage{
+					Action: "agent-client.set-keys.remove-key.request",
+					Data:   []lager.Data{{"key": "wan-key"}},
+				}))
+			})
+
+			It("sets the keys again when only the LAN pool was reconciled before", func() {
+				client.JoinedWAN = false
+				Expect(client.SetKeys([]string{encryptedKey1}, dataDir)).To(Succeed())
+				Expect(consulAPIOperator.KeyringUseCall.CallCount).To(Equal(1))
+
+				client.JoinedWAN = true
+				Expect(client.SetKeys([]string{encryptedKey1}, dataDir)).To(Succeed())
+				Expect(consulAPIOperator.KeyringUseCall.CallCount).To(Equal(2))
+			})
+		})
+
 		Context("failure cases", func() {
 			Context("when provided with a nil slice", func() {
 				It("returns a reasonably named error", func() {
-					Expect(client.SetKeys(nil, keyringFile)).To(MatchError("must provide a non-nil slice of keys"))
+					Expect(client.SetKeys(nil, dataDir)).To(MatchError("must provide a non-nil slice of keys"))
 					Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 						{
 							Action: "agent-client.set-keys.nil-slice",
@@ -667,7 +1186,7 @@ var _ = Describe("Client", func() {
 
 			Context("when provided with an empty slice", func() {
 				It("returns a reasonably named error", func() {
-					Expect(client.SetKeys([]string{}, keyringFile)).To(MatchError("must provide a non-empty slice of keys"))
+					Expect(client.SetKeys([]string{}, dataDir)).To(MatchError("must provide a non-empty slice of keys"))
 					Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 						{
 							Action: "agent-client.set-keys.empty-slice",
@@ -681,7 +1200,7 @@ var _ = Describe("Client", func() {
 				It("returns the error", func() {
 					consulAPIOperator.KeyringListCall.Returns.Error = errors.New("list keys error")
 
-					Expect(client.SetKeys([]string{"key1"}, keyringFile)).To(MatchError("list keys error"))
+					Expect(client.SetKeys([]string{"key1"}, dataDir)).To(MatchError("list keys error"))
 					Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 						{
 							Action: "agent-client.set-keys.list-keys.request",
@@ -706,7 +1225,7 @@ var _ = Describe("Client", func() {
 						},
 					}
 
-					Expect(client.SetKeys([]string{"key1"}, keyringFile)).To(MatchError("remove key error"))
+					Expect(client.SetKeys([]string{"key1"}, dataDir)).To(MatchError("remove key error"))
 					Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 						{
 							Action: "agent-client.set-keys.list-keys.request",
@@ -738,7 +1257,7 @@ var _ = Describe("Client", func() {
 				It("returns the error", func() {
 					consulAPIOperator.KeyringInstallCall.Returns.Error = errors.New("install key error")
 
-					Expect(client.SetKeys([]string{"key1"}, keyringFile)).To(MatchError("install key error"))
+					Expect(client.SetKeys([]string{"key1"}, dataDir)).To(MatchError("install key error"))
 					Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 						{
 							Action: "agent-client.set-keys.list-keys.request",
@@ -770,7 +1289,7 @@ var _ = Describe("Client", func() {
 				It("returns the error", func() {
 					consulAPIOperator.KeyringUseCall.Returns.Error = errors.New("use key error")
 
-					Expect(client.SetKeys([]string{"key1"}, keyringFile)).To(MatchError("use key error"))
+					Expect(client.SetKeys([]string{"key1"}, dataDir)).To(MatchError("use key error"))
 					Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 						{
 							Action: "agent-client.set-keys.list-keys.request",
@@ -839,6 +1358,80 @@ var _ = Describe("Client", func() {
 		})
 	})
 
+	Describe("ListPoolKeys", func() {
+		BeforeEach(func() {
+			consulAPIOperator.KeyringListCall.Returns.KeyringResponse = []*api.KeyringResponse{
+				{WAN: true, Keys: map[string]int{"key-1": 3, "wan-key": 3}, NumNodes: 3},
+				{WAN: false, Keys: map[string]int{"key-1": 5, "lan-key": 5}, NumNodes: 5},
+			}
+		})
+
+		It("returns the keys of the given pools", func() {
+			keys, err := client.ListPoolKeys(agent.KeyringPoolWAN)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(ConsistOf("key-1", "wan-key"))
+
+			keys, err = client.ListPoolKeys(agent.KeyringPoolLAN, agent.KeyringPoolWAN)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(keys).To(ConsistOf("key-1", "wan-key", "lan-key"))
+		})
+
+		It("returns an error when keyringList fails", func() {
+			consulAPIOperator.KeyringListCall.Returns.Error = errors.New("keyring list failed")
+
+			_, err := client.ListPoolKeys(agent.KeyringPoolLAN)
+			Expect(err).To(MatchError("keyring list failed"))
+		})
+	})
+
+	Describe("VerifyKeysInstalled", func() {
+		BeforeEach(func() {
+			consulAPIOperator.KeyringListCall.Returns.KeyringResponse = []*api.KeyringResponse{
+				{WAN: true, Datacenter: "dc1", Keys: map[string]int{"key-1": 3, "key-2": 1}, NumNodes: 3},
+				{WAN: false, Datacenter: "dc1", Keys: map[string]int{"key-1": 5, "key-2": 5}, NumNodes: 5},
+			}
+		})
+
+		It("succeeds when the keys are installed on every node of the given pools", func() {
+			Expect(client.VerifyKeysInstalled([]string{"key-1", "key-2"}, agent.KeyringPoolLAN)).To(Succeed())
+			Expect(client.VerifyKeysInstalled([]string{"key-1"}, agent.KeyringPoolLAN, agent.KeyringPoolWAN)).To(Succeed())
+		})
+
+		It("returns an error when a key is missing from some nodes of a pool", func() {
+			err := client.VerifyKeysInstalled([]string{"key-1", "key-2"}, agent.KeyringPoolLAN, agent.KeyringPoolWAN)
+			Expect(err).To(MatchError("key key-2 is installed on 1 of 3 nodes in the wan pool of dc1"))
+		})
+
+		It("returns an error when a given pool is not reported", func() {
+			consulAPIOperator.KeyringListCall.Returns.KeyringResponse = []*api.KeyringResponse{}
+
+			err := client.VerifyKeysInstalled([]string{"key-1"}, agent.KeyringPoolLAN)
+			Expect(err).To(MatchError("keyring list did not report the lan pool"))
+		})
+	})
+
+	Describe("Keyring", func() {
+		It("returns the keyring of the LAN and WAN pools", func() {
+			keyring := []*api.KeyringResponse{
+				{WAN: true, Datacenter: "dc1", Keys: map[string]int{"key-1": 3}, NumNodes: 3},
+				{WAN: false, Datacenter: "dc1", Keys: map[string]int{"key-1": 5, "key-2": 2}, NumNodes: 5},
+			}
+			consulAPIOperator.KeyringListCall.Returns.KeyringResponse = keyring
+
+			responses, err := client.Keyring()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(consulAPIOperator.KeyringListCall.CallCount).To(Equal(1))
+			Expect(responses).To(Equal(keyring))
+		})
+
+		It("returns an error when keyringList fails", func() {
+			consulAPIOperator.KeyringListCall.Returns.Error = errors.New("keyring list failed")
+
+			_, err := client.Keyring()
+			Expect(err).To(MatchError("keyring list failed"))
+		})
+	})
+
 	Describe("InstallKey", func() {
 		It("makes the call to InstallKey", func() {
 			err := client.InstallKey("key-1")
@@ -919,6 +1512,36 @@ var _ = Describe("Client", func() {
 		})
 	})
 
+	Describe("DeregisterService", func() {
+		It("deregisters the service from the agent", func() {
+			Expect(client.DeregisterService("router")).To(Succeed())
+			Expect(consulAPIAgent.ServiceDeregisterCall.Receives.ServiceIDs).To(Equal([]string{"router"}))
+			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "agent-client.deregister-service.deregister.request",
+					Data:   []lager.Data{{"service": "router"}},
+				},
+				{
+					Action: "agent-client.deregister-service.deregister.response",
+					Data:   []lager.Data{{"service": "router"}},
+				},
+			}))
+		})
+
+		Context("when consul's api agent deregister fails", func() {
+			It("returns an error", func() {
+				consulAPIAgent.ServiceDeregisterCall.Returns.Error = errors.New("failed to deregister")
+
+				Expect(client.DeregisterService("router")).To(MatchError("failed to deregister"))
+				Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+					Action: "agent-client.deregister-service.deregister.request.failed",
+					Error:  errors.New("failed to deregister"),
+					Data:   []lager.Data{{"service": "router"}},
+				}))
+			})
+		})
+	})
+
 	Describe("RaftStats", func() {
 		BeforeEach(func() {
 			consulAPIAgent.SelfCall.Returns.SelfInfo = map[string]map[string]interface{}{
@@ -951,4 +1574,347 @@ var _ = Describe("Client", func() {
 			})
 		})
 	})
+
+	Describe("NodeName", func() {
+		BeforeEach(func() {
+			consulAPIAgent.SelfCall.Returns.SelfInfo = map[string]map[string]interface{}{
+				"Config": map[string]interface{}{
+					"NodeName": "consul-0",
+				},
+			}
+		})
+
+		It("returns the config.node_name from /v1/agent/self", func() {
+			nodeName, err := client.NodeName()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nodeName).To(Equal("consul-0"))
+		})
+
+		Context("failure cases", func() {
+			It("returns an error when it fails to query self", func() {
+				consulAPIAgent.SelfCall.Returns.Error = errors.New("failed to query self")
+
+				_, err := client.NodeName()
+				Expect(err).To(MatchError("failed to query self"))
+			})
+
+			It("returns an error when the node name is missing", func() {
+				consulAPIAgent.SelfCall.Returns.SelfInfo = map[string]map[string]interface{}{}
+
+				_, err := client.NodeName()
+				Expect(err).To(MatchError("agent did not report a node name"))
+			})
+		})
+	})
+
+	Describe("RaftConfiguration", func() {
+		It("returns the raft configuration, allowing stale reads", func() {
+			consulAPIOperator.RaftGetConfigurationCall.Returns.RaftConfiguration = &api.RaftConfiguration{
+				Servers: []*api.RaftServer{
+					{Node: "consul-0", Address: "10.0.0.1:8300", Voter: true},
+				},
+			}
+
+			raftConfiguration, err := client.RaftConfiguration()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(raftConfiguration.Servers).To(HaveLen(1))
+			Expect(raftConfiguration.Servers[0].Node).To(Equal("consul-0"))
+			Expect(consulAPIOperator.RaftGetConfigurationCall.Receives.QueryOptions).To(Equal(&api.QueryOptions{AllowStale: true}))
+		})
+
+		Context("when the request fails", func() {
+			It("returns an error", func() {
+				consulAPIOperator.RaftGetConfigurationCall.Returns.Error = errors.New("no leader")
+
+				_, err := client.RaftConfiguration()
+				Expect(err).To(MatchError("no leader"))
+			})
+		})
+	})
+
+	Describe("Address", func() {
+		It("returns the member.addr from /v1/agent/self", func() {
+			consulAPIAgent.SelfCall.Returns.SelfInfo = map[string]map[string]interface{}{
+				"Member": map[string]interface{}{
+					"Addr": "10.0.0.1",
+				},
+			}
+
+			address, err := client.Address()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(address).To(Equal("10.0.0.1"))
+		})
+
+		Context("failure cases", func() {
+			It("returns an error when it fails to query self", func() {
+				consulAPIAgent.SelfCall.Returns.Error = errors.New("failed to query self")
+
+				_, err := client.Address()
+				Expect(err).To(MatchError("failed to query self"))
+			})
+
+			It("returns an error when the address is missing", func() {
+				consulAPIAgent.SelfCall.Returns.SelfInfo = map[string]map[string]interface{}{}
+
+				_, err := client.Address()
+				Expect(err).To(MatchError("agent did not report an address"))
+			})
+		})
+	})
+
+	Describe("RaftRemovePeer", func() {
+		It("removes the raft peer with the given address", func() {
+			Expect(client.RaftRemovePeer("10.0.0.1:8300")).To(Succeed())
+			Expect(consulAPIOperator.RaftRemovePeerByAddressCall.Receives.Address).To(Equal("10.0.0.1:8300"))
+		})
+
+		Context("when the request fails", func() {
+			It("returns an error", func() {
+				consulAPIOperator.RaftRemovePeerByAddressCall.Returns.Error = errors.New("not leader")
+
+				Expect(client.RaftRemovePeer("10.0.0.1:8300")).To(MatchError("not leader"))
+			})
+		})
+	})
+
+	Describe("VerifyRaftPeers", func() {
+		BeforeEach(func() {
+			client.ExpectedMembers = []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+			consulAPIAgent.SelfCall.Returns.SelfInfo = map[string]map[string]interface{}{
+				"Member": map[string]interface{}{
+					"Addr": "10.0.0.1",
+				},
+			}
+			consulAPIOperator.RaftGetConfigurationCall.Returns.RaftConfiguration = &api.RaftConfiguration{
+				Servers: []*api.RaftServer{
+					{Node: "consul-0", Address: "10.0.0.1:8300", Voter: true},
+					{Node: "consul-1", Address: "10.0.0.2:8300", Voter: true},
+					{Node: "consul-2", Address: "10.0.0.3:8300", Voter: true},
+				},
+			}
+			consulAPIAgent.MembersReturns([]*api.AgentMember{
+				{Name: "consul-0", Addr: "10.0.0.1", Status: 1},
+				{Name: "consul-1", Addr: "10.0.0.2", Status: 1},
+				{Name: "consul-2", Addr: "10.0.0.3", Status: 1},
+			}, nil)
+		})
+
+		It("succeeds when the peers are the expected members", func() {
+			Expect(client.VerifyRaftPeers()).To(Succeed())
+			Expect(consulAPIOperator.RaftRemovePeerByAddressCall.CallCount).To(Equal(0))
+
+			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "agent-client.verify-raft-peers.configuration.request",
+				},
+				{
+					Action: "agent-client.verify-raft-peers.configuration.response",
+					Data: []lager.Data{{
+						"peers": []string{"10.0.0.1:8300", "10.0.0.2:8300", "10.0.0.3:8300"},
+					}},
+				},
+				{
+					Action: "agent-client.verify-raft-peers.verified",
+				},
+			}))
+		})
+
+		It("returns an error when this server is not a peer", func() {
+			consulAPIAgent.SelfCall.Returns.SelfInfo["Member"]["Addr"] = "10.0.0.4"
+			client.ExpectedMembers = append(client.ExpectedMembers, "10.0.0.4")
+
+			Expect(client.VerifyRaftPeers()).To(MatchError("10.0.0.4 is not a raft peer"))
+		})
+
+		It("returns an error when this server is not a voter", func() {
+			consulAPIOperator.RaftGetConfigurationCall.Returns.RaftConfiguration.Servers[0].Voter = false
+
+			err := client.VerifyRaftPeers()
+			Expect(err).To(MatchError("10.0.0.1 is not a raft voter"))
+			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "agent-client.verify-raft-peers.not-a-voter",
+					Error:  err,
+				},
+			}))
+		})
+
+		It("returns an error when a running expected server is not a peer", func() {
+			client.ExpectedMembers = append(client.ExpectedMembers, "10.0.0.4")
+			consulAPIAgent.MembersReturns([]*api.AgentMember{
+				{Name: "consul-0", Addr: "10.0.0.1", Status: 1},
+				{Name: "consul-1", Addr: "10.0.0.2", Status: 1},
+				{Name: "consul-2", Addr: "10.0.0.3", Status: 1},
+				{Name: "consul-3", Addr: "10.0.0.4", Status: 1},
+			}, nil)
+
+			Expect(client.VerifyRaftPeers()).To(MatchError("expected servers are not raft peers: 10.0.0.4"))
+		})
+
+		It("ignores expected servers that are not running yet", func() {
+			client.ExpectedMembers = append(client.ExpectedMembers, "10.0.0.4")
+
+			Expect(client.VerifyRaftPeers()).To(Succeed())
+		})
+
+		Context("when expected servers are hostnames", func() {
+			BeforeEach(func() {
+				client.ExpectedMembers = []string{"consul-0.consul.bosh", "consul-1.consul.bosh", "consul-2.consul.bosh"}
+				client.LookupHost = func(host string) ([]string, error) {
+					switch host {
+					case "consul-0.consul.bosh":
+						return []string{"10.0.0.1"}, nil
+					case "consul-1.consul.bosh":
+						return []string{"10.0.0.2"}, nil
+					case "consul-2.consul.bosh":
+						return []string{"10.0.0.3"}, nil
+					}
+					return nil, fmt.Errorf("lookup %s: no such host", host)
+				}
+				consulAPIOperator.RaftGetConfigurationCall.Returns.RaftConfiguration.Servers[1].Node = "node-b"
+				consulAPIOperator.RaftGetConfigurationCall.Returns.RaftConfiguration.Servers[2].Node = "node-c"
+			})
+
+			It("matches the peers by their resolved addresses", func() {
+				Expect(client.VerifyRaftPeers()).To(Succeed())
+			})
+
+			It("returns an error without removing peers when a hostname cannot be resolved", func() {
+				client.ExpectedMembers[2] = "consul-3.consul.bosh"
+				client.RemoveStalePeers = true
+
+				err := client.VerifyRaftPeers()
+				Expect(err).To(MatchError("lookup consul-3.consul.bosh: no such host"))
+				Expect(consulAPIOperator.RaftRemovePeerByAddressCall.CallCount).To(Equal(0))
+				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "agent-client.verify-raft-peers.resolve-failed",
+						Error:  err,
+						Data: []lager.Data{{
+							"member": "consul-3.consul.bosh",
+						}},
+					},
+				}))
+			})
+		})
+
+		Context("when a peer is not expected", func() {
+			BeforeEach(func() {
+				client.ExpectedMembers = []string{"10.0.0.1", "10.0.0.2"}
+			})
+
+			It("returns an error when the peer is still alive", func() {
+				client.RemoveStalePeers = true
+
+				Expect(client.VerifyRaftPeers()).To(MatchError("unexpected raft peers: 10.0.0.3:8300"))
+				Expect(consulAPIOperator.RaftRemovePeerByAddressCall.CallCount).To(Equal(0))
+			})
+
+			Context("when the peer is no longer alive", func() {
+				BeforeEach(func() {
+					consulAPIAgent.MembersReturns([]*api.AgentMember{
+						{Name: "consul-0", Addr: "10.0.0.1", Status: 1},
+						{Name: "consul-1", Addr: "10.0.0.2", Status: 1},
+						{Name: "consul-2", Addr: "10.0.0.3", Status: 4},
+					}, nil)
+				})
+
+				It("removes the stale peer", func() {
+					client.RemoveStalePeers = true
+
+					Expect(client.VerifyRaftPeers()).To(Succeed())
+					Expect(consulAPIOperator.RaftRemovePeerByAddressCall.Receives.Address).To(Equal("10.0.0.3:8300"))
+					Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+						{
+							Action: "agent-client.verify-raft-peers.remove-stale-peer",
+							Data: []lager.Data{{
+								"peer": "10.0.0.3:8300",
+								"node": "consul-2",
+							}},
+						},
+						{
+							Action: "agent-client.verify-raft-peers.verified",
+						},
+					}))
+				})
+
+				It("returns an error when removing the stale peer fails", func() {
+					client.RemoveStalePeers = true
+					consulAPIOperator.RaftRemovePeerByAddressCall.Returns.Error = errors.New("not leader")
+
+					Expect(client.VerifyRaftPeers()).To(MatchError("not leader"))
+				})
+
+				It("logs the stale peer without failing when stale peers may not be removed", func() {
+					Expect(client.VerifyRaftPeers()).To(Succeed())
+					Expect(consulAPIOperator.RaftRemovePeerByAddressCall.CallCount).To(Equal(0))
+					Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+						{
+							Action: "agent-client.verify-raft-peers.stale-peer",
+							Data: []lager.Data{{
+								"peer": "10.0.0.3:8300",
+								"node": "consul-2",
+							}},
+						},
+						{
+							Action: "agent-client.verify-raft-peers.verified",
+						},
+					}))
+				})
+			})
+		})
+
+		Context("when the raft configuration cannot be read", func() {
+			It("returns an error", func() {
+				consulAPIOperator.RaftGetConfigurationCall.Returns.Error = errors.New("no leader")
+
+				Expect(client.VerifyRaftPeers()).To(MatchError("no leader"))
+				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "agent-client.verify-raft-peers.configuration.request.failed",
+						Error:  errors.New("no leader"),
+					},
+				}))
+			})
+		})
+	})
+
+	Describe("ACLToken", func() {
+		It("reads the token with the given acl token", func() {
+			consulAPIACL.InfoCall.Returns.ACLEntry = &api.ACLEntry{ID: "router-token", Name: "router"}
+
+			entry, err := client.ACLToken("router-token", "master-token")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entry).To(Equal(&api.ACLEntry{ID: "router-token", Name: "router"}))
+			Expect(consulAPIACL.InfoCall.Receives.ID).To(Equal("router-token"))
+			Expect(consulAPIACL.InfoCall.Receives.QueryOptions).To(Equal(&api.QueryOptions{Token: "master-token"}))
+		})
+
+		Context("when the request fails", func() {
+			It("returns an error", func() {
+				consulAPIACL.InfoCall.Returns.Error = errors.New("ACL not found")
+
+				_, err := client.ACLToken("router-token", "master-token")
+				Expect(err).To(MatchError("ACL not found"))
+			})
+		})
+	})
+
+	Describe("SetACLToken", func() {
+		It("writes the token with the given acl token", func() {
+			entry := &api.ACLEntry{ID: "router-token", Name: "router", Type: "client"}
+
+			Expect(client.SetACLToken(entry, "master-token")).To(Succeed())
+			Expect(consulAPIACL.UpdateCall.Receives.ACLEntry).To(Equal(entry))
+			Expect(consulAPIACL.UpdateCall.Receives.WriteOptions).To(Equal(&api.WriteOptions{Token: "master-token"}))
+		})
+
+		Context("when the request fails", func() {
+			It("returns an error", func() {
+				consulAPIACL.UpdateCall.Returns.Error = errors.New("Permission denied")
+
+				Expect(client.SetACLToken(&api.ACLEntry{}, "master-token")).To(MatchError("Permission denied"))
+			})
+		})
+	})
 })
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+type JoinPolicy string
+
+const (
+	JoinPolicyOne      JoinPolicy = "one"
+	JoinPolicyMajority JoinPolicy = "majority"
+	JoinPolicyAll      JoinPolicy = "all"
+)
+
+// required returns how many of members must be joined for the policy to be
+// met. An unknown policy is treated as JoinPolicyOne.
+func (p JoinPolicy) required(members int) int {
+	switch p {
+	case JoinPolicyMajority:
+		return members/2 + 1
+	case JoinPolicyAll:
+		return members
+	default:
+		return 1
+	}
+}
+
+type JoinConfig struct {
+	Policy         JoinPolicy
+	Attempts       int
+	AttemptTimeout time.Duration
+	InitialBackoff time.Duration
+}
+
+type sleeper interface {
+	Sleep(time.Duration)
+}
+
+// JoinOutcome is the result of joining a single member. Err is nil when the
+// member was joined.
+type JoinOutcome struct {
+	Member   string
+	Attempts int
+	Err      error
+}
+
+// InsufficientMembersError is returned when fewer members were joined than
+// the join policy requires.
+type InsufficientMembersError struct {
+	Policy   JoinPolicy
+	Joined   int
+	Required int
+}
+
+func (e *InsufficientMembersError) Error() string {
+	return fmt.Sprintf("joined %d members, join policy %q requires %d", e.Joined, e.Policy, e.Required)
+}
+
+// joinMember joins member, retrying with exponential backoff while it is
+// unreachable. Each attempt that takes longer than AttemptTimeout is treated
+// as unreachable.
+func (c Client) joinMember(member string) JoinOutcome {
+	attempts := c.Join.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	outcome := JoinOutcome{Member: member}
+	backoff := c.Join.InitialBackoff
+
+	for outcome.Attempts < attempts {
+		if outcome.Attempts > 0 {
+			c.Sleeper.Sleep(backoff)
+			backoff *= 2
+		}
+
+		outcome.Attempts++
+		c.Logger.Info("agent-client.join-members.consul-api-agent.join", lager.Data{
+			"member":  member,
+			"attempt": outcome.Attempts,
+		})
+
+		outcome.Err = c.joinWithTimeout(member)
+		if outcome.Err == nil {
+			return outcome
+		}
+
+		unreachableErr, ok := outcome.Err.(*UnreachableError)
+		if !ok {
+			return outcome
+		}
+
+		c.Logger.Info("agent-client.join-members.consul-api-agent.join.unable-to-join", lager.Data{
+			"member": member,
+			"reason": unreachableErr.Err.Error(),
+		})
+	}
+
+	return outcome
+}
+
+// joinWithTimeout gives up on a join after AttemptTimeout. The vendored consul
+// api cannot cancel the request, and consul carries on with a join once it has
+// started, so the goroutine only ends when the agent answers and the member may
+// still be joined after the attempt timed out. That is harmless, as joining is
+// idempotent and the members are verified once every join has returned.
+func (c Client) joinWithTimeout(member string) error {
+	if c.Join.AttemptTimeout <= 0 {
+		if err := c.ConsulAPIAgent.Join(member, false); err != nil {
+			return classifyJoinError(member, err)
+		}
+		return nil
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- c.ConsulAPIAgent.Join(member, false)
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			return classifyJoinError(member, err)
+		}
+		return nil
+	case <-time.After(c.Join.AttemptTimeout):
+		return &UnreachableError{
+			Member: member,
+			Err:    fmt.Errorf("join timed out after %s", c.Join.AttemptTimeout),
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// UnreachableError is returned for a member that could not be reached. The
+// member is skipped, and JoinMembers returns NoMembersToJoinError when no
+// member can be reached.
+type UnreachableError struct {
+	Member string
+	Err    error
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("member %s is unreachable: %s", e.Member, e.Err)
+}
+
+// TLSError is returned when the TLS handshake with the local agent fails. It
+// is not retried, as the certificates will not change until the next deploy.
+type TLSError struct {
+	Member string
+	Err    error
+}
+
+func (e *TLSError) Error() string {
+	return fmt.Sprintf("tls failure joining member %s: %s", e.Member, e.Err)
+}
+
+// GossipKeyMismatchError is returned when a member cannot decrypt gossip
+// traffic. It is retried until the timeout, as it is expected while encrypt
+// keys are being rotated.
+type GossipKeyMismatchError struct {
+	Member string
+	Err    error
+}
+
+func (e *GossipKeyMismatchError) Error() string {
+	return fmt.Sprintf("gossip key mismatch joining member %s: %s", e.Member, e.Err)
+}
+
+// ACLDeniedError is returned when the agent's ACL token is not allowed to
+// join. It is not retried.
+type ACLDeniedError struct {
+	Member string
+	Err    error
+}
+
+func (e *ACLDeniedError) Error() string {
+	return fmt.Sprintf("acl denied joining member %s: %s", e.Member, e.Err)
+}
+
+// AgentUnavailableError is returned when the request asking the local agent
+// to join a member fails, which says nothing about the member. It is not
+// retried.
+type AgentUnavailableError struct {
+	Member string
+	Err    error
+}
+
+func (e *AgentUnavailableError) Error() string {
+	return fmt.Sprintf("local agent unavailable joining member %s: %s", e.Member, e.Err)
+}
+
+// Consul reports the errors it hits joining a member as the text of an HTTP
+// 500 response, so they can only be classified by their message. Joins are
+// gossiped without TLS, so TLS failures only come from the request to the
+// local agent.
+var (
+	unreachableMessages = []string{
+		"connection refused",
+		"no route to host",
+		"network is unreachable",
+		"i/o timeout",
+		"actively refused it",
+		"did not properly respond after a period of time",
+		"unreachable host",
+	}
+
+	gossipKeyMismatchMessages = []string{
+		"no installed keys could decrypt the message",
+		"remote state is encrypted and encryption is not configured",
+		"encryption is configured but remote state is not encrypted",
+	}
+
+	aclDeniedMessages = []string{
+		"permission denied",
+		"acl not found",
+		"unexpected response code: 403",
+	}
+)
+
+func classifyJoinError(member string, err error) error {
+	// errors from the HTTP request itself are about the local agent, only
+	// the rest were reported for the member
+	if urlErr, ok := err.(*url.Error); ok {
+		if isTLSFailure(urlErr.Err) {
+			return &TLSError{Member: member, Err: err}
+		}
+
+		return &AgentUnavailableError{Member: member, Err: err}
+	}
+
+	switch message := err.Error(); {
+	case containsAny(message, unreachableMessages):
+		return &UnreachableError{Member: member, Err: err}
+	case containsAny(message, gossipKeyMismatchMessages):
+		return &GossipKeyMismatchError{Member: member, Err: err}
+	case containsAny(message, aclDeniedMessages):
+		return &ACLDeniedError{Member: member, Err: err}
+	default:
+		return err
+	}
+}
+
+// isTLSFailure reports whether the request to the local agent failed to
+// verify its certificate or to complete the handshake.
+func isTLSFailure(err error) bool {
+	switch err.(type) {
+	case x509.UnknownAuthorityError,
+		x509.CertificateInvalidError,
+		x509.HostnameError,
+		tls.RecordHeaderError:
+		return true
+	}
+
+	return strings.Contains(err.Error(), "tls: ")
+}
+
+func containsAny(message string, substrings []string) bool {
+	message = strings.ToLower(message)
+	for _, substring := range substrings {
+		if strings.Contains(message, substring) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,212 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/hashicorp/consul/api"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
+)
+
+// KeyringPool is a gossip pool with a keyring of its own. Consul applies
+// InstallKey, UseKey and RemoveKey to the LAN and WAN pools together, so the
+// pools are told apart when keys are listed and when an operation is checked
+// to have reached every node.
+type KeyringPool string
+
+const (
+	KeyringPoolLAN KeyringPool = "lan"
+	KeyringPoolWAN KeyringPool = "wan"
+)
+
+const keyringStateFile = "keyring.json"
+
+// keyringState records the key set SetKeys last reconciled. Keys holds the
+// SHA-256 hashes of the encrypted keys in order, so that the state file does
+// not contain key material.
+type keyringState struct {
+	Keys         []string      `json:"keys"`
+	Pools        []KeyringPool `json:"pools"`
+	ReconciledAt time.Time     `json:"reconciled_at"`
+}
+
+func newKeyringState(keys []string, pools []KeyringPool, reconciledAt time.Time) keyringState {
+	return keyringState{
+		Keys:         hashKeys(keys),
+		Pools:        pools,
+		ReconciledAt: reconciledAt,
+	}
+}
+
+func (s keyringState) matches(keys []string, pools []KeyringPool) bool {
+	return reflect.DeepEqual(s.Keys, hashKeys(keys)) && reflect.DeepEqual(s.Pools, pools)
+}
+
+func (c Client) readKeyringState(dataDir string) keyringState {
+	var state keyringState
+
+	contents, err := ioutil.ReadFile(filepath.Join(dataDir, keyringStateFile))
+	if err != nil {
+		return state
+	}
+
+	if err := json.Unmarshal(contents, &state); err != nil {
+		c.Logger.Error("agent-client.read-keyring-state.failed", err)
+	}
+
+	return state
+}
+
+func (c Client) writeKeyringState(dataDir string, state keyringState) {
+	path := filepath.Join(dataDir, keyringStateFile)
+
+	contents, err := json.Marshal(state)
+	if err != nil {
+		panic(err) // not tested, keyringState always marshals
+	}
+
+	if _, err := utils.WriteFileAtomically(path, contents, 0600, utils.DirOwner(dataDir)); err != nil {
+		c.Logger.Error("agent-client.write-keyring-state.failed", err, lager.Data{
+			"path": path,
+		})
+	}
+}
+
+func hashKeys(keys []string) []string {
+	hashes := []string{}
+	for _, key := range keys {
+		hashes = append(hashes, fmt.Sprintf("%x", sha256.Sum256([]byte(key))))
+	}
+
+	return hashes
+}
+
+// sameKeys reports whether keys and expected hold the same keys in any order.
+func sameKeys(keys, expected []string) bool {
+	sortedKeys := append([]string{}, keys...)
+	sort.Strings(sortedKeys)
+
+	sortedExpected := append([]string{}, expected...)
+	sort.Strings(sortedExpected)
+
+	return reflect.DeepEqual(sortedKeys, sortedExpected)
+}
+
+// ListPoolKeys returns the keys installed on any node of the given pools.
+func (c Client) ListPoolKeys(pools ...KeyringPool) ([]string, error) {
+	keyring, err := c.ConsulAPIOperator.KeyringList(&api.QueryOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return poolKeys(keyring, pools), nil
+}
+
+// VerifyKeysInstalled returns an error unless every key is installed on all
+// NumNodes nodes of each of the given pools.
+func (c Client) VerifyKeysInstalled(keys []string, pools ...KeyringPool) error {
+	keyring, err := c.ConsulAPIOperator.KeyringList(&api.QueryOptions{})
+	if err != nil {
+		return err
+	}
+
+	return KeysInstalled(keyring, keys, pools...)
+}
+
+// KeysInstalled returns an error unless every key is installed on all
+// NumNodes nodes of each of the given pools, and keyring reports on each of
+// them. Every pool in keyring is checked when no pools are given.
+func KeysInstalled(keyring []*api.KeyringResponse, keys []string, pools ...KeyringPool) error {
+	if len(keyring) == 0 && len(pools) == 0 {
+		return errors.New("keyring list returned no gossip pools")
+	}
+
+	for _, pool := range pools {
+		if !reportsPool(keyring, pool) {
+			return fmt.Errorf("keyring list did not report the %s pool", pool)
+		}
+	}
+
+	for _, response := range keyring {
+		pool := poolOf(response)
+		if len(pools) > 0 && !containsPool(pools, pool) {
+			continue
+		}
+
+		for _, key := range keys {
+			if response.Keys[key] < response.NumNodes {
+				return fmt.Errorf("key %s is installed on %d of %d nodes in the %s pool of %s",
+					key, response.Keys[key], response.NumNodes, pool, response.Datacenter)
+			}
+		}
+	}
+
+	return nil
+}
+
+// keyringPools returns the pools whose keyrings SetKeys reconciles. Only
+// servers that join other datacenters gossip in a WAN pool that needs the
+// same keys.
+func (c Client) keyringPools() []KeyringPool {
+	if c.JoinedWAN {
+		return []KeyringPool{KeyringPoolLAN, KeyringPoolWAN}
+	}
+
+	return []KeyringPool{KeyringPoolLAN}
+}
+
+func reportsPool(keyring []*api.KeyringResponse, pool KeyringPool) bool {
+	for _, response := range keyring {
+		if poolOf(response) == pool {
+			return true
+		}
+	}
+
+	return false
+}
+
+func poolKeys(keyring []*api.KeyringResponse, pools []KeyringPool) []string {
+	seen := map[string]bool{}
+	keys := []string{}
+	for _, response := range keyring {
+		if !containsPool(pools, poolOf(response)) {
+			continue
+		}
+
+		for key := range response.Keys {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return keys
+}
+
+func poolOf(response *api.KeyringResponse) KeyringPool {
+	if response.WAN {
+		return KeyringPoolWAN
+	}
+
+	return KeyringPoolLAN
+}
+
+func containsPool(pools []KeyringPool, pool KeyringPool) bool {
+	for _, p := range pools {
+		if p == pool {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// VerifyRaftPeers checks that this server is a raft voter, that every
+// expected member that is running is a raft peer, and that no other live peers
+// remain. Peers that are not expected and are no longer alive members, such
+// as those left behind by scaled down instances, are stale: they are removed
+// when RemoveStalePeers is set, and otherwise only logged.
+func (c Client) VerifyRaftPeers() error {
+	c.Logger.Info("agent-client.verify-raft-peers.configuration.request")
+
+	configuration, err := c.RaftConfiguration()
+	if err != nil {
+		c.Logger.Error("agent-client.verify-raft-peers.configuration.request.failed", err)
+		return err
+	}
+
+	var peers []string
+	for _, server := range configuration.Servers {
+		peers = append(peers, server.Address)
+	}
+
+	c.Logger.Info("agent-client.verify-raft-peers.configuration.response", lager.Data{
+		"peers": peers,
+	})
+
+	address, err := c.Address()
+	if err != nil {
+		c.Logger.Error("agent-client.verify-raft-peers.address.failed", err)
+		return err
+	}
+
+	members, err := c.ConsulAPIAgent.Members(false)
+	if err != nil {
+		c.Logger.Error("agent-client.verify-raft-peers.members.request.failed", err)
+		return err
+	}
+
+	// expected members given as hostnames are matched by the addresses they
+	// resolve to, as raft peers are known by address. A member that cannot be
+	// resolved would make its peer look unexpected, and possibly stale.
+	expected := map[string][]string{}
+	for _, member := range c.ExpectedMembers {
+		addresses, err := c.resolve(member)
+		if err != nil {
+			c.Logger.Error("agent-client.verify-raft-peers.resolve-failed", err, lager.Data{
+				"member": member,
+			})
+			return err
+		}
+		expected[member] = addresses
+	}
+
+	self := findPeer(configuration.Servers, address, []string{hostOf(address)})
+	if self == nil {
+		err = fmt.Errorf("%s is not a raft peer", address)
+		c.Logger.Error("agent-client.verify-raft-peers.not-a-peer", err)
+		return err
+	}
+
+	if !self.Voter {
+		err = fmt.Errorf("%s is not a raft voter", address)
+		c.Logger.Error("agent-client.verify-raft-peers.not-a-voter", err)
+		return err
+	}
+
+	var unexpected []string
+	for _, server := range configuration.Servers {
+		if server == self || isExpectedPeer(server, expected) {
+			continue
+		}
+
+		if !isAliveMember(members, server.Node, []string{hostOf(server.Address)}) {
+			if !c.RemoveStalePeers {
+				c.Logger.Info("agent-client.verify-raft-peers.stale-peer", lager.Data{
+					"peer": server.Address,
+					"node": server.Node,
+				})
+				continue
+			}
+
+			c.Logger.Info("agent-client.verify-raft-peers.remove-stale-peer", lager.Data{
+				"peer": server.Address,
+				"node": server.Node,
+			})
+
+			if err := c.RaftRemovePeer(server.Address); err != nil {
+				c.Logger.Error("agent-client.verify-raft-peers.remove-stale-peer.failed", err, lager.Data{
+					"peer": server.Address,
+				})
+				return err
+			}
+
+			continue
+		}
+
+		unexpected = append(unexpected, server.Address)
+	}
+
+	if len(unexpected) > 0 {
+		err = fmt.Errorf("unexpected raft peers: %s", strings.Join(unexpected, ", "))
+		c.Logger.Error("agent-client.verify-raft-peers.unexpected-peers", err)
+		return err
+	}
+
+	// expected members that are not running yet, such as those that are
+	// deployed after this one, cannot be raft peers
+	var missing []string
+	for _, member := range c.ExpectedMembers {
+		addresses := expected[member]
+		if findPeer(configuration.Servers, member, addresses) == nil && isAliveMember(members, member, addresses) {
+			missing = append(missing, member)
+		}
+	}
+
+	if len(missing) > 0 {
+		err = fmt.Errorf("expected servers are not raft peers: %s", strings.Join(missing, ", "))
+		c.Logger.Error("agent-client.verify-raft-peers.missing-peers", err)
+		return err
+	}
+
+	c.Logger.Info("agent-client.verify-raft-peers.verified")
+	return nil
+}
+
+func isExpectedPeer(server *api.RaftServer, expected map[string][]string) bool {
+	for member, addresses := range expected {
+		if peerMatches(server, member, addresses) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func findPeer(servers []*api.RaftServer, name string, addresses []string) *api.RaftServer {
+	for _, server := range servers {
+		if peerMatches(server, name, addresses) {
+			return server
+		}
+	}
+
+	return nil
+}
+
+// peerMatches reports whether server is the node called name, or is at one
+// of the addresses name resolved to.
+func peerMatches(server *api.RaftServer, name string, addresses []string) bool {
+	return server.Node == name || containsAddress(addresses, hostOf(server.Address))
+}
+
+func isAliveMember(members []*api.AgentMember, name string, addresses []string) bool {
+	for _, member := range members {
+		if member.Name == name || containsAddress(addresses, member.Addr) {
+			return member.Status == memberStatusAlive
+		}
+	}
+
+	return false
+}
+
+func hostOf(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+
+	return address
+}
@@ -11,21 +11,26 @@ import (
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"code.cloudfoundry.org/lager"
 )
 
+const stopPollInterval = 100 * time.Millisecond
+
 type Runner struct {
-	Path      string
-	PIDFile   string
-	ConfigDir string
-	Stdout    io.Writer
-	Stderr    io.Writer
-	Recursors []string
-	Logger    logger
-	cmd       *exec.Cmd
-	wg        sync.WaitGroup
-	exited    int32
+	Path             string
+	PIDFile          string
+	ConfigDir        string
+	Stdout           io.Writer
+	Stderr           io.Writer
+	Recursors        []string
+	Logger           logger
+	InterruptTimeout time.Duration
+	TerminateTimeout time.Duration
+	cmd              *exec.Cmd
+	wg               sync.WaitGroup
+	exited           int32
 }
 
 func (r *Runner) Run() error {
@@ -44,6 +49,8 @@ func (r *Runner) Run() error {
 		args = append(args, fmt.Sprintf("-recursor=%s", recursor))
 	}
 
+	atomic.StoreInt32(&r.exited, 0)
+
 	r.cmd = exec.Command(r.Path, args...)
 	r.cmd.Stdout = r.Stdout
 	r.cmd.Stderr = r.Stderr
@@ -62,11 +69,11 @@ func (r *Runner) Run() error {
 	}
 
 	r.wg.Add(1)
-	go func() {
-		r.cmd.Wait()
+	go func(cmd *exec.Cmd) {
+		cmd.Wait()
 		atomic.StoreInt32(&r.exited, 1)
 		r.wg.Done()
-	}()
+	}(r.cmd)
 
 	r.Logger.Info("agent-runner.run.success")
 	return nil
@@ -74,6 +81,33 @@ func (r *Runner) Run() error {
 
 func (r *Runner) Exited() bool { return atomic.LoadInt32(&r.exited) == 1 }
 
+// ExitStatus describes how the process started by Run exited, or returns an
+// empty string while it is still running.
+func (r *Runner) ExitStatus() string {
+	if !r.Exited() || r.cmd.ProcessState == nil {
+		return ""
+	}
+
+	return r.cmd.ProcessState.String()
+}
+
+// Crashed reports whether the process started by Run exited with a non-zero
+// status for any reason other than being asked to stop with SIGINT or SIGTERM.
+func (r *Runner) Crashed() bool {
+	if !r.Exited() || r.cmd.ProcessState == nil {
+		return false
+	}
+
+	if status, ok := r.cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		switch status.Signal() {
+		case syscall.SIGINT, syscall.SIGTERM:
+			return false
+		}
+	}
+
+	return !r.cmd.ProcessState.Success()
+}
+
 func (r *Runner) WritePID() error {
 	r.Logger.Info("agent-runner.run.write-pidfile", lager.Data{
 		"pid":  r.cmd.Process.Pid,
@@ -138,6 +172,11 @@ func (r *Runner) Wait() error {
 	return nil
 }
 
+// Stop sends SIGINT, then SIGTERM and finally SIGKILL, waiting for the
+// configured timeout after each of the first two signals for the process to
+// exit. SIGINT makes a client leave the cluster gracefully, while a server
+// skips leaving on interrupt by default and has to be asked to leave through
+// the API beforehand. A process that has already exited is not signalled.
 func (r *Runner) Stop() error {
 	r.Logger.Info("agent-runner.stop.get-process")
 
@@ -151,13 +190,61 @@ func (r *Runner) Stop() error {
 		"pid": process.Pid,
 	})
 
+	steps := []struct {
+		signal  syscall.Signal
+		timeout time.Duration
+	}{
+		{syscall.SIGINT, r.InterruptTimeout},
+		{syscall.SIGTERM, r.TerminateTimeout},
+	}
+
+	for _, step := range steps {
+		if r.processExited(process) {
+			r.Logger.Info("agent-runner.stop.success")
+			return nil
+		}
+
+		r.Logger.Info("agent-runner.stop.signal", lager.Data{
+			"pid":    process.Pid,
+			"signal": step.signal.String(),
+		})
+
+		if err := process.Signal(step.signal); err != nil {
+			r.Logger.Error("agent-runner.stop.signal.failed", err, lager.Data{
+				"pid":    process.Pid,
+				"signal": step.signal.String(),
+			})
+			continue
+		}
+
+		if r.waitForExit(process, step.timeout) {
+			r.Logger.Info("agent-runner.stop.success")
+			return nil
+		}
+
+		r.Logger.Info("agent-runner.stop.signal.timeout", lager.Data{
+			"pid":     process.Pid,
+			"signal":  step.signal.String(),
+			"timeout": step.timeout.String(),
+		})
+	}
+
+	if r.processExited(process) {
+		r.Logger.Info("agent-runner.stop.success")
+		return nil
+	}
+
 	r.Logger.Info("agent-runner.stop.signal", lager.Data{
-		"pid": process.Pid,
+		"pid":    process.Pid,
+		"signal": syscall.SIGKILL.String(),
 	})
 
-	err = process.Signal(syscall.Signal(syscall.SIGKILL))
+	err = process.Signal(syscall.SIGKILL)
 	if err != nil {
-		r.Logger.Error("agent-runner.stop.signal.failed", err)
+		r.Logger.Error("agent-runner.stop.signal.failed", err, lager.Data{
+			"pid":    process.Pid,
+			"signal": syscall.SIGKILL.String(),
+		})
 		return err
 	}
 
@@ -165,6 +252,54 @@ func (r *Runner) Stop() error {
 	return nil
 }
 
+// Reload sends SIGHUP, which tells consul to reload its configuration.
+func (r *Runner) Reload() error {
+	r.Logger.Info("agent-runner.reload.get-process")
+
+	process, err := r.getProcess()
+	if err != nil {
+		r.Logger.Error("agent-runner.reload.get-process.failed", errors.New(err.Error()))
+		return err
+	}
+
+	r.Logger.Info("agent-runner.reload.signal", lager.Data{
+		"pid": process.Pid,
+	})
+
+	if err := process.Signal(syscall.SIGHUP); err != nil {
+		r.Logger.Error("agent-runner.reload.signal.failed", err, lager.Data{
+			"pid": process.Pid,
+		})
+		return err
+	}
+
+	r.Logger.Info("agent-runner.reload.success")
+	return nil
+}
+
+func (r *Runner) waitForExit(process *os.Process, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if r.processExited(process) {
+			return true
+		}
+
+		if !time.Now().Before(deadline) {
+			return false
+		}
+
+		time.Sleep(stopPollInterval)
+	}
+}
+
+func (r *Runner) processExited(process *os.Process) bool {
+	if r.cmd != nil && r.cmd.Process == process {
+		return r.Exited()
+	}
+
+	return process.Signal(syscall.Signal(0)) != nil
+}
+
 func (r *Runner) Cleanup() error {
 	r.Logger.Info("agent-runner.cleanup.remove", lager.Data{
 		"pidfile": r.PIDFile,
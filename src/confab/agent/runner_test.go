@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	"code.cloudfoundry.org/lager"
 
@@ -39,11 +40,13 @@ var _ = Describe("Runner", func() {
 		logger = &fakes.Logger{}
 
 		runner = &agent.Runner{
-			Path:      pathToFakeProcess,
-			ConfigDir: configDir,
-			Recursors: []string{"8.8.8.8", "10.0.2.3"},
-			PIDFile:   pidFileName,
-			Logger:    logger,
+			Path:             pathToFakeProcess,
+			ConfigDir:        configDir,
+			Recursors:        []string{"8.8.8.8", "10.0.2.3"},
+			PIDFile:          pidFileName,
+			Logger:           logger,
+			InterruptTimeout: 100 * time.Millisecond,
+			TerminateTimeout: 100 * time.Millisecond,
 			// Stdout:    os.Stdout,  // uncomment this to see output from test agent
 			// Stderr:    os.Stderr,
 		}
@@ -141,7 +144,38 @@ var _ = Describe("Runner", func() {
 					{
 						Action: "agent-runner.stop.signal",
 						Data: []lager.Data{{
-							"pid": pid,
+							"pid":    pid,
+							"signal": "interrupt",
+						}},
+					},
+					{
+						Action: "agent-runner.stop.signal.timeout",
+						Data: []lager.Data{{
+							"pid":     pid,
+							"signal":  "interrupt",
+							"timeout": "100ms",
+						}},
+					},
+					{
+						Action: "agent-runner.stop.signal",
+						Data: []lager.Data{{
+							"pid":    pid,
+							"signal": "terminated",
+						}},
+					},
+					{
+						Action: "agent-runner.stop.signal.timeout",
+						Data: []lager.Data{{
+							"pid":     pid,
+							"signal":  "terminated",
+							"timeout": "100ms",
+						}},
+					},
+					{
+						Action: "agent-runner.stop.signal",
+						Data: []lager.Data{{
+							"pid":    pid,
+							"signal": "killed",
 						}},
 					},
 					{
@@ -155,6 +189,69 @@ var _ = Describe("Runner", func() {
 			})
 		})
 
+		It("stops without escalating when the process exits on interrupt", func() {
+			Expect(ioutil.WriteFile(filepath.Join(runner.ConfigDir, "options.json"), []byte(`{ "WaitForHUP": true, "ExitOnInterrupt": true }`), 0600)).To(Succeed())
+			Expect(runner.Run()).To(Succeed())
+			Expect(runner.WritePID()).To(Succeed())
+
+			Eventually(func() error {
+				_, err := os.Stat(filepath.Join(runner.ConfigDir, "fake-output.json"))
+				return err
+			}).Should(Succeed())
+
+			runner.InterruptTimeout = 5 * time.Second
+			Expect(runner.Stop()).To(Succeed())
+			Expect(runner.Exited()).To(BeTrue())
+
+			for _, message := range logger.Messages() {
+				Expect(message.Action).NotTo(Equal("agent-runner.stop.signal.timeout"))
+			}
+		})
+
+		It("does not signal a process that has already exited", func() {
+			Expect(ioutil.WriteFile(filepath.Join(runner.ConfigDir, "options.json"), []byte(`{ "WaitForHUP": true, "ExitOnInterrupt": true }`), 0600)).To(Succeed())
+			Expect(runner.Run()).To(Succeed())
+			Expect(runner.WritePID()).To(Succeed())
+
+			Eventually(func() error {
+				_, err := os.Stat(filepath.Join(runner.ConfigDir, "fake-output.json"))
+				return err
+			}).Should(Succeed())
+
+			runner.InterruptTimeout = 5 * time.Second
+			Expect(runner.Stop()).To(Succeed())
+			Expect(runner.Exited()).To(BeTrue())
+
+			stopped := len(logger.Messages())
+			Expect(runner.Stop()).To(Succeed())
+			for _, message := range logger.Messages()[stopped:] {
+				Expect(message.Action).NotTo(Equal("agent-runner.stop.signal"))
+			}
+		})
+
+		Context("when the process was started by another runner", func() {
+			It("finds the process from the PID file and escalates to kill", func() {
+				Expect(ioutil.WriteFile(filepath.Join(runner.ConfigDir, "options.json"), []byte(`{ "WaitForHUP": true }`), 0600)).To(Succeed())
+				Expect(runner.Run()).To(Succeed())
+				Expect(runner.WritePID()).To(Succeed())
+
+				Eventually(func() error {
+					_, err := os.Stat(filepath.Join(runner.ConfigDir, "fake-output.json"))
+					return err
+				}).Should(Succeed())
+
+				otherRunner := &agent.Runner{
+					PIDFile:          runner.PIDFile,
+					Logger:           logger,
+					InterruptTimeout: 100 * time.Millisecond,
+					TerminateTimeout: 100 * time.Millisecond,
+				}
+				Expect(otherRunner.Stop()).To(Succeed())
+
+				Eventually(runner.Exited).Should(BeTrue())
+			})
+		})
+
 		Context("when the PID file cannot be read", func() {
 			It("returns an error", func() {
 				runner.PIDFile = "/tmp/nope-i-do-not-exist"
@@ -280,6 +377,92 @@ var _ = Describe("Runner", func() {
 		})
 	})
 
+	Describe("Reload", func() {
+		It("sends SIGHUP to the process", func() {
+			Expect(ioutil.WriteFile(filepath.Join(runner.ConfigDir, "options.json"), []byte(`{ "WaitForHUP": true }`), 0600)).To(Succeed())
+			Expect(runner.Run()).To(Succeed())
+			Expect(runner.WritePID()).To(Succeed())
+
+			pid, err := getPID(runner)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(runner.Reload()).To(Succeed())
+			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "agent-runner.reload.get-process",
+				},
+				{
+					Action: "agent-runner.reload.signal",
+					Data: []lager.Data{{
+						"pid": pid,
+					}},
+				},
+				{
+					Action: "agent-runner.reload.success",
+				},
+			}))
+
+			Consistently(runner.Exited, "100ms").Should(BeFalse())
+			Expect(runner.Stop()).To(Succeed())
+		})
+
+		Context("when the PID file cannot be read", func() {
+			It("returns an error", func() {
+				runner.PIDFile = "/tmp/nope-i-do-not-exist"
+				err := runner.Reload()
+				Expect(err).To(BeAnOsIsNotExistError())
+				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "agent-runner.reload.get-process.failed",
+						Error:  errors.New(err.Error()),
+					},
+				}))
+			})
+		})
+	})
+
+	Describe("ExitStatus & Crashed", func() {
+		It("reports nothing while the process is running", func() {
+			Expect(ioutil.WriteFile(filepath.Join(runner.ConfigDir, "options.json"), []byte(`{ "WaitForHUP": true }`), 0600)).To(Succeed())
+			Expect(runner.Run()).To(Succeed())
+			Expect(runner.WritePID()).To(Succeed())
+
+			Expect(runner.ExitStatus()).To(BeEmpty())
+			Expect(runner.Crashed()).To(BeFalse())
+
+			Expect(runner.Stop()).To(Succeed())
+		})
+
+		It("does not report a clean exit as a crash", func() {
+			Expect(runner.Run()).To(Succeed())
+			Expect(runner.Wait()).To(Succeed())
+
+			Expect(runner.ExitStatus()).To(Equal("exit status 0"))
+			Expect(runner.Crashed()).To(BeFalse())
+		})
+
+		It("reports a non-zero exit as a crash", func() {
+			Expect(ioutil.WriteFile(filepath.Join(runner.ConfigDir, "options.json"), []byte(`{ "ExitCode": 3 }`), 0600)).To(Succeed())
+			Expect(runner.Run()).To(Succeed())
+			Expect(runner.Wait()).To(Succeed())
+
+			Expect(runner.ExitStatus()).To(Equal("exit status 3"))
+			Expect(runner.Crashed()).To(BeTrue())
+		})
+
+		It("reports the status of the latest run", func() {
+			Expect(ioutil.WriteFile(filepath.Join(runner.ConfigDir, "options.json"), []byte(`{ "ExitCode": 3 }`), 0600)).To(Succeed())
+			Expect(runner.Run()).To(Succeed())
+			Expect(runner.Wait()).To(Succeed())
+			Expect(runner.Crashed()).To(BeTrue())
+
+			Expect(os.Remove(filepath.Join(runner.ConfigDir, "options.json"))).To(Succeed())
+			Expect(runner.Run()).To(Succeed())
+			Expect(runner.Wait()).To(Succeed())
+			Expect(runner.Crashed()).To(BeFalse())
+		})
+	})
+
 	Describe("WritePID", func() {
 		BeforeEach(func() {
 			Expect(runner.Run()).To(Succeed())
@@ -7,6 +7,9 @@ import (
 	"code.cloudfoundry.org/lager"
 )
 
+// memberStatusAlive is serf's status for a member that is alive.
+const memberStatusAlive = 1
+
 type statusClient interface {
 	Leader() (string, error)
 }
@@ -75,3 +78,70 @@ func (b BootstrapChecker) StartInBootstrapMode() (startInBootstrapMode bool, err
 
 	return
 }
+
+// BootstrapNode returns the name of a cluster member that is running with the
+// single-node bootstrap flag, or an empty string when there is none.
+func (b BootstrapChecker) BootstrapNode() (string, error) {
+	b.logger.Info("chaperon-bootstrap-checker.bootstrap-node.agent-client.members")
+	members, err := b.agentClient.Members(false)
+	if err != nil {
+		b.logger.Error("chaperon-bootstrap-checker.bootstrap-node.agent-client.members.failed", err)
+		return "", err
+	}
+
+	for _, member := range members {
+		if member.Tags["bootstrap"] == "1" {
+			return member.Name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// AwaitingBootstrapPeers reports whether a cluster started with
+// bootstrap_expect is still waiting for servers to join before it can elect a
+// leader: there is no leader, no member running with the single-node
+// bootstrap flag, and fewer than expect servers are alive.
+func (b BootstrapChecker) AwaitingBootstrapPeers(expect int) (bool, error) {
+	b.logger.Info("chaperon-bootstrap-checker.awaiting-bootstrap-peers.agent-client.members")
+	members, err := b.agentClient.Members(false)
+	if err != nil {
+		b.logger.Error("chaperon-bootstrap-checker.awaiting-bootstrap-peers.agent-client.members.failed", err)
+		return false, err
+	}
+
+	var servers int
+	for _, member := range members {
+		if member.Tags["bootstrap"] == "1" {
+			return false, nil
+		}
+
+		if member.Tags["role"] == "consul" && member.Status == memberStatusAlive {
+			servers++
+		}
+	}
+
+	if servers >= expect {
+		return false, nil
+	}
+
+	b.logger.Info("chaperon-bootstrap-checker.awaiting-bootstrap-peers.status-client.leader")
+	leader, err := b.statusClient.Leader()
+	if err != nil {
+		if strings.Contains(err.Error(), "No known Consul servers") {
+			leader = ""
+		} else {
+			b.logger.Error("chaperon-bootstrap-checker.awaiting-bootstrap-peers.status-client.leader.failed", err)
+			return false, err
+		}
+	}
+
+	awaiting := leader == ""
+	b.logger.Info("chaperon-bootstrap-checker.awaiting-bootstrap-peers", lager.Data{
+		"alive-servers": servers,
+		"expect":        expect,
+		"awaiting":      awaiting,
+	})
+
+	return awaiting, nil
+}
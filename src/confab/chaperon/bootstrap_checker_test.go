@@ -253,4 +253,146 @@ var _ = Describe("BootstrapChecker", func() {
 			})
 		})
 	})
+
+	Describe("BootstrapNode", func() {
+		var (
+			logger           *fakes.Logger
+			agentClient      *fakes.AgentClient
+			bootstrapChecker chaperon.BootstrapChecker
+		)
+
+		BeforeEach(func() {
+			logger = &fakes.Logger{}
+			agentClient = &fakes.AgentClient{}
+
+			bootstrapChecker = chaperon.NewBootstrapChecker(logger, agentClient, &fakes.StatusClient{}, func(time.Duration) {})
+		})
+
+		It("returns the member running in bootstrap mode", func() {
+			agentClient.MembersCall.Returns.Members = []*api.AgentMember{
+				{Name: "consul-0", Tags: map[string]string{"bootstrap": "0"}},
+				{Name: "consul-1", Tags: map[string]string{"bootstrap": "1"}},
+			}
+
+			name, err := bootstrapChecker.BootstrapNode()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(Equal("consul-1"))
+		})
+
+		It("returns an empty name when no member is running in bootstrap mode", func() {
+			agentClient.MembersCall.Returns.Members = []*api.AgentMember{
+				{Name: "consul-0", Tags: map[string]string{}},
+			}
+
+			name, err := bootstrapChecker.BootstrapNode()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(name).To(BeEmpty())
+		})
+
+		It("returns an error when the members check fails", func() {
+			agentClient.MembersCall.Returns.Error = errors.New("error checking members")
+
+			_, err := bootstrapChecker.BootstrapNode()
+			Expect(err).To(MatchError("error checking members"))
+			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "chaperon-bootstrap-checker.bootstrap-node.agent-client.members.failed",
+					Error:  errors.New("error checking members"),
+				},
+			}))
+		})
+	})
+
+	Describe("AwaitingBootstrapPeers", func() {
+		var (
+			logger           *fakes.Logger
+			agentClient      *fakes.AgentClient
+			statusClient     *fakes.StatusClient
+			bootstrapChecker chaperon.BootstrapChecker
+		)
+
+		BeforeEach(func() {
+			logger = &fakes.Logger{}
+			agentClient = &fakes.AgentClient{}
+			statusClient = &fakes.StatusClient{}
+
+			agentClient.MembersCall.Returns.Members = []*api.AgentMember{
+				{Name: "consul-0", Status: 1, Tags: map[string]string{"role": "consul"}},
+				{Name: "consul-1", Status: 4, Tags: map[string]string{"role": "consul"}},
+				{Name: "client-0", Status: 1, Tags: map[string]string{"role": "node"}},
+			}
+
+			bootstrapChecker = chaperon.NewBootstrapChecker(logger, agentClient, statusClient, func(time.Duration) {})
+		})
+
+		It("returns true when fewer servers than expected are alive and there is no leader", func() {
+			awaiting, err := bootstrapChecker.AwaitingBootstrapPeers(3)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(awaiting).To(BeTrue())
+
+			Expect(statusClient.LeaderCall.CallCount).To(Equal(1))
+			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "chaperon-bootstrap-checker.awaiting-bootstrap-peers",
+					Data: []lager.Data{{
+						"alive-servers": 1,
+						"expect":        3,
+						"awaiting":      true,
+					}},
+				},
+			}))
+		})
+
+		It("returns true when the agent knows no consul servers", func() {
+			statusClient.LeaderCall.Returns.Error = errors.New("No known Consul servers")
+
+			awaiting, err := bootstrapChecker.AwaitingBootstrapPeers(3)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(awaiting).To(BeTrue())
+		})
+
+		It("returns false when enough servers are alive", func() {
+			awaiting, err := bootstrapChecker.AwaitingBootstrapPeers(1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(awaiting).To(BeFalse())
+			Expect(statusClient.LeaderCall.CallCount).To(Equal(0))
+		})
+
+		It("returns false when there is a leader", func() {
+			statusClient.LeaderCall.Returns.Leader = "10.0.0.1:8300"
+
+			awaiting, err := bootstrapChecker.AwaitingBootstrapPeers(3)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(awaiting).To(BeFalse())
+		})
+
+		It("returns false when a member is running in bootstrap mode", func() {
+			agentClient.MembersCall.Returns.Members[0].Tags["bootstrap"] = "1"
+
+			awaiting, err := bootstrapChecker.AwaitingBootstrapPeers(3)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(awaiting).To(BeFalse())
+			Expect(statusClient.LeaderCall.CallCount).To(Equal(0))
+		})
+
+		It("returns an error when the members check fails", func() {
+			agentClient.MembersCall.Returns.Error = errors.New("error checking members")
+
+			_, err := bootstrapChecker.AwaitingBootstrapPeers(3)
+			Expect(err).To(MatchError("error checking members"))
+		})
+
+		It("returns an error when the leader check fails", func() {
+			statusClient.LeaderCall.Returns.Error = errors.New("error checking leader")
+
+			_, err := bootstrapChecker.AwaitingBootstrapPeers(3)
+			Expect(err).To(MatchError("error checking leader"))
+			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "chaperon-bootstrap-checker.awaiting-bootstrap-peers.status-client.leader.failed",
+					Error:  errors.New("error checking leader"),
+				},
+			}))
+		})
+	})
 })
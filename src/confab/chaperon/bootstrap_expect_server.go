@@ -0,0 +1,98 @@
+package chaperon
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
+)
+
+type bootstrapExpectChecker interface {
+	BootstrapNode() (string, error)
+	AwaitingBootstrapPeers(expect int) (bool, error)
+}
+
+// BootstrapExpectServer starts a server once with bootstrap_expect set to the
+// number of LAN servers, instead of asking the cluster whether to restart in
+// single-node bootstrap mode. Until that many servers have joined there is no
+// leader, so the servers that start first only record their pid and the server
+// that completes the cluster configures it. This lets a deploy start the
+// servers one at a time.
+type BootstrapExpectServer struct {
+	controller       controller
+	configWriter     configWriter
+	bootstrapChecker bootstrapExpectChecker
+	logger           logger
+}
+
+func NewBootstrapExpectServer(controller controller, configWriter configWriter, bootstrapChecker bootstrapExpectChecker, logger logger) BootstrapExpectServer {
+	return BootstrapExpectServer{
+		controller:       controller,
+		configWriter:     configWriter,
+		bootstrapChecker: bootstrapChecker,
+		logger:           logger,
+	}
+}
+
+func (s BootstrapExpectServer) Start(cfg config.Config, timeout utils.Timeout) error {
+	if len(cfg.Consul.Agent.Servers.LAN) == 0 {
+		err := errors.New("the bootstrap_expect server start strategy needs at least one consul.agent.servers.lan entry")
+		s.logger.Error("bootstrap-expect-server.start.failed", err)
+		return err
+	}
+
+	cfg.Confab.ServerStartStrategy = config.ServerStartStrategyBootstrapExpect
+	cfg.Consul.Agent.Bootstrap = false
+
+	if err := s.configWriter.Write(cfg); err != nil {
+		return err
+	}
+
+	if err := s.controller.WriteServiceDefinitions(); err != nil {
+		return err
+	}
+
+	if err := s.controller.BootAgent(timeout); err != nil {
+		return err
+	}
+
+	// A cluster created with the bootstrap strategy keeps a member running with
+	// the single-node bootstrap flag until that member restarts with this
+	// strategy, which drops the flag. The cluster already has a leader and its
+	// servers keep their raft data, so consul ignores bootstrap_expect and this
+	// server is configured like any other member of an established cluster.
+	bootstrapNode, err := s.bootstrapChecker.BootstrapNode()
+	switch {
+	case err != nil:
+		s.logger.Error("bootstrap-expect-server.start.bootstrap-node.failed", err)
+	case bootstrapNode != "":
+		s.logger.Info("bootstrap-expect-server.start.migrating", lager.Data{
+			"bootstrap-node": bootstrapNode,
+		})
+	}
+
+	expect := len(cfg.Consul.Agent.Servers.LAN)
+	awaiting, err := s.bootstrapChecker.AwaitingBootstrapPeers(expect)
+	if err != nil {
+		s.logger.Error("bootstrap-expect-server.start.awaiting-bootstrap-peers.failed", err)
+	}
+
+	if awaiting {
+		s.logger.Info("bootstrap-expect-server.start.awaiting-bootstrap-peers", lager.Data{
+			"expect": expect,
+		})
+		return s.controller.WritePID()
+	}
+
+	if err := s.controller.ConfigureServer(timeout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s BootstrapExpectServer) Stop() {
+	s.controller.StopAgent()
+}
@@ -0,0 +1,168 @@
+package chaperon_test
+
+import (
+	"errors"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/chaperon"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/pivotal-cf-experimental/gomegamatchers"
+)
+
+var _ = Describe("BootstrapExpectServer", func() {
+	var (
+		server           chaperon.BootstrapExpectServer
+		timeout          *fakes.Timeout
+		controller       *fakes.Controller
+		bootstrapChecker *fakes.BootstrapChecker
+		configWriter     *fakes.ConfigWriter
+		logger           *fakes.Logger
+		cfg              config.Config
+	)
+
+	BeforeEach(func() {
+		cfg = config.Config{
+			Node: config.ConfigNode{
+				Name: "some-name",
+			},
+		}
+		cfg.Consul.Agent.Mode = "server"
+		cfg.Consul.Agent.Bootstrap = true
+		cfg.Consul.Agent.Servers.LAN = []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+
+		controller = &fakes.Controller{}
+		configWriter = &fakes.ConfigWriter{}
+		bootstrapChecker = &fakes.BootstrapChecker{}
+		logger = &fakes.Logger{}
+		timeout = &fakes.Timeout{}
+
+		server = chaperon.NewBootstrapExpectServer(controller, configWriter, bootstrapChecker, logger)
+	})
+
+	Describe("Start", func() {
+		It("writes the configuration once with bootstrap_expect and boots the agent once", func() {
+			Expect(server.Start(cfg, timeout)).To(Succeed())
+
+			expectedConfig := cfg
+			expectedConfig.Confab.ServerStartStrategy = "bootstrap_expect"
+			expectedConfig.Consul.Agent.Bootstrap = false
+
+			Expect(configWriter.WriteCall.Configs).To(Equal([]config.Config{expectedConfig}))
+			Expect(controller.WriteServiceDefinitionsCall.CallCount).To(Equal(1))
+			Expect(controller.BootAgentCall.CallCount).To(Equal(1))
+			Expect(controller.BootAgentCall.Receives.Timeout).To(Equal(timeout))
+			Expect(controller.ConfigureServerCall.CallCount).To(Equal(1))
+			Expect(controller.ConfigureServerCall.Receives.Timeout).To(Equal(timeout))
+			Expect(controller.StopAgentCall.CallCount).To(Equal(0))
+			Expect(bootstrapChecker.StartInBootstrapModeCall.CallCount).To(Equal(0))
+		})
+
+		It("configures a server joining a cluster created with the bootstrap strategy", func() {
+			bootstrapChecker.BootstrapNodeCall.Returns.Name = "consul-0"
+
+			Expect(server.Start(cfg, timeout)).To(Succeed())
+			Expect(controller.ConfigureServerCall.CallCount).To(Equal(1))
+			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "bootstrap-expect-server.start.migrating",
+					Data: []lager.Data{{
+						"bootstrap-node": "consul-0",
+					}},
+				},
+			}))
+		})
+
+		It("configures the server even when the bootstrap node cannot be determined", func() {
+			bootstrapChecker.BootstrapNodeCall.Returns.Error = errors.New("members failed")
+
+			Expect(server.Start(cfg, timeout)).To(Succeed())
+			Expect(controller.ConfigureServerCall.CallCount).To(Equal(1))
+		})
+
+		Context("when the cluster is still waiting for bootstrap_expect servers", func() {
+			BeforeEach(func() {
+				bootstrapChecker.AwaitingBootstrapPeersCall.Returns.Awaiting = true
+			})
+
+			It("writes the pid without configuring the server", func() {
+				Expect(server.Start(cfg, timeout)).To(Succeed())
+
+				Expect(bootstrapChecker.AwaitingBootstrapPeersCall.Receives.Expect).To(Equal(3))
+				Expect(controller.ConfigureServerCall.CallCount).To(Equal(0))
+				Expect(controller.WritePIDCall.CallCount).To(Equal(1))
+				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "bootstrap-expect-server.start.awaiting-bootstrap-peers",
+						Data: []lager.Data{{
+							"expect": 3,
+						}},
+					},
+				}))
+			})
+
+			It("returns an error when the pid cannot be written", func() {
+				controller.WritePIDCall.Returns.Error = errors.New("write pid failed")
+
+				Expect(server.Start(cfg, timeout)).To(MatchError("write pid failed"))
+			})
+		})
+
+		It("configures the server when it cannot tell whether the cluster is waiting", func() {
+			bootstrapChecker.AwaitingBootstrapPeersCall.Returns.Error = errors.New("members failed")
+
+			Expect(server.Start(cfg, timeout)).To(Succeed())
+			Expect(controller.ConfigureServerCall.CallCount).To(Equal(1))
+			Expect(controller.WritePIDCall.CallCount).To(Equal(0))
+		})
+
+		Context("failure cases", func() {
+			It("returns an error without writing anything when there are no lan servers", func() {
+				cfg.Consul.Agent.Servers.LAN = nil
+
+				err := server.Start(cfg, timeout)
+				Expect(err).To(MatchError("the bootstrap_expect server start strategy needs at least one consul.agent.servers.lan entry"))
+				Expect(configWriter.WriteCall.Configs).To(BeEmpty())
+				Expect(controller.BootAgentCall.CallCount).To(Equal(0))
+			})
+
+			It("returns an error when the configuration cannot be written", func() {
+				configWriter.WriteCall.Returns.Error = errors.New("write failed")
+
+				Expect(server.Start(cfg, timeout)).To(MatchError("write failed"))
+				Expect(controller.BootAgentCall.CallCount).To(Equal(0))
+			})
+
+			It("returns an error when the service definitions cannot be written", func() {
+				controller.WriteServiceDefinitionsCall.Returns.Error = errors.New("definitions failed")
+
+				Expect(server.Start(cfg, timeout)).To(MatchError("definitions failed"))
+				Expect(controller.BootAgentCall.CallCount).To(Equal(0))
+			})
+
+			It("returns an error when the agent cannot be booted", func() {
+				controller.BootAgentCall.Returns.Error = errors.New("boot failed")
+
+				Expect(server.Start(cfg, timeout)).To(MatchError("boot failed"))
+				Expect(controller.ConfigureServerCall.CallCount).To(Equal(0))
+			})
+
+			It("returns an error when the server cannot be configured", func() {
+				controller.ConfigureServerCall.Returns.Error = errors.New("configure failed")
+
+				Expect(server.Start(cfg, timeout)).To(MatchError("configure failed"))
+			})
+		})
+	})
+
+	Describe("Stop", func() {
+		It("stops the agent", func() {
+			server.Stop()
+			Expect(controller.StopAgentCall.CallCount).To(Equal(1))
+		})
+	})
+})
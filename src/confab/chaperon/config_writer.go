@@ -1,19 +1,27 @@
 package chaperon
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	"code.cloudfoundry.org/lager"
 
 	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
 )
 
+// configFileMode keeps the generated configuration, which holds the encrypt
+// key and ACL tokens, from being read by other users.
+const configFileMode = 0640
+
 type ConfigWriter struct {
 	dir    string
 	logger logger
@@ -27,30 +35,19 @@ func NewConfigWriter(dir string, logger logger) ConfigWriter {
 }
 
 func (w ConfigWriter) Write(cfg config.Config) error {
-	var err error
-
-	nodeName := cfg.Consul.Agent.NodeName
-
-	if nodeName == "" {
-		nodeName, err = getNodeName(cfg.Path.DataDir, cfg.Node.Name, cfg.Node.Index)
-		if err != nil {
-			w.logger.Error("config-writer.write.determine-node-name.failed", err)
-			return err
-		}
-	} else {
-		nodeName, err = getNodeName(cfg.Path.DataDir, nodeName, cfg.Node.Index)
-		if err != nil {
-			w.logger.Error("config-writer.write.determine-node-name.failed", err)
-			return err
-		}
+	identity, err := w.determineIdentity(cfg, true)
+	if err != nil {
+		w.logger.Error("config-writer.write.determine-node-name.failed", err)
+		return err
 	}
 
 	w.logger.Info("config-writer.write.determine-node-name", lager.Data{
-		"node-name": nodeName,
+		"node-name": identity.NodeName,
+		"node-id":   identity.NodeID,
 	})
 
 	w.logger.Info("config-writer.write.generate-configuration")
-	consulConfig := config.GenerateConfiguration(cfg, w.dir, nodeName)
+	consulConfig := config.GenerateConfiguration(cfg, w.dir, identity.NodeName, identity.NodeID)
 
 	data, err := json.Marshal(&consulConfig)
 	if err != nil {
@@ -60,53 +57,255 @@ func (w ConfigWriter) Write(cfg config.Config) error {
 	w.logger.Info("config-writer.write.write-file", lager.Data{
 		"config": consulConfig,
 	})
-	err = ioutil.WriteFile(filepath.Join(w.dir, "config.json"), data, os.ModePerm)
+	written, err := utils.WriteFileAtomically(filepath.Join(w.dir, "config.json"), data, configFileMode, utils.DirOwner(w.dir))
 	if err != nil {
 		w.logger.Error("config-writer.write.write-file.failed", errors.New(err.Error()))
 		return err
 	}
 
+	if !written {
+		w.logger.Info("config-writer.write.write-file.unchanged")
+	}
+
 	w.logger.Info("config-writer.write.success")
 	return nil
 }
 
-type node struct {
-	NodeName string `json:"node_name"`
+// Render returns the contents Write would put in config.json without writing
+// config.json or persisting the node identity. node_id is left out until an
+// identity has been persisted, so that rendering is repeatable.
+func (w ConfigWriter) Render(cfg config.Config) ([]byte, error) {
+	identity, err := w.determineIdentity(cfg, false)
+	if err != nil {
+		w.logger.Error("config-writer.render.determine-node-name.failed", err)
+		return nil, err
+	}
+
+	w.logger.Info("config-writer.render.determine-node-name", lager.Data{
+		"node-name": identity.NodeName,
+		"node-id":   identity.NodeID,
+	})
+
+	w.logger.Info("config-writer.render.generate-configuration")
+	consulConfig := config.GenerateConfiguration(cfg, w.dir, identity.NodeName, identity.NodeID)
+
+	return json.Marshal(&consulConfig)
 }
 
-func getNodeName(dataDir string, nodeName string, nodeIndex int) (string, error) {
-	var oldNode node
-	var name string
+// Reidentify replaces the persisted node identity with a new one for the
+// instance in cfg. Consul binds a node name to the node ID that first
+// registered it, so the new node ID comes with a name the previous identity
+// did not use. The agent must be stopped, as it uses the new identity the next
+// time it starts.
+func (w ConfigWriter) Reidentify(cfg config.Config) (NodeIdentity, error) {
+	if _, err := os.Stat(cfg.Path.DataDir); err != nil {
+		w.logger.Error("config-writer.reidentify.data-dir.failed", err)
+		return NodeIdentity{}, err
+	}
+
+	path := filepath.Join(cfg.Path.DataDir, nodeIdentityFile)
 
-	_, err := os.Stat(dataDir)
+	// a malformed identity is replaced like any other
+	previous, _ := readNodeIdentity(path)
+
+	nodeID, err := newNodeID()
 	if err != nil {
-		return "", err
+		w.logger.Error("config-writer.reidentify.new-node-id.failed", err)
+		return NodeIdentity{}, err
 	}
 
-	buf, err := ioutil.ReadFile(filepath.Join(dataDir, "node-name.json"))
-	switch {
-	case err == nil:
-		err = json.Unmarshal(buf, &oldNode)
+	nodeName := defaultNodeName(w.nodeName(cfg), cfg.Node)
+	if nodeName == previous.NodeName {
+		nodeName = fmt.Sprintf("%s-%s", nodeName, nodeID[:8])
+	}
+
+	identity := NodeIdentity{
+		NodeName:   nodeName,
+		NodeID:     nodeID,
+		InstanceID: cfg.Node.ID,
+		Deployment: cfg.Node.Deployment,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	// the node ID consul generated for itself would be adopted again
+	consulNodeID := filepath.Join(cfg.Path.DataDir, consulNodeIDFile)
+	if err := os.Remove(consulNodeID); err != nil && !os.IsNotExist(err) {
+		w.logger.Error("config-writer.reidentify.remove-node-id.failed", err)
+		return NodeIdentity{}, err
+	}
+
+	if err := writeNodeIdentity(cfg.Path.DataDir, identity); err != nil {
+		w.logger.Error("config-writer.reidentify.write-identity.failed", err)
+		return NodeIdentity{}, err
+	}
+
+	w.logger.Info("config-writer.reidentify.success", lager.Data{
+		"previous-node-name": previous.NodeName,
+		"previous-node-id":   previous.NodeID,
+		"node-name":          identity.NodeName,
+		"node-id":            identity.NodeID,
+	})
+
+	return identity, nil
+}
+
+func (w ConfigWriter) nodeName(cfg config.Config) string {
+	if cfg.Consul.Agent.NodeName != "" {
+		return cfg.Consul.Agent.NodeName
+	}
+
+	return cfg.Node.Name
+}
+
+func (w ConfigWriter) determineIdentity(cfg config.Config, persist bool) (NodeIdentity, error) {
+	return getNodeIdentity(cfg.Path.DataDir, w.nodeName(cfg), cfg.Node, persist)
+}
+
+const (
+	nodeIdentityFile = "node-name.json"
+
+	// consulNodeIDFile is where consul keeps the node ID it generated for
+	// itself when none was configured.
+	consulNodeIDFile = "node-id"
+)
+
+// NodeIdentity is persisted in the data dir so that the agent keeps its node
+// name and ID when it restarts or its VM is recreated with the same disk. It
+// records the instance and deployment it was created for, which tells when a
+// disk has been attached to another instance.
+type NodeIdentity struct {
+	NodeName   string    `json:"node_name"`
+	NodeID     string    `json:"node_id,omitempty"`
+	InstanceID string    `json:"instance_id,omitempty"`
+	Deployment string    `json:"deployment,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func readNodeIdentity(path string) (NodeIdentity, error) {
+	var identity NodeIdentity
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return NodeIdentity{}, err
+	}
+
+	if err := json.Unmarshal(buf, &identity); err != nil {
+		return NodeIdentity{}, err
+	}
+
+	return identity, nil
+}
+
+// getNodeIdentity returns the identity persisted in dataDir, or a new one
+// named after nodeName and the node index. Identities persisted before node
+// IDs were pinned keep their name and adopt the node ID consul generated for
+// itself, or a new one when consul has not generated one. Without persist, a
+// node ID that would be new is left empty so that it is not rendered. An error
+// is returned when the identity belongs to another instance.
+func getNodeIdentity(dataDir string, nodeName string, node config.ConfigNode, persist bool) (NodeIdentity, error) {
+	if persist {
+		_, err := os.Stat(dataDir)
 		if err != nil {
-			return "", err
+			return NodeIdentity{}, err
 		}
-		name = oldNode.NodeName
+	}
+
+	path := filepath.Join(dataDir, nodeIdentityFile)
+
+	identity, err := readNodeIdentity(path)
+	switch {
+	case err == nil:
 	case os.IsNotExist(err):
-		name = strings.Replace(nodeName, "_", "-", -1)
-		name = fmt.Sprintf("%s-%d", name, nodeIndex)
+		identity.NodeName = defaultNodeName(nodeName, node)
+	default:
+		return NodeIdentity{}, err
+	}
 
-		nodeNameJSON, err := json.Marshal(node{NodeName: name})
-		if err != nil {
-			return "", err
-		}
+	if identity.InstanceID != "" && node.ID != "" && identity.InstanceID != node.ID {
+		return NodeIdentity{}, fmt.Errorf("node identity in %s belongs to instance %s, not %s: run \"confab reidentify\" to give this node a new identity",
+			path, identity.InstanceID, node.ID)
+	}
+
+	persisted := identity
+
+	if identity.NodeID == "" {
+		identity.NodeID = readConsulNodeID(dataDir)
+	}
 
-		err = ioutil.WriteFile(filepath.Join(dataDir, "node-name.json"), []byte(nodeNameJSON), os.ModePerm)
+	if identity.NodeID == "" && persist {
+		nodeID, err := newNodeID()
 		if err != nil {
-			return "", err
+			return NodeIdentity{}, err
 		}
-	default:
+		identity.NodeID = nodeID
+	}
+
+	if identity.InstanceID == "" {
+		identity.InstanceID = node.ID
+	}
+
+	if identity.Deployment == "" {
+		identity.Deployment = node.Deployment
+	}
+
+	if identity.CreatedAt.IsZero() {
+		identity.CreatedAt = time.Now().UTC()
+	}
+
+	if !persist || identity == persisted {
+		return identity, nil
+	}
+
+	if err := writeNodeIdentity(dataDir, identity); err != nil {
+		return NodeIdentity{}, err
+	}
+
+	return identity, nil
+}
+
+func writeNodeIdentity(dataDir string, identity NodeIdentity) error {
+	identityJSON, err := json.Marshal(identity)
+	if err != nil {
+		return err
+	}
+
+	_, err = utils.WriteFileAtomically(filepath.Join(dataDir, nodeIdentityFile), identityJSON, configFileMode, utils.DirOwner(dataDir))
+	return err
+}
+
+func defaultNodeName(nodeName string, node config.ConfigNode) string {
+	return fmt.Sprintf("%s-%d", strings.Replace(nodeName, "_", "-", -1), node.Index)
+}
+
+// readConsulNodeID returns the node ID consul generated for itself in dataDir,
+// or an empty string when there is none.
+func readConsulNodeID(dataDir string) string {
+	buf, err := ioutil.ReadFile(filepath.Join(dataDir, consulNodeIDFile))
+	if err != nil {
+		return ""
+	}
+
+	nodeID := strings.TrimSpace(string(buf))
+	if !nodeIDPattern.MatchString(nodeID) {
+		return ""
+	}
+
+	return nodeID
+}
+
+var nodeIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// newNodeID returns a random UUID, which is the form consul requires node IDs
+// to take.
+func newNodeID() (string, error) {
+	var buf [16]byte
+
+	if _, err := rand.Read(buf[:]); err != nil {
 		return "", err
 	}
 
-	return name, nil
+	buf[6] = buf[6]&0x0f | 0x40
+	buf[8] = buf[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", buf[0:4], buf[4:6], buf[6:8], buf[8:10], buf[10:]), nil
 }
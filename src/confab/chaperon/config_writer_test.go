@@ -2,11 +2,12 @@ package chaperon_test
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	"code.cloudfoundry.org/lager"
 
@@ -28,6 +29,18 @@ var _ = Describe("ConfigWriter", func() {
 		logger    *fakes.Logger
 	)
 
+	readIdentity := func() chaperon.NodeIdentity {
+		buf, err := ioutil.ReadFile(filepath.Join(dataDir, "node-name.json"))
+		Expect(err).NotTo(HaveOccurred())
+
+		var identity chaperon.NodeIdentity
+		Expect(json.Unmarshal(buf, &identity)).To(Succeed())
+
+		return identity
+	}
+
+	const uuidPattern = `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`
+
 	Describe("Write", func() {
 		BeforeEach(func() {
 			logger = &fakes.Logger{}
@@ -54,6 +67,8 @@ var _ = Describe("ConfigWriter", func() {
 			err := writer.Write(cfg)
 			Expect(err).NotTo(HaveOccurred())
 
+			nodeID := readIdentity().NodeID
+
 			buf, err := ioutil.ReadFile(filepath.Join(configDir, "config.json"))
 			Expect(err).NotTo(HaveOccurred())
 
@@ -64,6 +79,7 @@ var _ = Describe("ConfigWriter", func() {
 				"data_dir":               dataDir,
 				"log_level":              "",
 				"node_name":              "node-0",
+				"node_id":                nodeID,
 				"rejoin_after_leave":     true,
 				"bind_addr":              "",
 				"disable_remote_exec":    true,
@@ -95,6 +111,12 @@ var _ = Describe("ConfigWriter", func() {
 			Expect(err).To(BeNil())
 			Expect(buf).To(MatchJSON(body))
 
+			if runtime.GOOS != "windows" {
+				info, err := os.Stat(filepath.Join(configDir, "config.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0640)))
+			}
+
 			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 				{
 					Action: "config-writer.write.generate-configuration",
@@ -102,7 +124,7 @@ var _ = Describe("ConfigWriter", func() {
 				{
 					Action: "config-writer.write.write-file",
 					Data: []lager.Data{{
-						"config": config.GenerateConfiguration(cfg, configDir, "node-0"),
+						"config": config.GenerateConfiguration(cfg, configDir, "node-0", nodeID),
 					}},
 				},
 				{
@@ -113,16 +135,21 @@ var _ = Describe("ConfigWriter", func() {
 
 		Context("node name", func() {
 			Context("when node-name.json does not exist", func() {
-				It("uses the job name-index and writes node-name.json", func() {
-					err := writer.Write(cfg)
-					Expect(err).NotTo(HaveOccurred())
+				It("uses the job name-index and writes a new identity to node-name.json", func() {
+					cfg.Node.ID = "some-instance-id"
+					cfg.Node.Deployment = "some-deployment"
 
-					buf, err := ioutil.ReadFile(filepath.Join(dataDir, "node-name.json"))
+					err := writer.Write(cfg)
 					Expect(err).NotTo(HaveOccurred())
 
-					Expect(buf).To(MatchJSON(`{"node_name":"node-0"}`))
+					identity := readIdentity()
+					Expect(identity.NodeName).To(Equal("node-0"))
+					Expect(identity.NodeID).To(MatchRegexp(uuidPattern))
+					Expect(identity.InstanceID).To(Equal("some-instance-id"))
+					Expect(identity.Deployment).To(Equal("some-deployment"))
+					Expect(identity.CreatedAt).To(BeTemporally("~", time.Now(), time.Minute))
 
-					buf, err = ioutil.ReadFile(filepath.Join(configDir, "config.json"))
+					buf, err := ioutil.ReadFile(filepath.Join(configDir, "config.json"))
 					Expect(err).NotTo(HaveOccurred())
 
 					var config map[string]interface{}
@@ -130,33 +157,45 @@ var _ = Describe("ConfigWriter", func() {
 					err = json.Unmarshal(buf, &config)
 					Expect(err).NotTo(HaveOccurred())
 					Expect(config["node_name"]).To(Equal("node-0"))
+					Expect(config["node_id"]).To(Equal(identity.NodeID))
 
 					Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 						{
 							Action: "config-writer.write.determine-node-name",
 							Data: []lager.Data{{
 								"node-name": "node-0",
+								"node-id":   identity.NodeID,
 							}},
 						},
 					}))
 				})
+
+				It("keeps the same node id across writes", func() {
+					Expect(writer.Write(cfg)).To(Succeed())
+					identity := readIdentity()
+
+					Expect(writer.Write(cfg)).To(Succeed())
+					Expect(readIdentity()).To(Equal(identity))
+				})
 			})
 
 			Context("when node-name.json exists", func() {
-				It("uses the the name from the file", func() {
-					err := ioutil.WriteFile(filepath.Join(dataDir, "node-name.json"),
-						[]byte(`{"node_name": "some-node-name"}`), os.ModePerm)
+				It("uses the identity from the file", func() {
+					err := ioutil.WriteFile(filepath.Join(dataDir, "node-name.json"), []byte(`{
+						"node_name": "some-node-name",
+						"node_id": "8d3b2f1e-4c5a-4e6f-9a7b-1c2d3e4f5a6b",
+						"instance_id": "some-instance-id",
+						"deployment": "some-deployment",
+						"created_at": "2017-01-02T03:04:05Z"
+					}`), os.ModePerm)
 					Expect(err).NotTo(HaveOccurred())
 
-					err = writer.Write(cfg)
-					Expect(err).NotTo(HaveOccurred())
+					cfg.Node.ID = "some-instance-id"
 
-					buf, err := ioutil.ReadFile(filepath.Join(dataDir, "node-name.json"))
+					err = writer.Write(cfg)
 					Expect(err).NotTo(HaveOccurred())
 
-					Expect(buf).To(MatchJSON(`{"node_name":"some-node-name"}`))
-
-					buf, err = ioutil.ReadFile(filepath.Join(configDir, "config.json"))
+					buf, err := ioutil.ReadFile(filepath.Join(configDir, "config.json"))
 					Expect(err).NotTo(HaveOccurred())
 
 					var config map[string]interface{}
@@ -164,6 +203,42 @@ var _ = Describe("ConfigWriter", func() {
 					err = json.Unmarshal(buf, &config)
 					Expect(err).NotTo(HaveOccurred())
 					Expect(config["node_name"]).To(Equal("some-node-name"))
+					Expect(config["node_id"]).To(Equal("8d3b2f1e-4c5a-4e6f-9a7b-1c2d3e4f5a6b"))
+				})
+
+				It("keeps the name from a file written before node ids were pinned and fills in the rest", func() {
+					err := ioutil.WriteFile(filepath.Join(dataDir, "node-name.json"),
+						[]byte(`{"node_name": "some-node-name"}`), os.ModePerm)
+					Expect(err).NotTo(HaveOccurred())
+
+					cfg.Node.ID = "some-instance-id"
+					cfg.Node.Deployment = "some-deployment"
+
+					err = writer.Write(cfg)
+					Expect(err).NotTo(HaveOccurred())
+
+					identity := readIdentity()
+					Expect(identity.NodeName).To(Equal("some-node-name"))
+					Expect(identity.NodeID).To(MatchRegexp(uuidPattern))
+					Expect(identity.InstanceID).To(Equal("some-instance-id"))
+					Expect(identity.Deployment).To(Equal("some-deployment"))
+					Expect(identity.CreatedAt.IsZero()).To(BeFalse())
+				})
+
+				It("adopts the node id consul generated for a file written before node ids were pinned", func() {
+					err := ioutil.WriteFile(filepath.Join(dataDir, "node-name.json"),
+						[]byte(`{"node_name": "some-node-name"}`), os.ModePerm)
+					Expect(err).NotTo(HaveOccurred())
+
+					err = ioutil.WriteFile(filepath.Join(dataDir, "node-id"),
+						[]byte("2f9c1d7e-6b3a-4f58-8e21-0a4b5c6d7e8f\n"), os.ModePerm)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(writer.Write(cfg)).To(Succeed())
+
+					identity := readIdentity()
+					Expect(identity.NodeName).To(Equal("some-node-name"))
+					Expect(identity.NodeID).To(Equal("2f9c1d7e-6b3a-4f58-8e21-0a4b5c6d7e8f"))
 				})
 			})
 
@@ -223,7 +298,21 @@ var _ = Describe("ConfigWriter", func() {
 					Expect(err).NotTo(HaveOccurred())
 
 					err = writer.Write(cfg)
-					Expect(err).To(MatchError(ContainSubstring("node-name.json: permission denied")))
+					Expect(err).To(MatchError(ContainSubstring("permission denied")))
+					Expect(filepath.Join(dataDir, "node-name.json")).NotTo(BeAnExistingFile())
+				})
+
+				It("returns an error when node-name.json belongs to another instance", func() {
+					err := ioutil.WriteFile(filepath.Join(dataDir, "node-name.json"),
+						[]byte(`{"node_name": "some-node-name", "instance_id": "other-instance-id"}`), os.ModePerm)
+					Expect(err).NotTo(HaveOccurred())
+
+					cfg.Node.ID = "some-instance-id"
+
+					err = writer.Write(cfg)
+					Expect(err).To(MatchError(ContainSubstring("belongs to instance other-instance-id, not some-instance-id")))
+					Expect(err).To(MatchError(ContainSubstring(`run "confab reidentify"`)))
+					Expect(filepath.Join(configDir, "config.json")).NotTo(BeAnExistingFile())
 				})
 
 				It("returns an error when node-name.json cannot be read", func() {
@@ -246,7 +335,7 @@ var _ = Describe("ConfigWriter", func() {
 				Expect(os.Mkdir(configFile, os.ModeDir)).To(Succeed())
 
 				err := writer.Write(cfg)
-				Expect(err).To(MatchError(ContainSubstring("is a directory")))
+				Expect(err).To(HaveOccurred())
 
 				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 					{
@@ -255,15 +344,107 @@ var _ = Describe("ConfigWriter", func() {
 					{
 						Action: "config-writer.write.write-file",
 						Data: []lager.Data{{
-							"config": config.GenerateConfiguration(cfg, configDir, "node-0"),
+							"config": config.GenerateConfiguration(cfg, configDir, "node-0", readIdentity().NodeID),
 						}},
 					},
 					{
 						Action: "config-writer.write.write-file.failed",
-						Error:  fmt.Errorf("open %s: is a directory", filepath.Join(configDir, "config.json")),
+						Error:  errors.New(err.Error()),
 					},
 				}))
 			})
 		})
 	})
+
+	Describe("Reidentify", func() {
+		BeforeEach(func() {
+			logger = &fakes.Logger{}
+
+			var err error
+			configDir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			dataDir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			cfg = config.Config{}
+			cfg.Node = config.ConfigNode{Name: "node", Index: 1, ID: "some-instance-id", Deployment: "some-deployment"}
+			cfg.Path.ConsulConfigDir = configDir
+			cfg.Path.DataDir = dataDir
+
+			writer = chaperon.NewConfigWriter(configDir, logger)
+
+			err = ioutil.WriteFile(filepath.Join(dataDir, "node-name.json"), []byte(`{
+				"node_name": "some-node-name",
+				"node_id": "8d3b2f1e-4c5a-4e6f-9a7b-1c2d3e4f5a6b",
+				"instance_id": "other-instance-id"
+			}`), os.ModePerm)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("replaces the persisted identity with a new one for this instance", func() {
+			identity, err := writer.Reidentify(cfg)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(identity.NodeName).To(Equal("node-1"))
+			Expect(identity.NodeID).To(MatchRegexp(uuidPattern))
+			Expect(identity.NodeID).NotTo(Equal("8d3b2f1e-4c5a-4e6f-9a7b-1c2d3e4f5a6b"))
+			Expect(identity.InstanceID).To(Equal("some-instance-id"))
+			Expect(identity.Deployment).To(Equal("some-deployment"))
+			Expect(readIdentity()).To(Equal(identity))
+
+			Expect(writer.Write(cfg)).To(Succeed())
+
+			Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+				Action: "config-writer.reidentify.success",
+				Data: []lager.Data{{
+					"previous-node-name": "some-node-name",
+					"previous-node-id":   "8d3b2f1e-4c5a-4e6f-9a7b-1c2d3e4f5a6b",
+					"node-name":          "node-1",
+					"node-id":            identity.NodeID,
+				}},
+			}))
+		})
+
+		It("gives the node a new name when its name would not change", func() {
+			cfg.Node.ID = "other-instance-id"
+			err := ioutil.WriteFile(filepath.Join(dataDir, "node-name.json"), []byte(`{
+				"node_name": "node-1",
+				"node_id": "8d3b2f1e-4c5a-4e6f-9a7b-1c2d3e4f5a6b",
+				"instance_id": "other-instance-id"
+			}`), os.ModePerm)
+			Expect(err).NotTo(HaveOccurred())
+
+			identity, err := writer.Reidentify(cfg)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(identity.NodeName).To(Equal("node-1-" + identity.NodeID[:8]))
+			Expect(readIdentity()).To(Equal(identity))
+		})
+
+		It("removes the node id consul generated for itself", func() {
+			err := ioutil.WriteFile(filepath.Join(dataDir, "node-id"), []byte("2f9c1d7e-6b3a-4f58-8e21-0a4b5c6d7e8f"), os.ModePerm)
+			Expect(err).NotTo(HaveOccurred())
+
+			identity, err := writer.Reidentify(cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(identity.NodeID).NotTo(Equal("2f9c1d7e-6b3a-4f58-8e21-0a4b5c6d7e8f"))
+			Expect(filepath.Join(dataDir, "node-id")).NotTo(BeAnExistingFile())
+		})
+
+		It("creates an identity when none is persisted", func() {
+			Expect(os.Remove(filepath.Join(dataDir, "node-name.json"))).To(Succeed())
+
+			identity, err := writer.Reidentify(cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readIdentity()).To(Equal(identity))
+		})
+
+		It("returns an error when the data dir does not exist", func() {
+			cfg.Path.DataDir = "/some/fake/path"
+
+			_, err := writer.Reidentify(cfg)
+			Expect(err).To(BeAnOsIsNotExistError())
+		})
+	})
 })
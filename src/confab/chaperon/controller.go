@@ -2,6 +2,7 @@ package chaperon
 
 import (
 	"errors"
+	"sort"
 	"time"
 
 	"code.cloudfoundry.org/lager"
@@ -28,18 +29,23 @@ type agentClient interface {
 	Members(wan bool) ([]*api.AgentMember, error)
 	VerifyJoined() error
 	VerifySynced() error
-	SetKeys(encryptKeys []string, keyringFile string) error
+	VerifyRaftPeers() error
+	ACLToken(id, token string) (*api.ACLEntry, error)
+	SetACLToken(entry *api.ACLEntry, token string) error
+	SetKeys(encryptKeys []string, dataDir string) error
 	Leave() error
-	JoinMembers() error
+	JoinMembers() ([]agent.JoinOutcome, error)
 	Self() error
 	ListKeys() ([]string, error)
 	InstallKey(key string) error
 	UseKey(key string) error
 	RemoveKey(key string) error
+	RaftStats() (map[string]interface{}, error)
 }
 
 type serviceDefiner interface {
 	GenerateDefinitions(config.Config) ([]config.ServiceDefinition, error)
+	RemoveStaleDefinitions(string, []config.ServiceDefinition) ([]string, error)
 	WriteDefinitions(string, []config.ServiceDefinition) error
 }
 
@@ -78,16 +84,50 @@ func (c Controller) BootAgent(timeout utils.Timeout) error {
 	}
 
 	c.Logger.Info("controller.boot-agent.agent-client.join-members")
-	err = c.AgentClient.JoinMembers()
-	switch err {
-	case agent.NoMembersToJoinError:
-		c.Logger.Error("controller.boot-agent.agent-client.join-members.no-members-to-join", err)
-	case nil:
-	default:
+	var (
+		outcomes []agent.JoinOutcome
+		joinErr  error
+	)
+	err = c.Retrier.TryUntil(timeout, func() error {
+		outcomes, joinErr = c.AgentClient.JoinMembers()
+
+		// gossip keys are expected to mismatch while they are being rotated
+		if _, ok := joinErr.(*agent.GossipKeyMismatchError); ok {
+			c.Logger.Error("controller.boot-agent.agent-client.join-members.gossip-key-mismatch", joinErr)
+			return joinErr
+		}
+
+		return nil
+	})
+	if err != nil {
 		c.Logger.Error("controller.boot-agent.agent-client.join-members.failed", err)
 		return err
 	}
 
+	c.logJoinOutcomes(outcomes)
+
+	// VerifyJoined decides whether an agent with no members to join may start
+	if joinErr == agent.NoMembersToJoinError {
+		c.Logger.Error("controller.boot-agent.agent-client.join-members.no-members-to-join", joinErr)
+		joinErr = nil
+	}
+
+	switch joinErr.(type) {
+	case nil:
+	case *agent.TLSError:
+		c.Logger.Error("controller.boot-agent.agent-client.join-members.tls-failure", joinErr)
+		return joinErr
+	case *agent.ACLDeniedError:
+		c.Logger.Error("controller.boot-agent.agent-client.join-members.acl-denied", joinErr)
+		return joinErr
+	case *agent.InsufficientMembersError:
+		c.Logger.Error("controller.boot-agent.agent-client.join-members.insufficient-members", joinErr)
+		return joinErr
+	default:
+		c.Logger.Error("controller.boot-agent.agent-client.join-members.failed", joinErr)
+		return joinErr
+	}
+
 	c.Logger.Info("controller.boot-agent.verify-joined")
 
 	if err := c.AgentClient.VerifyJoined(); err != nil {
@@ -99,6 +139,88 @@ func (c Controller) BootAgent(timeout utils.Timeout) error {
 	return nil
 }
 
+// bootstrapACL waits for the leader to create the ACL master token, and then
+// creates or updates the declared ACL tokens. Every server does this, as the
+// writes are applied by the leader and are idempotent.
+func (c Controller) bootstrapACL(timeout utils.Timeout) error {
+	acl := c.Config.Consul.ACL
+	if !acl.Enabled() || acl.MasterToken == "" {
+		return nil
+	}
+
+	c.Logger.Info("controller.configure-server.bootstrap-acl.wait-for-master-token")
+	err := c.Retrier.TryUntil(timeout, func() error {
+		entry, err := c.AgentClient.ACLToken(acl.MasterToken, acl.MasterToken)
+		if err != nil {
+			return err
+		}
+
+		if entry == nil {
+			return errors.New("master token has not been created")
+		}
+
+		return nil
+	})
+	if err != nil {
+		c.Logger.Error("controller.configure-server.bootstrap-acl.wait-for-master-token.failed", err)
+		return err
+	}
+
+	var names []string
+	for name := range acl.Tokens {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		token := acl.Tokens[name]
+
+		tokenType := token.Type
+		if tokenType == "" {
+			tokenType = "client"
+		}
+
+		c.Logger.Info("controller.configure-server.bootstrap-acl.set-token", lager.Data{
+			"token":  name,
+			"type":   tokenType,
+			"policy": token.Policy,
+		})
+
+		err := c.AgentClient.SetACLToken(&api.ACLEntry{
+			ID:    token.ID,
+			Name:  name,
+			Type:  tokenType,
+			Rules: acl.Policies[token.Policy],
+		}, acl.MasterToken)
+		if err != nil {
+			c.Logger.Error("controller.configure-server.bootstrap-acl.set-token.failed", err, lager.Data{
+				"token": name,
+			})
+			return err
+		}
+	}
+
+	c.Logger.Info("controller.configure-server.bootstrap-acl.success")
+	return nil
+}
+
+func (c Controller) logJoinOutcomes(outcomes []agent.JoinOutcome) {
+	reachable := []string{}
+	unreachable := []string{}
+	for _, outcome := range outcomes {
+		if _, ok := outcome.Err.(*agent.UnreachableError); ok {
+			unreachable = append(unreachable, outcome.Member)
+		} else {
+			reachable = append(reachable, outcome.Member)
+		}
+	}
+
+	c.Logger.Info("controller.boot-agent.agent-client.join-members.outcomes", lager.Data{
+		"reachable":   reachable,
+		"unreachable": unreachable,
+	})
+}
+
 func (c Controller) ConfigureServer(timeout utils.Timeout) error {
 	if len(c.EncryptKeys) == 0 {
 		err := errors.New("encrypt keys cannot be empty if ssl is enabled")
@@ -112,12 +234,18 @@ func (c Controller) ConfigureServer(timeout utils.Timeout) error {
 		return err
 	}
 
+	c.Logger.Info("controller.configure-server.verify-raft-peers")
+	if err := c.Retrier.TryUntil(timeout, c.AgentClient.VerifyRaftPeers); err != nil {
+		c.Logger.Error("controller.configure-server.verify-raft-peers.failed", err)
+		return err
+	}
+
 	c.Logger.Info("controller.configure-server.set-keys", lager.Data{
 		"keys": c.EncryptKeys,
 	})
 
 	err := c.Retrier.TryUntil(timeout, func() error {
-		return c.AgentClient.SetKeys(c.EncryptKeys, c.Config.Path.KeyringFile)
+		return c.AgentClient.SetKeys(c.EncryptKeys, c.Config.Path.DataDir)
 	})
 	if err != nil {
 		c.Logger.Error("controller.configure-server.set-keys.failed", err, lager.Data{
@@ -126,6 +254,10 @@ func (c Controller) ConfigureServer(timeout utils.Timeout) error {
 		return err
 	}
 
+	if err := c.bootstrapACL(timeout); err != nil {
+		return err
+	}
+
 	if err := c.AgentRunner.WritePID(); err != nil {
 		c.Logger.Error("controller.configure-server.write-pid.failed", err)
 		return err
@@ -144,17 +276,33 @@ func (c Controller) ConfigureClient() error {
 	return nil
 }
 
-func (c Controller) StopAgent() {
-	c.Logger.Info("controller.stop-agent.leave")
-	if err := c.AgentClient.Leave(); err != nil {
-		c.Logger.Error("controller.stop-agent.leave.failed", err)
+// WritePID records the running agent's pid without configuring it, for a
+// server that has to wait for its peers before it can be configured.
+func (c Controller) WritePID() error {
+	if err := c.AgentRunner.WritePID(); err != nil {
+		c.Logger.Error("controller.write-pid.failed", err)
+		return err
+	}
 
-		c.Logger.Info("controller.stop-agent.stop")
-		if err = c.AgentRunner.Stop(); err != nil {
-			c.Logger.Error("controller.stop-agent.stop.failed", err)
+	return nil
+}
+
+// StopAgent stops the agent with the runner's signal ladder. Servers skip
+// leaving the cluster on SIGINT, so they are asked to leave through the API
+// first, and the ladder then makes sure the agent exits even if leaving fails.
+func (c Controller) StopAgent() {
+	if c.Config.Consul.Agent.Mode == "server" {
+		c.Logger.Info("controller.stop-agent.leave")
+		if err := c.AgentClient.Leave(); err != nil {
+			c.Logger.Error("controller.stop-agent.leave.failed", err)
 		}
 	}
 
+	c.Logger.Info("controller.stop-agent.stop")
+	if err := c.AgentRunner.Stop(); err != nil {
+		c.Logger.Error("controller.stop-agent.stop.failed", err)
+	}
+
 	c.Logger.Info("controller.stop-agent.wait")
 	if err := c.AgentRunner.Wait(); err != nil {
 		c.Logger.Error("controller.stop-agent.wait.failed", err)
@@ -175,6 +323,14 @@ func (c Controller) WriteServiceDefinitions() error {
 		return err
 	}
 
+	// the agent is not running yet, so services of removed definitions are
+	// never registered and need not be deregistered
+	c.Logger.Info("controller.write-service-definitions.remove-stale-definitions")
+	if _, err := c.ServiceDefiner.RemoveStaleDefinitions(c.ConfigDir, definitions); err != nil {
+		c.Logger.Error("controller.write-service-definitions.remove-stale-definitions.failed", err)
+		return err
+	}
+
 	c.Logger.Info("controller.write-service-definitions.write")
 	if err := c.ServiceDefiner.WriteDefinitions(c.ConfigDir, definitions); err != nil {
 		c.Logger.Error("controller.write-service-definitions.write.failed", err)
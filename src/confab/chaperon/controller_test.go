@@ -11,6 +11,7 @@ import (
 	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
 	"github.com/cloudfoundry-incubator/consul-release/src/confab/fakes"
 	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
+	"github.com/hashicorp/consul/api"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -41,7 +42,7 @@ var _ = Describe("Controller", func() {
 
 		confabConfig := config.Config{}
 		confabConfig.Node = config.ConfigNode{Name: "node", Index: 0}
-		confabConfig.Path = config.ConfigPath{KeyringFile: "some-keyring-file-path"}
+		confabConfig.Path = config.ConfigPath{DataDir: "some-data-dir"}
 
 		controller = chaperon.Controller{
 			AgentClient:    agentClient,
@@ -82,6 +83,8 @@ var _ = Describe("Controller", func() {
 
 			Expect(controller.WriteServiceDefinitions()).To(Succeed())
 			Expect(serviceDefiner.GenerateDefinitionsCall.Receives.Config).To(Equal(controller.Config))
+			Expect(serviceDefiner.RemoveStaleDefinitionsCall.Receives.ConfigDir).To(Equal("/tmp/config"))
+			Expect(serviceDefiner.RemoveStaleDefinitionsCall.Receives.Definitions).To(Equal(definitions))
 			Expect(serviceDefiner.WriteDefinitionsCall.Receives.ConfigDir).To(Equal("/tmp/config"))
 			Expect(serviceDefiner.WriteDefinitionsCall.Receives.Definitions).To(Equal(definitions))
 
@@ -89,6 +92,9 @@ var _ = Describe("Controller", func() {
 				{
 					Action: "controller.write-service-definitions.generate-definitions",
 				},
+				{
+					Action: "controller.write-service-definitions.remove-stale-definitions",
+				},
 				{
 					Action: "controller.write-service-definitions.write",
 				},
@@ -98,6 +104,19 @@ var _ = Describe("Controller", func() {
 			}))
 		})
 
+		Context("when stale definitions cannot be removed", func() {
+			It("returns the error without writing definitions", func() {
+				serviceDefiner.RemoveStaleDefinitionsCall.Returns.Error = errors.New("remove failed")
+
+				Expect(controller.WriteServiceDefinitions()).To(MatchError("remove failed"))
+				Expect(serviceDefiner.WriteDefinitionsCall.Receives.ConfigDir).To(BeEmpty())
+				Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+					Action: "controller.write-service-definitions.remove-stale-definitions.failed",
+					Error:  errors.New("remove failed"),
+				}))
+			})
+		})
+
 		Context("when there is an error", func() {
 			It("returns the error", func() {
 				serviceDefiner.WriteDefinitionsCall.Returns.Error = errors.New("write definitions error")
@@ -107,7 +126,7 @@ var _ = Describe("Controller", func() {
 
 				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 					{
-						Action: "controller.write-service-definitions.generate-definitions",
+						Action: "controller.write-service-definitions.remove-stale-definitions",
 					},
 					{
 						Action: "controller.write-service-definitions.write",
@@ -123,6 +142,12 @@ var _ = Describe("Controller", func() {
 
 	Describe("BootAgent", func() {
 		It("launches the consul agent and confirms that it joined the cluster", func() {
+			agentClient.JoinMembersCall.Returns.Outcomes = []agent.JoinOutcome{
+				{Member: "member-1", Attempts: 1},
+				{Member: "member-2", Attempts: 3, Err: &agent.UnreachableError{Member: "member-2", Err: errors.New("connection refused")}},
+				{Member: "member-3", Attempts: 1},
+			}
+
 			Expect(controller.BootAgent(utils.NewTimeout(make(chan time.Time)))).To(Succeed())
 
 			Expect(agentClient.JoinMembersCall.CallCount).To(Equal(1))
@@ -140,6 +165,13 @@ var _ = Describe("Controller", func() {
 				{
 					Action: "controller.boot-agent.agent-client.join-members",
 				},
+				{
+					Action: "controller.boot-agent.agent-client.join-members.outcomes",
+					Data: []lager.Data{{
+						"reachable":   []string{"member-1", "member-3"},
+						"unreachable": []string{"member-2"},
+					}},
+				},
 				{
 					Action: "controller.boot-agent.verify-joined",
 				},
@@ -220,6 +252,13 @@ var _ = Describe("Controller", func() {
 						{
 							Action: "controller.boot-agent.agent-client.join-members",
 						},
+						{
+							Action: "controller.boot-agent.agent-client.join-members.outcomes",
+							Data: []lager.Data{{
+								"reachable":   []string{},
+								"unreachable": []string{},
+							}},
+						},
 						{
 							Action: "controller.boot-agent.agent-client.join-members.no-members-to-join",
 							Error:  agent.NoMembersToJoinError,
@@ -229,6 +268,105 @@ var _ = Describe("Controller", func() {
 				})
 			})
 
+			Context("when the gossip keys do not match", func() {
+				It("retries until the members can be joined", func() {
+					gossipKeyErr := &agent.GossipKeyMismatchError{Member: "member-1", Err: errors.New("No installed keys could decrypt the message")}
+					agentClient.JoinMembersCall.Returns.Errors = []error{gossipKeyErr, gossipKeyErr, nil}
+
+					err := controller.BootAgent(utils.NewTimeout(make(chan time.Time)))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(agentClient.JoinMembersCall.CallCount).To(Equal(3))
+					Expect(agentClient.VerifyJoinedCalls.CallCount).To(Equal(1))
+
+					Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+						{
+							Action: "controller.boot-agent.agent-client.join-members",
+						},
+						{
+							Action: "controller.boot-agent.agent-client.join-members.gossip-key-mismatch",
+							Error:  gossipKeyErr,
+						},
+						{
+							Action: "controller.boot-agent.agent-client.join-members.gossip-key-mismatch",
+							Error:  gossipKeyErr,
+						},
+						{
+							Action: "controller.boot-agent.agent-client.join-members.outcomes",
+							Data: []lager.Data{{
+								"reachable":   []string{},
+								"unreachable": []string{},
+							}},
+						},
+						{
+							Action: "controller.boot-agent.verify-joined",
+						},
+					}))
+				})
+
+				It("returns an error when the timeout is exceeded", func() {
+					agentClient.JoinMembersCall.Returns.Error = &agent.GossipKeyMismatchError{Member: "member-1", Err: errors.New("No installed keys could decrypt the message")}
+
+					err := controller.BootAgent(utils.NewTimeout(time.After(10 * time.Millisecond)))
+					Expect(err).To(MatchError(ContainSubstring("timeout exceeded")))
+					Expect(agentClient.VerifyJoinedCalls.CallCount).To(Equal(0))
+				})
+			})
+
+			Context("when the tls handshake fails", func() {
+				It("returns the error without retrying", func() {
+					tlsErr := &agent.TLSError{Member: "member-1", Err: errors.New("x509: certificate signed by unknown authority")}
+					agentClient.JoinMembersCall.Returns.Error = tlsErr
+
+					err := controller.BootAgent(utils.NewTimeout(make(chan time.Time)))
+					Expect(err).To(Equal(tlsErr))
+					Expect(agentClient.JoinMembersCall.CallCount).To(Equal(1))
+
+					Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+						{
+							Action: "controller.boot-agent.agent-client.join-members.tls-failure",
+							Error:  tlsErr,
+						},
+					}))
+				})
+			})
+
+			Context("when the acl token may not join", func() {
+				It("returns the error without retrying", func() {
+					aclDeniedErr := &agent.ACLDeniedError{Member: "member-1", Err: errors.New("Permission denied")}
+					agentClient.JoinMembersCall.Returns.Error = aclDeniedErr
+
+					err := controller.BootAgent(utils.NewTimeout(make(chan time.Time)))
+					Expect(err).To(Equal(aclDeniedErr))
+					Expect(agentClient.JoinMembersCall.CallCount).To(Equal(1))
+
+					Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+						{
+							Action: "controller.boot-agent.agent-client.join-members.acl-denied",
+							Error:  aclDeniedErr,
+						},
+					}))
+				})
+			})
+
+			Context("when too few members were joined for the join policy", func() {
+				It("returns the error without retrying", func() {
+					insufficientMembersErr := &agent.InsufficientMembersError{Policy: agent.JoinPolicyMajority, Joined: 2, Required: 3}
+					agentClient.JoinMembersCall.Returns.Error = insufficientMembersErr
+
+					err := controller.BootAgent(utils.NewTimeout(make(chan time.Time)))
+					Expect(err).To(Equal(insufficientMembersErr))
+					Expect(agentClient.JoinMembersCall.CallCount).To(Equal(1))
+					Expect(agentClient.VerifyJoinedCalls.CallCount).To(Equal(0))
+
+					Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+						{
+							Action: "controller.boot-agent.agent-client.join-members.insufficient-members",
+							Error:  insufficientMembersErr,
+						},
+					}))
+				})
+			})
+
 			Context("when fails with any other error", func() {
 				It("returns an error", func() {
 					agentClient.JoinMembersCall.Returns.Error = errors.New("some error")
@@ -239,6 +377,13 @@ var _ = Describe("Controller", func() {
 						{
 							Action: "controller.boot-agent.agent-client.join-members",
 						},
+						{
+							Action: "controller.boot-agent.agent-client.join-members.outcomes",
+							Data: []lager.Data{{
+								"reachable":   []string{},
+								"unreachable": []string{},
+							}},
+						},
 						{
 							Action: "controller.boot-agent.agent-client.join-members.failed",
 							Error:  errors.New("some error"),
@@ -269,14 +414,15 @@ var _ = Describe("Controller", func() {
 	})
 
 	Describe("StopAgent", func() {
-		It("tells client to leave the cluster and waits for the agent to stop", func() {
+		It("stops the agent with the runner and waits for it to exit", func() {
 			controller.StopAgent()
-			Expect(agentClient.LeaveCall.CallCount).To(Equal(1))
+			Expect(agentClient.LeaveCall.CallCount).To(Equal(0))
+			Expect(agentRunner.StopCall.CallCount).To(Equal(1))
 			Expect(agentRunner.WaitCall.CallCount).To(Equal(1))
 			Expect(agentRunner.CleanupCall.CallCount).To(Equal(1))
 			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 				{
-					Action: "controller.stop-agent.leave",
+					Action: "controller.stop-agent.stop",
 				},
 				{
 					Action: "controller.stop-agent.wait",
@@ -290,16 +436,34 @@ var _ = Describe("Controller", func() {
 			}))
 		})
 
-		Context("when the agent client Leave() returns an error", func() {
+		Context("when the agent is a server", func() {
 			BeforeEach(func() {
-				agentClient.LeaveCall.Returns.Error = errors.New("leave error")
+				controller.Config.Consul.Agent.Mode = "server"
 			})
 
-			It("tells the runner to stop the agent", func() {
+			It("asks the server to leave the cluster before stopping it", func() {
+				controller.StopAgent()
+				Expect(agentClient.LeaveCall.CallCount).To(Equal(1))
+				Expect(agentRunner.StopCall.CallCount).To(Equal(1))
+				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "controller.stop-agent.leave",
+					},
+					{
+						Action: "controller.stop-agent.stop",
+					},
+					{
+						Action: "controller.stop-agent.wait",
+					},
+				}))
+			})
+
+			It("still stops the server when it fails to leave", func() {
+				agentClient.LeaveCall.Returns.Error = errors.New("leave error")
+
 				controller.StopAgent()
 				Expect(agentRunner.StopCall.CallCount).To(Equal(1))
 				Expect(agentRunner.WaitCall.CallCount).To(Equal(1))
-				Expect(agentRunner.CleanupCall.CallCount).To(Equal(1))
 				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 					{
 						Action: "controller.stop-agent.leave",
@@ -314,6 +478,28 @@ var _ = Describe("Controller", func() {
 					{
 						Action: "controller.stop-agent.wait",
 					},
+				}))
+			})
+		})
+
+		Context("when agent runner Stop() returns an error", func() {
+			BeforeEach(func() {
+				agentRunner.StopCall.Returns.Error = errors.New("stop error")
+			})
+
+			It("logs the error", func() {
+				controller.StopAgent()
+				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "controller.stop-agent.stop",
+					},
+					{
+						Action: "controller.stop-agent.stop.failed",
+						Error:  errors.New("stop error"),
+					},
+					{
+						Action: "controller.stop-agent.wait",
+					},
 					{
 						Action: "controller.stop-agent.cleanup",
 					},
@@ -322,41 +508,6 @@ var _ = Describe("Controller", func() {
 					},
 				}))
 			})
-
-			Context("when agent runner Stop() returns an error", func() {
-				BeforeEach(func() {
-					agentRunner.StopCall.Returns.Error = errors.New("stop error")
-				})
-
-				It("logs the error", func() {
-					controller.StopAgent()
-					Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
-						{
-							Action: "controller.stop-agent.leave",
-						},
-						{
-							Action: "controller.stop-agent.leave.failed",
-							Error:  errors.New("leave error"),
-						},
-						{
-							Action: "controller.stop-agent.stop",
-						},
-						{
-							Action: "controller.stop-agent.stop.failed",
-							Error:  errors.New("stop error"),
-						},
-						{
-							Action: "controller.stop-agent.wait",
-						},
-						{
-							Action: "controller.stop-agent.cleanup",
-						},
-						{
-							Action: "controller.stop-agent.success",
-						},
-					}))
-				})
-			})
 		})
 
 		Context("when agent runner Wait() returns an error", func() {
@@ -368,7 +519,7 @@ var _ = Describe("Controller", func() {
 				controller.StopAgent()
 				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 					{
-						Action: "controller.stop-agent.leave",
+						Action: "controller.stop-agent.stop",
 					},
 					{
 						Action: "controller.stop-agent.wait",
@@ -396,7 +547,7 @@ var _ = Describe("Controller", func() {
 				controller.StopAgent()
 				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 					{
-						Action: "controller.stop-agent.leave",
+						Action: "controller.stop-agent.stop",
 					},
 					{
 						Action: "controller.stop-agent.wait",
@@ -433,7 +584,7 @@ var _ = Describe("Controller", func() {
 					"key 2",
 					"key 3",
 				}))
-				Expect(agentClient.SetKeysCall.Receives.KeyringFile).To(Equal("some-keyring-file-path"))
+				Expect(agentClient.SetKeysCall.Receives.DataDir).To(Equal("some-data-dir"))
 				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 					{
 						Action: "controller.configure-server.set-keys",
@@ -458,7 +609,7 @@ var _ = Describe("Controller", func() {
 						"key 2",
 						"key 3",
 					}))
-					Expect(agentClient.SetKeysCall.Receives.KeyringFile).To(Equal("some-keyring-file-path"))
+					Expect(agentClient.SetKeysCall.Receives.DataDir).To(Equal("some-data-dir"))
 					Expect(agentRunner.WritePIDCall.CallCount).To(Equal(0))
 					Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 						{
@@ -508,6 +659,9 @@ var _ = Describe("Controller", func() {
 					{
 						Action: "controller.configure-server.verify-synced",
 					},
+					{
+						Action: "controller.configure-server.verify-raft-peers",
+					},
 					{
 						Action: "controller.configure-server.set-keys",
 						Data: []lager.Data{{
@@ -537,6 +691,9 @@ var _ = Describe("Controller", func() {
 						{
 							Action: "controller.configure-server.verify-synced",
 						},
+						{
+							Action: "controller.configure-server.verify-raft-peers",
+						},
 						{
 							Action: "controller.configure-server.set-keys",
 							Data: []lager.Data{{
@@ -575,6 +732,121 @@ var _ = Describe("Controller", func() {
 			})
 		})
 
+		Context("when verifying the raft peers", func() {
+			It("retries until the raft peers are verified", func() {
+				agentClient.VerifyRaftPeersCall.Returns.Errors = []error{
+					errors.New("expected servers are not raft peers: 10.0.0.3"),
+					nil,
+				}
+
+				Expect(controller.ConfigureServer(timeout)).To(Succeed())
+				Expect(agentClient.VerifyRaftPeersCall.CallCount).To(Equal(2))
+				Expect(agentClient.SetKeysCall.CallCount).To(Equal(1))
+			})
+
+			It("returns an error when the raft peers are not verified within the timeout", func() {
+				agentClient.VerifyRaftPeersCall.Returns.Error = errors.New("10.0.0.1 is not a raft voter")
+				timeout = utils.NewTimeout(time.After(10 * time.Millisecond))
+
+				err := controller.ConfigureServer(timeout)
+				Expect(err).To(MatchError(`timeout exceeded: "10.0.0.1 is not a raft voter"`))
+				Expect(agentClient.SetKeysCall.CallCount).To(Equal(0))
+
+				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "controller.configure-server.verify-raft-peers.failed",
+						Error:  errors.New(`timeout exceeded: "10.0.0.1 is not a raft voter"`),
+					},
+				}))
+			})
+		})
+
+		Context("when acls are configured", func() {
+			BeforeEach(func() {
+				controller.Config.Consul.ACL = config.ConfigConsulACL{
+					Datacenter:  "dc1",
+					MasterToken: "master-token",
+					Policies: map[string]string{
+						"router": `service "gorouter" { policy = "write" }`,
+					},
+					Tokens: map[string]config.ConfigConsulACLToken{
+						"router": {ID: "router-token", Policy: "router"},
+						"admin":  {ID: "admin-token", Type: "management"},
+					},
+				}
+				agentClient.ACLTokenCall.Returns.ACLEntries = []*api.ACLEntry{nil, {ID: "master-token"}}
+			})
+
+			It("waits for the master token and sets the declared tokens", func() {
+				Expect(controller.ConfigureServer(timeout)).To(Succeed())
+				Expect(agentClient.ACLTokenCall.CallCount).To(Equal(2))
+				Expect(agentClient.ACLTokenCall.Receives.ID).To(Equal("master-token"))
+				Expect(agentClient.SetACLTokenCall.Receives.Token).To(Equal("master-token"))
+				Expect(agentClient.SetACLTokenCall.Receives.Entries).To(Equal([]*api.ACLEntry{
+					{ID: "admin-token", Name: "admin", Type: "management"},
+					{ID: "router-token", Name: "router", Type: "client", Rules: `service "gorouter" { policy = "write" }`},
+				}))
+
+				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "controller.configure-server.bootstrap-acl.wait-for-master-token",
+					},
+					{
+						Action: "controller.configure-server.bootstrap-acl.set-token",
+						Data: []lager.Data{{
+							"token":  "admin",
+							"type":   "management",
+							"policy": "",
+						}},
+					},
+					{
+						Action: "controller.configure-server.bootstrap-acl.set-token",
+						Data: []lager.Data{{
+							"token":  "router",
+							"type":   "client",
+							"policy": "router",
+						}},
+					},
+					{
+						Action: "controller.configure-server.bootstrap-acl.success",
+					},
+				}))
+			})
+
+			It("returns an error when the master token is not created within the timeout", func() {
+				agentClient.ACLTokenCall.Returns.ACLEntries = nil
+				timeout = utils.NewTimeout(time.After(10 * time.Millisecond))
+
+				err := controller.ConfigureServer(timeout)
+				Expect(err).To(MatchError(`timeout exceeded: "master token has not been created"`))
+				Expect(agentClient.SetACLTokenCall.CallCount).To(Equal(0))
+				Expect(agentRunner.WritePIDCall.CallCount).To(Equal(0))
+			})
+
+			It("returns an error when a token cannot be set", func() {
+				agentClient.SetACLTokenCall.Returns.Error = errors.New("Permission denied")
+
+				Expect(controller.ConfigureServer(timeout)).To(MatchError("Permission denied"))
+				Expect(agentRunner.WritePIDCall.CallCount).To(Equal(0))
+				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+					{
+						Action: "controller.configure-server.bootstrap-acl.set-token.failed",
+						Error:  errors.New("Permission denied"),
+						Data: []lager.Data{{
+							"token": "admin",
+						}},
+					},
+				}))
+			})
+
+			It("does not bootstrap acls without a master token", func() {
+				controller.Config.Consul.ACL.MasterToken = ""
+
+				Expect(controller.ConfigureServer(timeout)).To(Succeed())
+				Expect(agentClient.ACLTokenCall.CallCount).To(Equal(0))
+			})
+		})
+
 		Context("when writing the PID file fails", func() {
 			It("returns the error", func() {
 				agentRunner.WritePIDCall.Returns.Error = errors.New("failed to write PIDFILE")
@@ -0,0 +1,201 @@
+package chaperon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/hashicorp/consul/api"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
+)
+
+const drainStateFile = "drain.json"
+
+type drainClient interface {
+	NodeName() (string, error)
+	RaftConfiguration() (*api.RaftConfiguration, error)
+	Members(wan bool) ([]*api.AgentMember, error)
+}
+
+type DrainerConfig struct {
+	RetryInterval time.Duration
+	Deadline      time.Duration
+}
+
+type drainState struct {
+	DeferredSince time.Time `json:"deferred_since"`
+}
+
+type Drainer struct {
+	controller controller
+	client     drainClient
+	clock      supervisorClock
+	config     DrainerConfig
+	dataDir    string
+	logger     logger
+}
+
+func NewDrainer(controller controller, client drainClient, clock supervisorClock, config DrainerConfig, dataDir string, logger logger) Drainer {
+	return Drainer{
+		controller: controller,
+		client:     client,
+		clock:      clock,
+		config:     config,
+		dataDir:    dataDir,
+		logger:     logger,
+	}
+}
+
+// Drain returns the value for the BOSH dynamic drain protocol. When stopping
+// a server would leave the cluster without quorum it returns a negative wait
+// time so that BOSH retries later, otherwise it stops the agent with a
+// graceful leave and returns 0. checkStatus is true when BOSH is retrying a
+// deferred drain; once the drain has been deferred for longer than Deadline
+// the agent is stopped regardless.
+func (d Drainer) Drain(cfg config.Config, checkStatus bool) int {
+	if !checkStatus {
+		d.removeState()
+	}
+
+	if cfg.Consul.Agent.Mode == "server" && utils.IsRunningProcess(cfg.Path.PIDFile) {
+		d.logger.Info("drainer.drain.check-quorum")
+		if err := d.checkQuorum(); err != nil {
+			now := d.clock.Now()
+			state := d.readState()
+			if state.DeferredSince.IsZero() {
+				state.DeferredSince = now
+				d.writeState(state)
+			}
+
+			deferred := now.Sub(state.DeferredSince)
+			if deferred < d.config.Deadline {
+				d.logger.Error("drainer.drain.defer", err, lager.Data{
+					"deferred": deferred.String(),
+					"deadline": d.config.Deadline.String(),
+				})
+				return -int(d.config.RetryInterval / time.Second)
+			}
+
+			d.logger.Error("drainer.drain.deadline-exceeded", err, lager.Data{
+				"deferred": deferred.String(),
+				"deadline": d.config.Deadline.String(),
+			})
+		}
+	}
+
+	d.logger.Info("drainer.drain.stop-agent")
+	d.controller.StopAgent()
+	d.removeState()
+
+	d.logger.Info("drainer.drain.success")
+	return 0
+}
+
+// checkQuorum returns an error unless the other healthy voting servers can
+// keep quorum without this node. A node that is not a voter, or is the only
+// voter, cannot affect quorum by leaving.
+func (d Drainer) checkQuorum() error {
+	nodeName, err := d.client.NodeName()
+	if err != nil {
+		return err
+	}
+
+	raftConfiguration, err := d.client.RaftConfiguration()
+	if err != nil {
+		return err
+	}
+
+	members, err := d.client.Members(false)
+	if err != nil {
+		return err
+	}
+
+	alive := map[string]bool{}
+	for _, member := range members {
+		if member.Status >= 0 && member.Status < len(memberStatuses) && memberStatuses[member.Status] == "alive" {
+			alive[member.Name] = true
+		}
+	}
+
+	var (
+		voters  int
+		healthy int
+		isVoter bool
+	)
+	for _, server := range raftConfiguration.Servers {
+		if !server.Voter {
+			continue
+		}
+
+		voters++
+		if server.Node == nodeName {
+			isVoter = true
+			continue
+		}
+
+		if alive[server.Node] {
+			healthy++
+		}
+	}
+
+	quorum := voters/2 + 1
+
+	d.logger.Info("drainer.drain.check-quorum.peers", lager.Data{
+		"node":    nodeName,
+		"voters":  voters,
+		"healthy": healthy,
+		"quorum":  quorum,
+	})
+
+	if !isVoter || voters == 1 {
+		return nil
+	}
+
+	if healthy < quorum {
+		return fmt.Errorf("stopping %s would leave %d of %d servers healthy, below quorum of %d", nodeName, healthy, voters, quorum)
+	}
+
+	return nil
+}
+
+func (d Drainer) readState() drainState {
+	var state drainState
+
+	contents, err := ioutil.ReadFile(filepath.Join(d.dataDir, drainStateFile))
+	if err != nil {
+		return state
+	}
+
+	if err := json.Unmarshal(contents, &state); err != nil {
+		d.logger.Error("drainer.read-state.failed", err)
+	}
+
+	return state
+}
+
+func (d Drainer) writeState(state drainState) {
+	path := filepath.Join(d.dataDir, drainStateFile)
+
+	contents, err := json.Marshal(state)
+	if err != nil {
+		panic(err) // not tested, drainState always marshals
+	}
+
+	if _, err := utils.WriteFileAtomically(path, contents, configFileMode, utils.DirOwner(d.dataDir)); err != nil {
+		d.logger.Error("drainer.write-state.failed", err, lager.Data{
+			"path": path,
+		})
+	}
+}
+
+func (d Drainer) removeState() {
+	if err := os.Remove(filepath.Join(d.dataDir, drainStateFile)); err != nil && !os.IsNotExist(err) {
+		d.logger.Error("drainer.remove-state.failed", err)
+	}
+}
@@ -0,0 +1,203 @@
+package chaperon_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/chaperon"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/fakes"
+	"github.com/hashicorp/consul/api"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/pivotal-cf-experimental/gomegamatchers"
+)
+
+var _ = Describe("Drainer", func() {
+	var (
+		dataDir     string
+		controller  *fakes.Controller
+		agentClient *fakes.AgentClient
+		clock       *fakes.Clock
+		logger      *fakes.Logger
+		cfg         config.Config
+		drainer     chaperon.Drainer
+	)
+
+	BeforeEach(func() {
+		var err error
+		dataDir, err = ioutil.TempDir("", "data")
+		Expect(err).NotTo(HaveOccurred())
+
+		pidFile := filepath.Join(dataDir, "consul.pid")
+		Expect(ioutil.WriteFile(pidFile, []byte(fmt.Sprintf("%d", os.Getpid())), 0644)).To(Succeed())
+
+		controller = &fakes.Controller{}
+		logger = &fakes.Logger{}
+
+		clock = &fakes.Clock{}
+		clock.NowCall.Returns.Time = time.Unix(1000, 0)
+
+		agentClient = &fakes.AgentClient{}
+		agentClient.NodeNameCall.Returns.NodeName = "consul-0"
+		agentClient.RaftConfigurationCall.Returns.RaftConfiguration = &api.RaftConfiguration{
+			Servers: []*api.RaftServer{
+				{Node: "consul-0", Address: "10.0.0.1:8300", Voter: true},
+				{Node: "consul-1", Address: "10.0.0.2:8300", Voter: true},
+				{Node: "consul-2", Address: "10.0.0.3:8300", Voter: true},
+			},
+		}
+		agentClient.MembersCall.Returns.Members = []*api.AgentMember{
+			{Name: "consul-0", Addr: "10.0.0.1", Status: 1},
+			{Name: "consul-1", Addr: "10.0.0.2", Status: 1},
+			{Name: "consul-2", Addr: "10.0.0.3", Status: 1},
+		}
+
+		cfg = config.Config{}
+		cfg.Path.PIDFile = pidFile
+		cfg.Consul.Agent.Mode = "server"
+
+		drainer = chaperon.NewDrainer(controller, agentClient, clock, chaperon.DrainerConfig{
+			RetryInterval: 10 * time.Second,
+			Deadline:      5 * time.Minute,
+		}, dataDir, logger)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dataDir)).To(Succeed())
+	})
+
+	It("stops the agent when the other servers keep quorum", func() {
+		Expect(drainer.Drain(cfg, false)).To(Equal(0))
+		Expect(controller.StopAgentCall.CallCount).To(Equal(1))
+		Expect(agentClient.MembersCall.Receives.WAN).To(BeFalse())
+
+		Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+			{
+				Action: "drainer.drain.check-quorum",
+			},
+			{
+				Action: "drainer.drain.check-quorum.peers",
+				Data: []lager.Data{{
+					"node":    "consul-0",
+					"voters":  3,
+					"healthy": 2,
+					"quorum":  2,
+				}},
+			},
+			{
+				Action: "drainer.drain.stop-agent",
+			},
+			{
+				Action: "drainer.drain.success",
+			},
+		}))
+	})
+
+	It("does not check quorum for clients", func() {
+		cfg.Consul.Agent.Mode = "client"
+
+		Expect(drainer.Drain(cfg, false)).To(Equal(0))
+		Expect(agentClient.RaftConfigurationCall.CallCount).To(Equal(0))
+		Expect(controller.StopAgentCall.CallCount).To(Equal(1))
+	})
+
+	It("does not check quorum when the agent is not running", func() {
+		cfg.Path.PIDFile = filepath.Join(dataDir, "does-not-exist.pid")
+
+		Expect(drainer.Drain(cfg, false)).To(Equal(0))
+		Expect(agentClient.RaftConfigurationCall.CallCount).To(Equal(0))
+		Expect(controller.StopAgentCall.CallCount).To(Equal(1))
+	})
+
+	It("stops the only server of a single node cluster", func() {
+		agentClient.RaftConfigurationCall.Returns.RaftConfiguration.Servers = []*api.RaftServer{
+			{Node: "consul-0", Address: "10.0.0.1:8300", Voter: true},
+		}
+
+		Expect(drainer.Drain(cfg, false)).To(Equal(0))
+		Expect(controller.StopAgentCall.CallCount).To(Equal(1))
+	})
+
+	Context("when stopping would lose quorum", func() {
+		BeforeEach(func() {
+			agentClient.MembersCall.Returns.Members[2].Status = 4
+		})
+
+		It("defers the drain with a negative wait time", func() {
+			Expect(drainer.Drain(cfg, false)).To(Equal(-10))
+			Expect(controller.StopAgentCall.CallCount).To(Equal(0))
+
+			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "drainer.drain.defer",
+					Error:  errors.New("stopping consul-0 would leave 1 of 3 servers healthy, below quorum of 2"),
+					Data: []lager.Data{{
+						"deferred": "0s",
+						"deadline": "5m0s",
+					}},
+				},
+			}))
+		})
+
+		It("writes the state file with the mode of the configuration", func() {
+			if runtime.GOOS == "windows" {
+				Skip("file modes are not enforced on Windows")
+			}
+
+			drainer.Drain(cfg, false)
+
+			info, err := os.Stat(filepath.Join(dataDir, "drain.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0640)))
+		})
+
+		It("ignores non-voting servers", func() {
+			agentClient.RaftConfigurationCall.Returns.RaftConfiguration.Servers[2].Voter = false
+			agentClient.RaftConfigurationCall.Returns.RaftConfiguration.Servers = append(agentClient.RaftConfigurationCall.Returns.RaftConfiguration.Servers,
+				&api.RaftServer{Node: "consul-3", Address: "10.0.0.4:8300", Voter: true})
+			agentClient.MembersCall.Returns.Members = append(agentClient.MembersCall.Returns.Members,
+				&api.AgentMember{Name: "consul-3", Addr: "10.0.0.4", Status: 1})
+
+			Expect(drainer.Drain(cfg, false)).To(Equal(0))
+			Expect(controller.StopAgentCall.CallCount).To(Equal(1))
+		})
+
+		It("stops the agent once the deadline has passed", func() {
+			Expect(drainer.Drain(cfg, false)).To(Equal(-10))
+
+			clock.NowCall.Returns.Time = time.Unix(1000, 0).Add(4 * time.Minute)
+			Expect(drainer.Drain(cfg, true)).To(Equal(-10))
+			Expect(controller.StopAgentCall.CallCount).To(Equal(0))
+
+			clock.NowCall.Returns.Time = time.Unix(1000, 0).Add(5 * time.Minute)
+			Expect(drainer.Drain(cfg, true)).To(Equal(0))
+			Expect(controller.StopAgentCall.CallCount).To(Equal(1))
+			Expect(filepath.Join(dataDir, "drain.json")).NotTo(BeAnExistingFile())
+		})
+
+		It("restarts the deadline for a new drain", func() {
+			Expect(drainer.Drain(cfg, false)).To(Equal(-10))
+
+			clock.NowCall.Returns.Time = time.Unix(1000, 0).Add(10 * time.Minute)
+			Expect(drainer.Drain(cfg, false)).To(Equal(-10))
+			Expect(controller.StopAgentCall.CallCount).To(Equal(0))
+		})
+
+		It("defers the drain when the raft configuration cannot be read", func() {
+			agentClient.MembersCall.Returns.Members[2].Status = 1
+			agentClient.RaftConfigurationCall.Returns.Error = errors.New("no cluster leader")
+
+			Expect(drainer.Drain(cfg, false)).To(Equal(-10))
+			Expect(controller.StopAgentCall.CallCount).To(Equal(0))
+		})
+	})
+})
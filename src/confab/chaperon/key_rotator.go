@@ -0,0 +1,169 @@
+package chaperon
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/hashicorp/consul/api"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/agent"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
+)
+
+type keyringClient interface {
+	Keyring() ([]*api.KeyringResponse, error)
+	InstallKey(key string) error
+	UseKey(key string) error
+	RemoveKey(key string) error
+}
+
+type KeyRotatorConfig struct {
+	SettleTime time.Duration
+}
+
+type KeyRotator struct {
+	client  keyringClient
+	retrier utils.Retrier
+	clock   clock
+	config  KeyRotatorConfig
+	logger  logger
+}
+
+func NewKeyRotator(client keyringClient, retrier utils.Retrier, clock clock, config KeyRotatorConfig, logger logger) KeyRotator {
+	return KeyRotator{
+		client:  client,
+		retrier: retrier,
+		clock:   clock,
+		config:  config,
+		logger:  logger,
+	}
+}
+
+// Rotate changes the gossip encryption keys of a running cluster to keys,
+// making the first of them the primary key. It installs the keys that are
+// missing, waits until every node in the LAN and WAN pools has them, switches
+// the primary key, waits SettleTime for the switch to propagate and removes
+// every other key. Each phase starts from the current keyring, so running
+// Rotate again resumes a rotation that was interrupted.
+func (r KeyRotator) Rotate(keys []string, timeout utils.Timeout) error {
+	if len(keys) == 0 {
+		err := errors.New("must provide at least one key")
+		r.logger.Error("key-rotator.rotate.no-keys", err)
+		return err
+	}
+
+	encryptedKeys := agent.EncryptKeys(keys)
+	primaryKey := encryptedKeys[0]
+
+	keyring, err := r.client.Keyring()
+	if err != nil {
+		r.logger.Error("key-rotator.rotate.keyring.failed", err)
+		return err
+	}
+
+	var missing []string
+	for _, key := range encryptedKeys {
+		if agent.KeysInstalled(keyring, []string{key}) != nil {
+			missing = append(missing, key)
+		}
+	}
+
+	r.logger.Info("key-rotator.rotate.install", lager.Data{
+		"missing": len(missing),
+	})
+
+	for _, key := range missing {
+		if err := r.client.InstallKey(key); err != nil {
+			r.logger.Error("key-rotator.rotate.install.failed", err)
+			return err
+		}
+	}
+
+	err = r.retrier.TryUntil(timeout, func() error {
+		keyring, err := r.client.Keyring()
+		if err != nil {
+			return err
+		}
+
+		return agent.KeysInstalled(keyring, encryptedKeys)
+	})
+	if err != nil {
+		r.logger.Error("key-rotator.rotate.install.verify.failed", err)
+		return err
+	}
+
+	r.logger.Info("key-rotator.rotate.use")
+	if err := r.client.UseKey(primaryKey); err != nil {
+		r.logger.Error("key-rotator.rotate.use.failed", err)
+		return err
+	}
+
+	keyring, err = r.client.Keyring()
+	if err != nil {
+		r.logger.Error("key-rotator.rotate.keyring.failed", err)
+		return err
+	}
+
+	retired := retiredKeys(keyring, encryptedKeys)
+	if len(retired) > 0 {
+		r.logger.Info("key-rotator.rotate.settle", lager.Data{
+			"settle-time": r.config.SettleTime.String(),
+		})
+		r.clock.Sleep(r.config.SettleTime)
+
+		r.logger.Info("key-rotator.rotate.remove", lager.Data{
+			"retired": len(retired),
+		})
+
+		for _, key := range retired {
+			if err := r.client.RemoveKey(key); err != nil {
+				r.logger.Error("key-rotator.rotate.remove.failed", err)
+				return err
+			}
+		}
+
+		err = r.retrier.TryUntil(timeout, func() error {
+			keyring, err := r.client.Keyring()
+			if err != nil {
+				return err
+			}
+
+			if remaining := retiredKeys(keyring, encryptedKeys); len(remaining) > 0 {
+				return fmt.Errorf("%d retired keys are still installed", len(remaining))
+			}
+
+			return nil
+		})
+		if err != nil {
+			r.logger.Error("key-rotator.rotate.remove.verify.failed", err)
+			return err
+		}
+	}
+
+	r.logger.Info("key-rotator.rotate.success")
+	return nil
+}
+
+func retiredKeys(keyring []*api.KeyringResponse, keys []string) []string {
+	retired := map[string]bool{}
+	for _, pool := range keyring {
+		for key := range pool.Keys {
+			retired[key] = true
+		}
+	}
+
+	for _, key := range keys {
+		delete(retired, key)
+	}
+
+	var sorted []string
+	for key := range retired {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	return sorted
+}
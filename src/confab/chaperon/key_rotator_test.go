@@ -0,0 +1,183 @@
+package chaperon_test
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/chaperon"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/fakes"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
+	"github.com/hashicorp/consul/api"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/pivotal-cf-experimental/gomegamatchers"
+)
+
+var _ = Describe("KeyRotator", func() {
+	const (
+		newKey     = "MDEyMzQ1Njc4OWFiY2RlZg=="
+		currentKey = "b2xka2V5b2xka2V5b2xkaw=="
+	)
+
+	var (
+		agentClient *fakes.AgentClient
+		clock       *fakes.Clock
+		logger      *fakes.Logger
+		timeout     utils.Timeout
+		keyRotator  chaperon.KeyRotator
+	)
+
+	keyring := func(keys map[string]int) []*api.KeyringResponse {
+		lan := map[string]int{}
+		wan := map[string]int{}
+		for key, nodes := range keys {
+			lan[key] = nodes
+			if nodes > 3 {
+				nodes = 3
+			}
+			wan[key] = nodes
+		}
+
+		return []*api.KeyringResponse{
+			{WAN: true, Datacenter: "dc1", Keys: wan, NumNodes: 3},
+			{WAN: false, Datacenter: "dc1", Keys: lan, NumNodes: 5},
+		}
+	}
+
+	BeforeEach(func() {
+		agentClient = &fakes.AgentClient{}
+		clock = &fakes.Clock{}
+		logger = &fakes.Logger{}
+		timeout = utils.NewTimeout(time.After(100 * time.Millisecond))
+
+		keyRotator = chaperon.NewKeyRotator(agentClient, utils.NewRetrier(clock, 10*time.Millisecond),
+			clock, chaperon.KeyRotatorConfig{SettleTime: 30 * time.Second}, logger)
+	})
+
+	It("installs the new key everywhere, makes it primary and removes the retired key", func() {
+		agentClient.KeyringCall.Returns.Keyrings = [][]*api.KeyringResponse{
+			keyring(map[string]int{currentKey: 5}),
+			keyring(map[string]int{currentKey: 5, newKey: 2}),
+			keyring(map[string]int{currentKey: 5, newKey: 5}),
+			keyring(map[string]int{currentKey: 5, newKey: 5}),
+		}
+		agentClient.KeyringCall.Returns.Keyring = keyring(map[string]int{newKey: 5})
+
+		Expect(keyRotator.Rotate([]string{newKey}, timeout)).To(Succeed())
+
+		Expect(agentClient.InstallKeyCall.Receives.Keys).To(Equal([]string{newKey}))
+		Expect(agentClient.UseKeyCall.Receives.Keys).To(Equal([]string{newKey}))
+		Expect(agentClient.RemoveKeyCall.Receives.Keys).To(Equal([]string{currentKey}))
+		Expect(clock.SleepCall.Receives.Durations).To(ContainElement(30 * time.Second))
+
+		Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+			{
+				Action: "key-rotator.rotate.install",
+				Data:   []lager.Data{{"missing": 1}},
+			},
+			{
+				Action: "key-rotator.rotate.use",
+			},
+			{
+				Action: "key-rotator.rotate.settle",
+				Data:   []lager.Data{{"settle-time": "30s"}},
+			},
+			{
+				Action: "key-rotator.rotate.remove",
+				Data:   []lager.Data{{"retired": 1}},
+			},
+			{
+				Action: "key-rotator.rotate.success",
+			},
+		}))
+	})
+
+	It("hashes keys that are not base64 encoded", func() {
+		agentClient.KeyringCall.Returns.Keyring = keyring(map[string]int{
+			"enqzXBmgKOy13WIGsmUk+g==": 5,
+		})
+
+		Expect(keyRotator.Rotate([]string{"banana"}, timeout)).To(Succeed())
+		Expect(agentClient.UseKeyCall.Receives.Key).To(Equal("enqzXBmgKOy13WIGsmUk+g=="))
+	})
+
+	Context("when a previous rotation was interrupted after switching the primary key", func() {
+		It("only removes the retired key", func() {
+			agentClient.KeyringCall.Returns.Keyrings = [][]*api.KeyringResponse{
+				keyring(map[string]int{currentKey: 5, newKey: 5}),
+				keyring(map[string]int{currentKey: 5, newKey: 5}),
+				keyring(map[string]int{currentKey: 5, newKey: 5}),
+			}
+			agentClient.KeyringCall.Returns.Keyring = keyring(map[string]int{newKey: 5})
+
+			Expect(keyRotator.Rotate([]string{newKey}, timeout)).To(Succeed())
+
+			Expect(agentClient.InstallKeyCall.CallCount).To(Equal(0))
+			Expect(agentClient.UseKeyCall.Receives.Keys).To(Equal([]string{newKey}))
+			Expect(agentClient.RemoveKeyCall.Receives.Keys).To(Equal([]string{currentKey}))
+		})
+	})
+
+	Context("when the rotation has already completed", func() {
+		It("does not wait or remove any keys", func() {
+			agentClient.KeyringCall.Returns.Keyring = keyring(map[string]int{newKey: 5})
+
+			Expect(keyRotator.Rotate([]string{newKey}, timeout)).To(Succeed())
+
+			Expect(agentClient.InstallKeyCall.CallCount).To(Equal(0))
+			Expect(agentClient.RemoveKeyCall.CallCount).To(Equal(0))
+			Expect(clock.SleepCall.Receives.Durations).NotTo(ContainElement(30 * time.Second))
+		})
+	})
+
+	Context("failure cases", func() {
+		It("returns an error when no keys are given", func() {
+			Expect(keyRotator.Rotate([]string{}, timeout)).To(MatchError("must provide at least one key"))
+		})
+
+		It("returns an error when the keyring cannot be listed", func() {
+			agentClient.KeyringCall.Returns.Error = errors.New("keyring list failed")
+
+			Expect(keyRotator.Rotate([]string{newKey}, timeout)).To(MatchError("keyring list failed"))
+			Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+				Action: "key-rotator.rotate.keyring.failed",
+				Error:  errors.New("keyring list failed"),
+			}))
+		})
+
+		It("returns an error when the key cannot be installed", func() {
+			agentClient.KeyringCall.Returns.Keyring = keyring(map[string]int{currentKey: 5})
+			agentClient.InstallKeyCall.Returns.Error = errors.New("install failed")
+
+			Expect(keyRotator.Rotate([]string{newKey}, timeout)).To(MatchError("install failed"))
+			Expect(agentClient.UseKeyCall.CallCount).To(Equal(0))
+		})
+
+		It("times out when a node never receives the new key", func() {
+			agentClient.KeyringCall.Returns.Keyring = keyring(map[string]int{currentKey: 5, newKey: 4})
+
+			err := keyRotator.Rotate([]string{newKey}, timeout)
+			Expect(err).To(MatchError(`timeout exceeded: "key MDEyMzQ1Njc4OWFiY2RlZg== is installed on 4 of 5 nodes in the lan pool of dc1"`))
+			Expect(agentClient.UseKeyCall.CallCount).To(Equal(0))
+			Expect(agentClient.RemoveKeyCall.CallCount).To(Equal(0))
+		})
+
+		It("returns an error when the primary key cannot be switched", func() {
+			agentClient.KeyringCall.Returns.Keyring = keyring(map[string]int{currentKey: 5, newKey: 5})
+			agentClient.UseKeyCall.Returns.Error = errors.New("use failed")
+
+			Expect(keyRotator.Rotate([]string{newKey}, timeout)).To(MatchError("use failed"))
+			Expect(agentClient.RemoveKeyCall.CallCount).To(Equal(0))
+		})
+
+		It("times out when a retired key is not removed", func() {
+			agentClient.KeyringCall.Returns.Keyring = keyring(map[string]int{currentKey: 5, newKey: 5})
+
+			err := keyRotator.Rotate([]string{newKey}, timeout)
+			Expect(err).To(MatchError(`timeout exceeded: "1 retired keys are still installed"`))
+		})
+	})
+})
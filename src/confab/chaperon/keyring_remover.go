@@ -2,32 +2,68 @@ package chaperon
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"code.cloudfoundry.org/lager"
 )
 
+const (
+	keyringBackupTimeFormat = "20060102T150405Z"
+
+	// keyringBackupsKept is the number of timestamped keyring backups kept,
+	// the oldest are removed once there are more.
+	keyringBackupsKept = 5
+)
+
 type KeyringRemover struct {
 	path   string
+	clock  supervisorClock
 	logger logger
 }
 
-func NewKeyringRemover(path string, logger logger) KeyringRemover {
+func NewKeyringRemover(path string, clock supervisorClock, logger logger) KeyringRemover {
 	return KeyringRemover{
 		path:   path,
+		clock:  clock,
 		logger: logger,
 	}
 }
 
+// Execute moves the keyring out of the agent's way to a backup named after the
+// current time, so that the agent starts with the keys in its configuration.
+// The backups hold gossip keys, so they are only readable by their owner and
+// only the most recent ones are kept.
 func (r KeyringRemover) Execute() error {
+	backup := fmt.Sprintf("%s.%s", r.path, r.clock.Now().UTC().Format(keyringBackupTimeFormat))
+
 	r.logger.Info("keyring-remover.execute", lager.Data{
 		"keyring": r.path,
+		"backup":  backup,
 	})
 
-	if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+	err := os.Rename(r.path, backup)
+	if err == nil {
+		err = os.Chmod(backup, 0600)
+	}
+
+	if err != nil && !os.IsNotExist(err) {
 		err = errors.New(err.Error())
 		r.logger.Error("keyring-remover.execute.failed", err, lager.Data{
 			"keyring": r.path,
+			"backup":  backup,
+		})
+
+		return err
+	}
+
+	if err := r.pruneBackups(); err != nil {
+		r.logger.Error("keyring-remover.execute.prune-backups.failed", err, lager.Data{
+			"keyring": r.path,
 		})
 
 		return err
@@ -35,7 +71,37 @@ func (r KeyringRemover) Execute() error {
 
 	r.logger.Info("keyring-remover.execute.success", lager.Data{
 		"keyring": r.path,
+		"backup":  backup,
 	})
 
 	return nil
 }
+
+func (r KeyringRemover) pruneBackups() error {
+	matches, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, r.path+".")
+		if _, err := time.Parse(keyringBackupTimeFormat, suffix); err == nil {
+			backups = append(backups, match)
+		}
+	}
+
+	if len(backups) <= keyringBackupsKept {
+		return nil
+	}
+
+	// the time format sorts the backups from the oldest to the most recent
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-keyringBackupsKept] {
+		if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
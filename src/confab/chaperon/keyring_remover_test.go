@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"runtime"
+	"time"
 
 	"code.cloudfoundry.org/lager"
 
@@ -21,6 +23,8 @@ var _ = Describe("KeyringRemover", func() {
 		var (
 			dataDir string
 			keyring string
+			backup  string
+			clock   *fakes.Clock
 			logger  *fakes.Logger
 			remover chaperon.KeyringRemover
 		)
@@ -30,39 +34,79 @@ var _ = Describe("KeyringRemover", func() {
 			dataDir, err = ioutil.TempDir("", "")
 			Expect(err).NotTo(HaveOccurred())
 
-			file, err := ioutil.TempFile(dataDir, "keyring")
-			Expect(err).NotTo(HaveOccurred())
-			defer file.Close()
-			keyring = file.Name()
+			keyring = filepath.Join(dataDir, "local.keyring")
+			Expect(ioutil.WriteFile(keyring, []byte(`["key-1"]`), 0644)).To(Succeed())
+			backup = filepath.Join(dataDir, "local.keyring.20161017T093015Z")
+
+			clock = &fakes.Clock{}
+			clock.NowCall.Returns.Time = time.Date(2016, time.October, 17, 9, 30, 15, 0, time.UTC)
 
 			logger = &fakes.Logger{}
 
-			remover = chaperon.NewKeyringRemover(keyring, logger)
+			remover = chaperon.NewKeyringRemover(keyring, clock, logger)
+		})
+
+		AfterEach(func() {
+			Expect(os.Chmod(dataDir, 0700)).To(Succeed())
+			Expect(os.RemoveAll(dataDir)).To(Succeed())
 		})
 
-		It("removes the keyring file", func() {
+		It("moves the keyring file to a timestamped backup only its owner can read", func() {
 			err := remover.Execute()
 			Expect(err).NotTo(HaveOccurred())
 
 			_, err = os.Stat(keyring)
 			Expect(err).To(BeAnOsIsNotExistError())
 
+			contents, err := ioutil.ReadFile(backup)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal(`["key-1"]`))
+
+			if runtime.GOOS != "windows" {
+				info, err := os.Stat(backup)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+			}
+
 			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
 				{
 					Action: "keyring-remover.execute",
 					Data: []lager.Data{{
 						"keyring": keyring,
+						"backup":  backup,
 					}},
 				},
 				{
 					Action: "keyring-remover.execute.success",
 					Data: []lager.Data{{
 						"keyring": keyring,
+						"backup":  backup,
 					}},
 				},
 			}))
 		})
 
+		It("keeps the most recent backups and removes the oldest", func() {
+			var earlier []string
+			for day := 10; day < 15; day++ {
+				path := filepath.Join(dataDir, fmt.Sprintf("local.keyring.201610%dT093015Z", day))
+				Expect(ioutil.WriteFile(path, []byte(`["key-0"]`), 0600)).To(Succeed())
+				earlier = append(earlier, path)
+			}
+
+			unrelated := filepath.Join(dataDir, "local.keyring.banana")
+			Expect(ioutil.WriteFile(unrelated, []byte(`banana`), 0644)).To(Succeed())
+
+			Expect(remover.Execute()).To(Succeed())
+
+			_, err := os.Stat(earlier[0])
+			Expect(err).To(BeAnOsIsNotExistError())
+
+			for _, path := range append(earlier[1:], backup, unrelated) {
+				Expect(path).To(BeAnExistingFile())
+			}
+		})
+
 		Context("when the file does not exist", func() {
 			It("does not error", func() {
 				err := os.Remove(keyring)
@@ -70,6 +114,9 @@ var _ = Describe("KeyringRemover", func() {
 
 				err = remover.Execute()
 				Expect(err).NotTo(HaveOccurred())
+
+				_, err = os.Stat(backup)
+				Expect(err).To(BeAnOsIsNotExistError())
 			})
 		})
 
@@ -93,13 +140,15 @@ var _ = Describe("KeyringRemover", func() {
 							Action: "keyring-remover.execute",
 							Data: []lager.Data{{
 								"keyring": keyring,
+								"backup":  backup,
 							}},
 						},
 						{
 							Action: "keyring-remover.execute.failed",
-							Error:  fmt.Errorf("remove %s: permission denied", keyring),
+							Error:  fmt.Errorf("rename %s %s: permission denied", keyring, backup),
 							Data: []lager.Data{{
 								"keyring": keyring,
+								"backup":  backup,
 							}},
 						},
 					}))
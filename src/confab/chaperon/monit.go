@@ -0,0 +1,43 @@
+package chaperon
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// Monit runs monit commands for the job that runs the agent, so that monit
+// does not restart the agent while confab works on it.
+type Monit struct {
+	path   string
+	job    string
+	logger logger
+}
+
+func NewMonit(path string, job string, logger logger) Monit {
+	return Monit{
+		path:   path,
+		job:    job,
+		logger: logger,
+	}
+}
+
+// Unmonitor stops monit from monitoring the job until "monit monitor" is run.
+func (m Monit) Unmonitor() error {
+	m.logger.Info("monit.unmonitor", lager.Data{
+		"job": m.job,
+	})
+
+	output, err := exec.Command(m.path, "unmonitor", m.job).CombinedOutput()
+	if err != nil {
+		err = fmt.Errorf("monit unmonitor %s failed: %s: %s", m.job, err, strings.TrimSpace(string(output)))
+		m.logger.Error("monit.unmonitor.failed", err, lager.Data{
+			"job": m.job,
+		})
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package chaperon_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/chaperon"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Monit", func() {
+	var (
+		dir    string
+		monit  chaperon.Monit
+		logger *fakes.Logger
+	)
+
+	writeMonit := func(script string) {
+		Expect(ioutil.WriteFile(filepath.Join(dir, "monit"), []byte("#!/bin/sh\n"+script), 0755)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		if runtime.GOOS == "windows" {
+			Skip("monit only runs on linux")
+		}
+
+		var err error
+		dir, err = ioutil.TempDir("", "monit")
+		Expect(err).NotTo(HaveOccurred())
+
+		logger = &fakes.Logger{}
+		monit = chaperon.NewMonit(filepath.Join(dir, "monit"), "consul_agent", logger)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	Describe("Unmonitor", func() {
+		It("unmonitors the job", func() {
+			writeMonit(`echo "$@" > "$(dirname "$0")/args"`)
+
+			Expect(monit.Unmonitor()).To(Succeed())
+
+			args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(args)).To(Equal("unmonitor consul_agent\n"))
+		})
+
+		It("returns an error with the output of monit when it fails", func() {
+			writeMonit(`echo "monit: cannot read status"; exit 1`)
+
+			err := monit.Unmonitor()
+			Expect(err).To(MatchError("monit unmonitor consul_agent failed: exit status 1: monit: cannot read status"))
+			Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+				Action: "monit.unmonitor.failed",
+				Error:  err,
+				Data: []lager.Data{{
+					"job": "consul_agent",
+				}},
+			}))
+		})
+	})
+})
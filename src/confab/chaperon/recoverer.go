@@ -0,0 +1,172 @@
+package chaperon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
+)
+
+type serverStatusClient interface {
+	Leader(address string) (string, error)
+}
+
+type jobMonitor interface {
+	Unmonitor() error
+}
+
+type Recoverer struct {
+	controller         controller
+	agentRunner        agentRunner
+	agentClient        agentClient
+	statusClient       statusClient
+	serverStatusClient serverStatusClient
+	monitor            jobMonitor
+	retrier            utils.Retrier
+	logger             logger
+}
+
+func NewRecoverer(controller controller, agentRunner agentRunner, agentClient agentClient, statusClient statusClient, serverStatusClient serverStatusClient, monitor jobMonitor, retrier utils.Retrier, logger logger) Recoverer {
+	return Recoverer{
+		controller:         controller,
+		agentRunner:        agentRunner,
+		agentClient:        agentClient,
+		statusClient:       statusClient,
+		serverStatusClient: serverStatusClient,
+		monitor:            monitor,
+		retrier:            retrier,
+		logger:             logger,
+	}
+}
+
+// Recover restarts a server that has lost quorum with a raft/peers.json
+// listing every LAN server, then waits for a leader to be elected and for the
+// raft log to sync. It refuses to run while the local agent or any LAN server
+// reports a leader, and unmonitors the job first so that monit does not
+// restart the agent underneath it. Servers are recovered one at a time and no
+// leader can be elected until a majority of them has been, so Recover returns
+// an error on the servers recovered before that, leaving their agents running.
+func (r Recoverer) Recover(cfg config.Config, timeout utils.Timeout) error {
+	r.logger.Info("recoverer.recover.check-leader")
+	if err := r.checkLeader(cfg.Consul.Agent.Servers.LAN); err != nil {
+		r.logger.Error("recoverer.recover.check-leader.leader-exists", err)
+		return err
+	}
+
+	peers, err := config.GeneratePeers(cfg)
+	if err != nil {
+		r.logger.Error("recoverer.recover.generate-peers.failed", err)
+		return err
+	}
+
+	r.logger.Info("recoverer.recover.unmonitor")
+	if err := r.monitor.Unmonitor(); err != nil {
+		r.logger.Error("recoverer.recover.unmonitor.failed", err)
+		return err
+	}
+
+	if utils.IsRunningProcess(cfg.Path.PIDFile) {
+		r.logger.Info("recoverer.recover.stop-agent")
+		if err := r.agentRunner.Stop(); err != nil {
+			r.logger.Error("recoverer.recover.stop-agent.failed", err)
+			return err
+		}
+
+		if err := r.agentRunner.Wait(); err != nil {
+			r.logger.Error("recoverer.recover.wait.failed", err)
+			return err
+		}
+
+		if err := r.agentRunner.Cleanup(); err != nil {
+			r.logger.Error("recoverer.recover.cleanup.failed", err)
+		}
+	}
+
+	raftDir := filepath.Join(cfg.Path.DataDir, "raft")
+	peersFile := filepath.Join(raftDir, "peers.json")
+
+	r.logger.Info("recoverer.recover.write-peers", lager.Data{
+		"path":  peersFile,
+		"peers": string(peers),
+	})
+
+	if err := os.MkdirAll(raftDir, 0755); err != nil {
+		err = errors.New(err.Error())
+		r.logger.Error("recoverer.recover.write-peers.failed", err)
+		return err
+	}
+
+	if _, err := utils.WriteFileAtomically(peersFile, peers, configFileMode, utils.DirOwner(cfg.Path.DataDir)); err != nil {
+		err = errors.New(err.Error())
+		r.logger.Error("recoverer.recover.write-peers.failed", err)
+		return err
+	}
+
+	r.logger.Info("recoverer.recover.boot-agent")
+	if err := r.controller.BootAgent(timeout); err != nil {
+		r.logger.Error("recoverer.recover.boot-agent.failed", err)
+		return err
+	}
+
+	if err := r.agentRunner.WritePID(); err != nil {
+		r.logger.Error("recoverer.recover.write-pid.failed", err)
+		return err
+	}
+
+	r.logger.Info("recoverer.recover.wait-for-leader")
+	err = r.retrier.TryUntil(timeout, func() error {
+		leader, err := r.statusClient.Leader()
+		if err != nil {
+			return err
+		}
+
+		if leader == "" {
+			return errors.New("cluster has no leader")
+		}
+
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("no leader was elected, which needs a majority of the servers to be recovered: %s", err)
+		r.logger.Error("recoverer.recover.wait-for-leader.failed", err)
+		return err
+	}
+
+	r.logger.Info("recoverer.recover.verify-synced")
+	if err := r.retrier.TryUntil(timeout, r.agentClient.VerifySynced); err != nil {
+		r.logger.Error("recoverer.recover.verify-synced.failed", err)
+		return err
+	}
+
+	r.logger.Info("recoverer.recover.success")
+	return nil
+}
+
+// checkLeader returns an error when the local agent or any of servers reports
+// a leader. Servers that cannot be reached are expected during an outage.
+func (r Recoverer) checkLeader(servers []string) error {
+	if leader, err := r.statusClient.Leader(); err == nil && leader != "" {
+		return fmt.Errorf("refusing to recover: cluster has a leader at %s", leader)
+	}
+
+	for _, server := range servers {
+		leader, err := r.serverStatusClient.Leader(server)
+		if err != nil {
+			r.logger.Error("recoverer.recover.check-leader.unreachable", err, lager.Data{
+				"server": server,
+			})
+			continue
+		}
+
+		if leader != "" {
+			return fmt.Errorf("refusing to recover: cluster has a leader at %s, reported by %s", leader, server)
+		}
+	}
+
+	return nil
+}
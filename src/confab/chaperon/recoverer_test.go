@@ -0,0 +1,231 @@
+package chaperon_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/chaperon"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/fakes"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/pivotal-cf-experimental/gomegamatchers"
+)
+
+var _ = Describe("Recoverer", func() {
+	var (
+		dataDir      string
+		controller   *fakes.Controller
+		agentRunner  *fakes.AgentRunner
+		agentClient  *fakes.AgentClient
+		statusClient *fakes.StatusClient
+		servers      *fakes.ServerStatusClient
+		monit        *fakes.Monit
+		logger       *fakes.Logger
+		timeout      utils.Timeout
+		cfg          config.Config
+		recoverer    chaperon.Recoverer
+	)
+
+	BeforeEach(func() {
+		var err error
+		dataDir, err = ioutil.TempDir("", "data")
+		Expect(err).NotTo(HaveOccurred())
+
+		controller = &fakes.Controller{}
+		agentRunner = &fakes.AgentRunner{}
+		agentClient = &fakes.AgentClient{}
+		agentClient.VerifySyncedCalls.Returns.Errors = []error{nil}
+		logger = &fakes.Logger{}
+		timeout = utils.NewTimeout(time.After(100 * time.Millisecond))
+
+		statusClient = &fakes.StatusClient{}
+		statusClient.LeaderCall.Stub = func() (string, error) {
+			if statusClient.LeaderCall.CallCount == 1 {
+				return "", nil
+			}
+			return "10.0.0.1:8300", nil
+		}
+
+		servers = &fakes.ServerStatusClient{}
+		servers.LeaderCall.Returns.Error = errors.New("connection refused")
+
+		monit = &fakes.Monit{}
+
+		cfg = config.Config{}
+		cfg.Path.DataDir = dataDir
+		cfg.Path.PIDFile = filepath.Join(dataDir, "does-not-exist.pid")
+		cfg.Consul.Agent.Mode = "server"
+		cfg.Consul.Agent.ProtocolVersion = 2
+		cfg.Consul.Agent.Servers.LAN = []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+
+		recoverer = chaperon.NewRecoverer(controller, agentRunner, agentClient, statusClient, servers, monit,
+			utils.NewRetrier(&fakes.Clock{}, 10*time.Millisecond), logger)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dataDir)).To(Succeed())
+	})
+
+	It("writes peers.json, boots the agent and waits for a synced leader", func() {
+		Expect(recoverer.Recover(cfg, timeout)).To(Succeed())
+
+		peers, err := ioutil.ReadFile(filepath.Join(dataDir, "raft", "peers.json"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(peers).To(MatchJSON(`["10.0.0.1:8300", "10.0.0.2:8300", "10.0.0.3:8300"]`))
+
+		if runtime.GOOS != "windows" {
+			info, err := os.Stat(filepath.Join(dataDir, "raft", "peers.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0640)))
+		}
+
+		Expect(servers.LeaderCall.Receives.Addresses).To(Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}))
+
+		Expect(monit.UnmonitorCall.CallCount).To(Equal(1))
+		Expect(agentRunner.StopCall.CallCount).To(Equal(0))
+		Expect(controller.BootAgentCall.CallCount).To(Equal(1))
+		Expect(agentRunner.WritePIDCall.CallCount).To(Equal(1))
+		Expect(statusClient.LeaderCall.CallCount).To(Equal(2))
+		Expect(agentClient.VerifySyncedCalls.CallCount).To(Equal(1))
+
+		Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+			{
+				Action: "recoverer.recover.check-leader",
+			},
+			{
+				Action: "recoverer.recover.unmonitor",
+			},
+			{
+				Action: "recoverer.recover.write-peers",
+				Data: []lager.Data{{
+					"path":  filepath.Join(dataDir, "raft", "peers.json"),
+					"peers": `["10.0.0.1:8300","10.0.0.2:8300","10.0.0.3:8300"]`,
+				}},
+			},
+			{
+				Action: "recoverer.recover.boot-agent",
+			},
+			{
+				Action: "recoverer.recover.wait-for-leader",
+			},
+			{
+				Action: "recoverer.recover.verify-synced",
+			},
+			{
+				Action: "recoverer.recover.success",
+			},
+		}))
+	})
+
+	It("stops the agent when it is running", func() {
+		Expect(ioutil.WriteFile(cfg.Path.PIDFile, []byte(fmt.Sprintf("%d", os.Getpid())), 0644)).To(Succeed())
+
+		Expect(recoverer.Recover(cfg, timeout)).To(Succeed())
+		Expect(agentRunner.StopCall.CallCount).To(Equal(1))
+		Expect(agentRunner.WaitCall.CallCount).To(Equal(1))
+		Expect(agentRunner.CleanupCall.CallCount).To(Equal(1))
+	})
+
+	It("proceeds when the leader cannot be determined", func() {
+		statusClient.LeaderCall.Stub = func() (string, error) {
+			if statusClient.LeaderCall.CallCount == 1 {
+				return "", errors.New("connection refused")
+			}
+			return "10.0.0.1:8300", nil
+		}
+
+		Expect(recoverer.Recover(cfg, timeout)).To(Succeed())
+		Expect(controller.BootAgentCall.CallCount).To(Equal(1))
+	})
+
+	Context("failure cases", func() {
+		It("refuses to run while a leader is reachable", func() {
+			statusClient.LeaderCall.Stub = nil
+			statusClient.LeaderCall.Returns.Leader = "10.0.0.2:8300"
+
+			Expect(recoverer.Recover(cfg, timeout)).To(MatchError("refusing to recover: cluster has a leader at 10.0.0.2:8300"))
+			Expect(controller.BootAgentCall.CallCount).To(Equal(0))
+			Expect(monit.UnmonitorCall.CallCount).To(Equal(0))
+			Expect(filepath.Join(dataDir, "raft", "peers.json")).NotTo(BeAnExistingFile())
+		})
+
+		It("refuses to run while another server reports a leader", func() {
+			statusClient.LeaderCall.Stub = func() (string, error) {
+				return "", errors.New("connection refused")
+			}
+			servers.LeaderCall.Stub = func(address string) (string, error) {
+				if address == "10.0.0.3" {
+					return "10.0.0.2:8300", nil
+				}
+				return "", errors.New("connection refused")
+			}
+
+			Expect(recoverer.Recover(cfg, timeout)).To(MatchError("refusing to recover: cluster has a leader at 10.0.0.2:8300, reported by 10.0.0.3"))
+			Expect(controller.BootAgentCall.CallCount).To(Equal(0))
+			Expect(filepath.Join(dataDir, "raft", "peers.json")).NotTo(BeAnExistingFile())
+		})
+
+		It("returns an error when peers.json cannot be generated", func() {
+			cfg.Consul.Agent.RaftProtocol = 3
+
+			Expect(recoverer.Recover(cfg, timeout)).To(MatchError(ContainSubstring("raft protocol 3")))
+			Expect(controller.BootAgentCall.CallCount).To(Equal(0))
+		})
+
+		It("returns an error without stopping the agent when the job cannot be unmonitored", func() {
+			Expect(ioutil.WriteFile(cfg.Path.PIDFile, []byte(fmt.Sprintf("%d", os.Getpid())), 0644)).To(Succeed())
+			monit.UnmonitorCall.Returns.Error = errors.New("unmonitor failed")
+
+			Expect(recoverer.Recover(cfg, timeout)).To(MatchError("unmonitor failed"))
+			Expect(agentRunner.StopCall.CallCount).To(Equal(0))
+			Expect(filepath.Join(dataDir, "raft", "peers.json")).NotTo(BeAnExistingFile())
+		})
+
+		It("returns an error when the agent cannot be stopped", func() {
+			Expect(ioutil.WriteFile(cfg.Path.PIDFile, []byte(fmt.Sprintf("%d", os.Getpid())), 0644)).To(Succeed())
+			agentRunner.StopCall.Returns.Error = errors.New("stop failed")
+
+			Expect(recoverer.Recover(cfg, timeout)).To(MatchError("stop failed"))
+			Expect(controller.BootAgentCall.CallCount).To(Equal(0))
+		})
+
+		It("returns an error when the agent cannot be booted", func() {
+			controller.BootAgentCall.Returns.Error = errors.New("boot failed")
+
+			Expect(recoverer.Recover(cfg, timeout)).To(MatchError("boot failed"))
+		})
+
+		It("returns an error when no leader is elected before the timeout", func() {
+			statusClient.LeaderCall.Stub = nil
+
+			err := recoverer.Recover(cfg, utils.NewTimeout(time.After(10*time.Millisecond)))
+			Expect(err).To(MatchError(`no leader was elected, which needs a majority of the servers to be recovered: timeout exceeded: "cluster has no leader"`))
+			Expect(agentClient.VerifySyncedCalls.CallCount).To(Equal(0))
+			Expect(agentRunner.StopCall.CallCount).To(Equal(0))
+
+			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "recoverer.recover.wait-for-leader.failed",
+					Error:  err,
+				},
+			}))
+		})
+
+		It("returns an error when the raft log does not sync before the timeout", func() {
+			agentClient.VerifySyncedCalls.Returns.Error = errors.New("not synced")
+
+			err := recoverer.Recover(cfg, utils.NewTimeout(time.After(10*time.Millisecond)))
+			Expect(err).To(MatchError(ContainSubstring("not synced")))
+		})
+	})
+})
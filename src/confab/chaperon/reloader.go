@@ -0,0 +1,108 @@
+package chaperon
+
+import (
+	"reflect"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
+)
+
+type reloadableRunner interface {
+	Reload() error
+}
+
+type reloaderAgentClient interface {
+	SetKeys(encryptKeys []string, dataDir string) error
+	DeregisterService(serviceID string) error
+}
+
+type Reloader struct {
+	configWriter   configWriter
+	serviceDefiner serviceDefiner
+	agentRunner    reloadableRunner
+	agentClient    reloaderAgentClient
+	retrier        utils.Retrier
+	logger         logger
+}
+
+func NewReloader(configWriter configWriter, serviceDefiner serviceDefiner, agentRunner reloadableRunner, agentClient reloaderAgentClient, retrier utils.Retrier, logger logger) Reloader {
+	return Reloader{
+		configWriter:   configWriter,
+		serviceDefiner: serviceDefiner,
+		agentRunner:    agentRunner,
+		agentClient:    agentClient,
+		retrier:        retrier,
+		logger:         logger,
+	}
+}
+
+// Reload rewrites the consul configuration and service definitions for cfg,
+// tells the running agent to reload them, deregisters the services whose
+// definitions were removed and installs the encrypt keys when they differ
+// from those in previous.
+func (r Reloader) Reload(previous, cfg config.Config, timeout utils.Timeout) error {
+	r.logger.Info("reloader.reload.write-config")
+	if err := r.configWriter.Write(cfg); err != nil {
+		r.logger.Error("reloader.reload.write-config.failed", err)
+		return err
+	}
+
+	r.logger.Info("reloader.reload.write-service-definitions")
+	definitions, err := r.serviceDefiner.GenerateDefinitions(cfg)
+	if err != nil {
+		r.logger.Error("reloader.reload.write-service-definitions.failed", err)
+		return err
+	}
+
+	removedServices, err := r.serviceDefiner.RemoveStaleDefinitions(cfg.Path.ConsulConfigDir, definitions)
+	if err != nil {
+		r.logger.Error("reloader.reload.write-service-definitions.failed", err)
+		return err
+	}
+
+	if err := r.serviceDefiner.WriteDefinitions(cfg.Path.ConsulConfigDir, definitions); err != nil {
+		r.logger.Error("reloader.reload.write-service-definitions.failed", err)
+		return err
+	}
+
+	r.logger.Info("reloader.reload.signal-agent")
+	if err := r.agentRunner.Reload(); err != nil {
+		r.logger.Error("reloader.reload.signal-agent.failed", err)
+		return err
+	}
+
+	// the agent drops services of removed definitions when it reloads, they
+	// are deregistered as well in case it kept them, and failures are not fatal
+	for _, serviceID := range removedServices {
+		r.logger.Info("reloader.reload.deregister-service", lager.Data{
+			"service": serviceID,
+		})
+
+		if err := r.agentClient.DeregisterService(serviceID); err != nil {
+			r.logger.Error("reloader.reload.deregister-service.failed", err, lager.Data{
+				"service": serviceID,
+			})
+		}
+	}
+
+	if !reflect.DeepEqual(previous.Consul.EncryptKeys, cfg.Consul.EncryptKeys) {
+		r.logger.Info("reloader.reload.set-keys", lager.Data{
+			"keys": cfg.Consul.EncryptKeys,
+		})
+
+		err := r.retrier.TryUntil(timeout, func() error {
+			return r.agentClient.SetKeys(cfg.Consul.EncryptKeys, cfg.Path.DataDir)
+		})
+		if err != nil {
+			r.logger.Error("reloader.reload.set-keys.failed", err, lager.Data{
+				"keys": cfg.Consul.EncryptKeys,
+			})
+			return err
+		}
+	}
+
+	r.logger.Info("reloader.reload.success")
+	return nil
+}
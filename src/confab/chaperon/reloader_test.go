@@ -0,0 +1,193 @@
+package chaperon_test
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/chaperon"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/fakes"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/pivotal-cf-experimental/gomegamatchers"
+)
+
+var _ = Describe("Reloader", func() {
+	var (
+		configWriter   *fakes.ConfigWriter
+		serviceDefiner *fakes.ServiceDefiner
+		agentRunner    *fakes.AgentRunner
+		agentClient    *fakes.AgentClient
+		logger         *fakes.Logger
+		timeout        utils.Timeout
+		previous       config.Config
+		cfg            config.Config
+		reloader       chaperon.Reloader
+	)
+
+	BeforeEach(func() {
+		configWriter = &fakes.ConfigWriter{}
+		serviceDefiner = &fakes.ServiceDefiner{}
+		agentRunner = &fakes.AgentRunner{}
+		agentClient = &fakes.AgentClient{}
+		logger = &fakes.Logger{}
+		timeout = utils.NewTimeout(make(chan time.Time))
+
+		previous = config.Config{}
+		previous.Path.ConsulConfigDir = "/some/config/dir"
+		previous.Path.DataDir = "/some/data/dir"
+		previous.Consul.EncryptKeys = []string{"key-1"}
+
+		cfg = previous
+		cfg.Consul.Agent.Services = map[string]config.ServiceDefinition{
+			"router": {},
+		}
+
+		serviceDefiner.GenerateDefinitionsCall.Returns.Definitions = []config.ServiceDefinition{
+			{ServiceName: "router"},
+		}
+
+		reloader = chaperon.NewReloader(configWriter, serviceDefiner, agentRunner, agentClient,
+			utils.NewRetrier(&fakes.Clock{}, 10*time.Millisecond), logger)
+	})
+
+	It("rewrites the configuration and signals the agent to reload", func() {
+		Expect(reloader.Reload(previous, cfg, timeout)).To(Succeed())
+
+		Expect(configWriter.WriteCall.Receives.Config).To(Equal(cfg))
+		Expect(serviceDefiner.GenerateDefinitionsCall.Receives.Config).To(Equal(cfg))
+		Expect(serviceDefiner.WriteDefinitionsCall.Receives.ConfigDir).To(Equal("/some/config/dir"))
+		Expect(serviceDefiner.WriteDefinitionsCall.Receives.Definitions).To(Equal([]config.ServiceDefinition{
+			{ServiceName: "router"},
+		}))
+		Expect(agentRunner.ReloadCall.CallCount).To(Equal(1))
+		Expect(agentClient.SetKeysCall.CallCount).To(Equal(0))
+
+		Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+			{
+				Action: "reloader.reload.write-config",
+			},
+			{
+				Action: "reloader.reload.write-service-definitions",
+			},
+			{
+				Action: "reloader.reload.signal-agent",
+			},
+			{
+				Action: "reloader.reload.success",
+			},
+		}))
+	})
+
+	Context("when services have been removed", func() {
+		BeforeEach(func() {
+			serviceDefiner.RemoveStaleDefinitionsCall.Returns.ServiceIDs = []string{"uaa", "old-router"}
+		})
+
+		It("deregisters them after reloading the agent", func() {
+			Expect(reloader.Reload(previous, cfg, timeout)).To(Succeed())
+
+			Expect(serviceDefiner.RemoveStaleDefinitionsCall.Receives.ConfigDir).To(Equal("/some/config/dir"))
+			Expect(serviceDefiner.RemoveStaleDefinitionsCall.Receives.Definitions).To(Equal([]config.ServiceDefinition{
+				{ServiceName: "router"},
+			}))
+			Expect(agentClient.DeregisterServiceCall.Receives.ServiceIDs).To(Equal([]string{"uaa", "old-router"}))
+
+			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "reloader.reload.signal-agent",
+				},
+				{
+					Action: "reloader.reload.deregister-service",
+					Data:   []lager.Data{{"service": "uaa"}},
+				},
+				{
+					Action: "reloader.reload.deregister-service",
+					Data:   []lager.Data{{"service": "old-router"}},
+				},
+				{
+					Action: "reloader.reload.success",
+				},
+			}))
+		})
+
+		It("logs services that cannot be deregistered and carries on", func() {
+			agentClient.DeregisterServiceCall.Returns.Error = errors.New("deregister failed")
+
+			Expect(reloader.Reload(previous, cfg, timeout)).To(Succeed())
+			Expect(agentClient.DeregisterServiceCall.CallCount).To(Equal(2))
+			Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+				Action: "reloader.reload.deregister-service.failed",
+				Error:  errors.New("deregister failed"),
+				Data:   []lager.Data{{"service": "uaa"}},
+			}))
+		})
+	})
+
+	Context("when the encrypt keys have changed", func() {
+		It("sets the new keys", func() {
+			cfg.Consul.EncryptKeys = []string{"key-2", "key-1"}
+
+			Expect(reloader.Reload(previous, cfg, timeout)).To(Succeed())
+			Expect(agentClient.SetKeysCall.CallCount).To(Equal(1))
+			Expect(agentClient.SetKeysCall.Receives.Keys).To(Equal([]string{"key-2", "key-1"}))
+			Expect(agentClient.SetKeysCall.Receives.DataDir).To(Equal("/some/data/dir"))
+
+			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "reloader.reload.signal-agent",
+				},
+				{
+					Action: "reloader.reload.set-keys",
+					Data: []lager.Data{{
+						"keys": []string{"key-2", "key-1"},
+					}},
+				},
+				{
+					Action: "reloader.reload.success",
+				},
+			}))
+		})
+	})
+
+	Context("failure cases", func() {
+		It("returns an error when the config cannot be written", func() {
+			configWriter.WriteCall.Returns.Error = errors.New("write failed")
+
+			Expect(reloader.Reload(previous, cfg, timeout)).To(MatchError("write failed"))
+			Expect(agentRunner.ReloadCall.CallCount).To(Equal(0))
+		})
+
+		It("returns an error when the service definitions cannot be generated", func() {
+			serviceDefiner.GenerateDefinitionsCall.Returns.Error = errors.New("generate failed")
+
+			Expect(reloader.Reload(previous, cfg, timeout)).To(MatchError("generate failed"))
+			Expect(agentRunner.ReloadCall.CallCount).To(Equal(0))
+		})
+
+		It("returns an error when the service definitions cannot be written", func() {
+			serviceDefiner.WriteDefinitionsCall.Returns.Error = errors.New("write definitions failed")
+
+			Expect(reloader.Reload(previous, cfg, timeout)).To(MatchError("write definitions failed"))
+			Expect(agentRunner.ReloadCall.CallCount).To(Equal(0))
+		})
+
+		It("returns an error when the agent cannot be signalled", func() {
+			agentRunner.ReloadCall.Returns.Error = errors.New("signal failed")
+
+			Expect(reloader.Reload(previous, cfg, timeout)).To(MatchError("signal failed"))
+		})
+
+		It("returns an error when the keys cannot be set before the timeout", func() {
+			cfg.Consul.EncryptKeys = []string{"key-2"}
+			agentClient.SetKeysCall.Returns.Error = errors.New("set keys failed")
+
+			err := reloader.Reload(previous, cfg, utils.NewTimeout(time.After(10*time.Millisecond)))
+			Expect(err).To(MatchError(ContainSubstring("set keys failed")))
+		})
+	})
+})
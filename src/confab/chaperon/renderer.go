@@ -0,0 +1,266 @@
+package chaperon
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
+)
+
+const (
+	FileUnchanged = "unchanged"
+	FileAdded     = "added"
+	FileModified  = "modified"
+	FileExtra     = "extra"
+)
+
+type configRenderer interface {
+	Render(config.Config) ([]byte, error)
+}
+
+type definitionRenderer interface {
+	GenerateDefinitions(config.Config) ([]config.ServiceDefinition, error)
+	RenderDefinitions([]config.ServiceDefinition) (map[string][]byte, error)
+}
+
+type RenderedFile struct {
+	Name     string
+	Contents []byte
+}
+
+type FileDiff struct {
+	Name   string
+	Status string
+	Lines  []string
+}
+
+type Renderer struct {
+	configRenderer     configRenderer
+	definitionRenderer definitionRenderer
+	logger             logger
+}
+
+func NewRenderer(configRenderer configRenderer, definitionRenderer definitionRenderer, logger logger) Renderer {
+	return Renderer{
+		configRenderer:     configRenderer,
+		definitionRenderer: definitionRenderer,
+		logger:             logger,
+	}
+}
+
+// Render returns config.json followed by the service definition files, in the
+// form they would be written by start, without writing anything.
+func (r Renderer) Render(cfg config.Config) ([]RenderedFile, error) {
+	r.logger.Info("renderer.render.config")
+	consulConfig, err := r.configRenderer.Render(cfg)
+	if err != nil {
+		r.logger.Error("renderer.render.config.failed", err)
+		return nil, err
+	}
+
+	files := []RenderedFile{{
+		Name:     "config.json",
+		Contents: consulConfig,
+	}}
+
+	r.logger.Info("renderer.render.service-definitions")
+	definitions, err := r.definitionRenderer.GenerateDefinitions(cfg)
+	if err != nil {
+		r.logger.Error("renderer.render.service-definitions.failed", err)
+		return nil, err
+	}
+
+	definitionFiles, err := r.definitionRenderer.RenderDefinitions(definitions)
+	if err != nil {
+		r.logger.Error("renderer.render.service-definitions.failed", err)
+		return nil, err
+	}
+
+	var names []string
+	for name := range definitionFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		files = append(files, RenderedFile{
+			Name:     name,
+			Contents: definitionFiles[name],
+		})
+	}
+
+	r.logger.Info("renderer.render.success")
+	return files, nil
+}
+
+// WriteFiles writes rendered files to dir, which must already exist. Like
+// start, it writes them atomically and keeps them from other users, as they
+// may hold tokens and keys.
+func (r Renderer) WriteFiles(dir string, files []RenderedFile) error {
+	owner := utils.DirOwner(dir)
+
+	for _, file := range files {
+		path := filepath.Join(dir, file.Name)
+
+		r.logger.Info("renderer.write-files.write", lager.Data{
+			"path": path,
+		})
+
+		if _, err := utils.WriteFileAtomically(path, file.Contents, configFileMode, owner); err != nil {
+			r.logger.Error("renderer.write-files.write.failed", err, lager.Data{
+				"path": path,
+			})
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Diff compares rendered files with the files currently in dir. Service
+// definition files in dir that would no longer be rendered are reported as
+// extra.
+func (r Renderer) Diff(dir string, files []RenderedFile) ([]FileDiff, error) {
+	rendered := map[string]bool{}
+
+	var diffs []FileDiff
+	for _, file := range files {
+		rendered[file.Name] = true
+
+		current, err := ioutil.ReadFile(filepath.Join(dir, file.Name))
+		switch {
+		case os.IsNotExist(err):
+			diffs = append(diffs, FileDiff{
+				Name:   file.Name,
+				Status: FileAdded,
+				Lines:  diffLines(nil, splitJSONLines(file.Contents)),
+			})
+			continue
+		case err != nil:
+			r.logger.Error("renderer.diff.read.failed", err, lager.Data{
+				"file": file.Name,
+			})
+			return nil, err
+		}
+
+		lines := diffLines(splitJSONLines(current), splitJSONLines(file.Contents))
+
+		status := FileUnchanged
+		if hasChanges(lines) {
+			status = FileModified
+		}
+
+		diffs = append(diffs, FileDiff{
+			Name:   file.Name,
+			Status: status,
+			Lines:  lines,
+		})
+	}
+
+	existing, err := filepath.Glob(filepath.Join(dir, "service-*.json"))
+	if err != nil {
+		return nil, err // not tested, the pattern is always valid
+	}
+	sort.Strings(existing)
+
+	for _, path := range existing {
+		name := filepath.Base(path)
+		if rendered[name] {
+			continue
+		}
+
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			r.logger.Error("renderer.diff.read.failed", err, lager.Data{
+				"file": name,
+			})
+			return nil, err
+		}
+
+		diffs = append(diffs, FileDiff{
+			Name:   name,
+			Status: FileExtra,
+			Lines:  diffLines(splitJSONLines(contents), nil),
+		})
+	}
+
+	return diffs, nil
+}
+
+// splitJSONLines indents JSON so that a line diff is meaningful for files
+// that were written without whitespace.
+func splitJSONLines(contents []byte) []string {
+	var buffer bytes.Buffer
+	if err := json.Indent(&buffer, contents, "", "  "); err == nil {
+		contents = buffer.Bytes()
+	}
+
+	return strings.Split(strings.TrimRight(string(contents), "\n"), "\n")
+}
+
+func hasChanges(lines []string) bool {
+	for _, line := range lines {
+		if !strings.HasPrefix(line, " ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// diffLines returns a line-oriented diff of a and b where every line is
+// prefixed with " " when unchanged, "-" when removed and "+" when added.
+func diffLines(a, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, "-"+a[i])
+			i++
+		default:
+			lines = append(lines, "+"+b[j])
+			j++
+		}
+	}
+
+	for ; i < len(a); i++ {
+		lines = append(lines, "-"+a[i])
+	}
+
+	for ; j < len(b); j++ {
+		lines = append(lines, "+"+b[j])
+	}
+
+	return lines
+}
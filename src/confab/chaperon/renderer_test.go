@@ -0,0 +1,255 @@
+package chaperon_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/chaperon"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/pivotal-cf-experimental/gomegamatchers"
+)
+
+var _ = Describe("Renderer", func() {
+	var (
+		configDir      string
+		dataDir        string
+		cfg            config.Config
+		logger         *fakes.Logger
+		serviceDefiner *fakes.ServiceDefiner
+		renderer       chaperon.Renderer
+	)
+
+	BeforeEach(func() {
+		var err error
+		configDir, err = ioutil.TempDir("", "config")
+		Expect(err).NotTo(HaveOccurred())
+
+		dataDir, err = ioutil.TempDir("", "data")
+		Expect(err).NotTo(HaveOccurred())
+
+		logger = &fakes.Logger{}
+
+		cfg = config.Config{}
+		cfg.Node = config.ConfigNode{Name: "node", Index: 0}
+		cfg.Path.ConsulConfigDir = configDir
+		cfg.Path.DataDir = dataDir
+
+		serviceDefiner = &fakes.ServiceDefiner{}
+		serviceDefiner.RenderDefinitionsCall.Returns.Files = map[string][]byte{
+			"service-router.json":           []byte(`{"service":{"name":"gorouter"}}` + "\n"),
+			"service-cloud_controller.json": []byte(`{"service":{"name":"cloud-controller"}}` + "\n"),
+		}
+
+		renderer = chaperon.NewRenderer(chaperon.NewConfigWriter(configDir, logger), serviceDefiner, logger)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(configDir)).To(Succeed())
+		Expect(os.RemoveAll(dataDir)).To(Succeed())
+	})
+
+	Describe("Render", func() {
+		It("renders config.json followed by the service definitions", func() {
+			definitions := []config.ServiceDefinition{{ServiceName: "router"}}
+			serviceDefiner.GenerateDefinitionsCall.Returns.Definitions = definitions
+
+			files, err := renderer.Render(cfg)
+			Expect(err).NotTo(HaveOccurred())
+
+			consulConfig, err := json.Marshal(config.GenerateConfiguration(cfg, configDir, "node-0", ""))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(files).To(Equal([]chaperon.RenderedFile{
+				{
+					Name:     "config.json",
+					Contents: consulConfig,
+				},
+				{
+					Name:     "service-cloud_controller.json",
+					Contents: []byte(`{"service":{"name":"cloud-controller"}}` + "\n"),
+				},
+				{
+					Name:     "service-router.json",
+					Contents: []byte(`{"service":{"name":"gorouter"}}` + "\n"),
+				},
+			}))
+
+			Expect(serviceDefiner.GenerateDefinitionsCall.Receives.Config).To(Equal(cfg))
+			Expect(serviceDefiner.RenderDefinitionsCall.Receives.Definitions).To(Equal(definitions))
+
+			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "renderer.render.config",
+				},
+				{
+					Action: "config-writer.render.determine-node-name",
+					Data: []lager.Data{{
+						"node-name": "node-0",
+						"node-id":   "",
+					}},
+				},
+			}))
+		})
+
+		It("renders the same files each time when no node identity is persisted", func() {
+			files, err := renderer.Render(cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(files[0].Contents)).NotTo(ContainSubstring(`"node_id"`))
+
+			again, err := renderer.Render(cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again).To(Equal(files))
+		})
+
+		It("does not write anything", func() {
+			_, err := renderer.Render(cfg)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(filepath.Join(dataDir, "node-name.json")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(configDir, "config.json")).NotTo(BeAnExistingFile())
+		})
+
+		It("uses the persisted node identity", func() {
+			Expect(ioutil.WriteFile(filepath.Join(dataDir, "node-name.json"),
+				[]byte(`{"node_name":"persisted-name","node_id":"8d3b2f1e-4c5a-4e6f-9a7b-1c2d3e4f5a6b"}`), 0644)).To(Succeed())
+
+			files, err := renderer.Render(cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(files[0].Contents)).To(ContainSubstring(`"node_name":"persisted-name"`))
+			Expect(string(files[0].Contents)).To(ContainSubstring(`"node_id":"8d3b2f1e-4c5a-4e6f-9a7b-1c2d3e4f5a6b"`))
+
+			contents, err := ioutil.ReadFile(filepath.Join(dataDir, "node-name.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(contents).To(MatchJSON(`{"node_name":"persisted-name","node_id":"8d3b2f1e-4c5a-4e6f-9a7b-1c2d3e4f5a6b"}`))
+		})
+
+		It("renders when the data dir does not exist", func() {
+			cfg.Path.DataDir = "/some/fake/path"
+
+			files, err := renderer.Render(cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(files[0].Contents)).To(ContainSubstring(`"node_name":"node-0"`))
+		})
+
+		Context("failure cases", func() {
+			It("returns an error when the definitions cannot be generated", func() {
+				serviceDefiner.GenerateDefinitionsCall.Returns.Error = errors.New("generate failed")
+
+				_, err := renderer.Render(cfg)
+				Expect(err).To(MatchError("generate failed"))
+			})
+
+			It("returns an error when the definitions cannot be rendered", func() {
+				serviceDefiner.RenderDefinitionsCall.Returns.Error = errors.New("render failed")
+
+				_, err := renderer.Render(cfg)
+				Expect(err).To(MatchError("render failed"))
+			})
+
+			It("returns an error when the node name cannot be determined", func() {
+				Expect(ioutil.WriteFile(filepath.Join(dataDir, "node-name.json"), []byte(`%%%`), 0644)).To(Succeed())
+
+				_, err := renderer.Render(cfg)
+				Expect(err).To(MatchError(ContainSubstring("invalid character")))
+			})
+		})
+	})
+
+	Describe("WriteFiles", func() {
+		It("writes the files to the given directory", func() {
+			outputDir, err := ioutil.TempDir("", "output")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(outputDir)
+
+			err = renderer.WriteFiles(outputDir, []chaperon.RenderedFile{
+				{Name: "config.json", Contents: []byte("{}")},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ioutil.ReadFile(filepath.Join(outputDir, "config.json"))).To(Equal([]byte("{}")))
+
+			if runtime.GOOS != "windows" {
+				info, err := os.Stat(filepath.Join(outputDir, "config.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0640)))
+			}
+		})
+
+		It("returns an error when the directory does not exist", func() {
+			err := renderer.WriteFiles("/some/fake/path", []chaperon.RenderedFile{
+				{Name: "config.json", Contents: []byte("{}")},
+			})
+			Expect(err).To(MatchError(ContainSubstring("no such file or directory")))
+		})
+	})
+
+	Describe("Diff", func() {
+		It("reports added, modified, unchanged and extra files", func() {
+			Expect(ioutil.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{"server":false,"log_level":"info"}`), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(configDir, "service-router.json"), []byte(`{"service":{"name":"gorouter"}}`+"\n"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(configDir, "service-old.json"), []byte(`{"service":{"name":"old"}}`+"\n"), 0644)).To(Succeed())
+
+			diffs, err := renderer.Diff(configDir, []chaperon.RenderedFile{
+				{Name: "config.json", Contents: []byte(`{"server":false,"log_level":"debug"}`)},
+				{Name: "service-cloud_controller.json", Contents: []byte(`{"service":{}}`)},
+				{Name: "service-router.json", Contents: []byte(`{"service":{"name":"gorouter"}}` + "\n")},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(diffs).To(Equal([]chaperon.FileDiff{
+				{
+					Name:   "config.json",
+					Status: chaperon.FileModified,
+					Lines: []string{
+						" {",
+						`   "server": false,`,
+						`-  "log_level": "info"`,
+						`+  "log_level": "debug"`,
+						" }",
+					},
+				},
+				{
+					Name:   "service-cloud_controller.json",
+					Status: chaperon.FileAdded,
+					Lines: []string{
+						"+{",
+						`+  "service": {}`,
+						"+}",
+					},
+				},
+				{
+					Name:   "service-router.json",
+					Status: chaperon.FileUnchanged,
+					Lines: []string{
+						" {",
+						`   "service": {`,
+						`     "name": "gorouter"`,
+						"   }",
+						" }",
+					},
+				},
+				{
+					Name:   "service-old.json",
+					Status: chaperon.FileExtra,
+					Lines: []string{
+						"-{",
+						`-  "service": {`,
+						`-    "name": "old"`,
+						"-  }",
+						"-}",
+					},
+				},
+			}))
+		})
+	})
+})
@@ -10,6 +10,7 @@ type controller interface {
 	BootAgent(utils.Timeout) error
 	ConfigureServer(utils.Timeout) error
 	ConfigureClient() error
+	WritePID() error
 	StopAgent()
 }
 
@@ -0,0 +1,228 @@
+package chaperon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/agent"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
+)
+
+var memberStatuses = []string{"none", "alive", "leaving", "left", "failed"}
+
+type Status struct {
+	Healthy    bool             `json:"healthy"`
+	Problems   []string         `json:"problems"`
+	Agent      StatusAgent      `json:"agent"`
+	Members    []StatusMember   `json:"members"`
+	Leader     string           `json:"leader"`
+	Raft       *StatusRaft      `json:"raft,omitempty"`
+	Keyring    StatusKeyring    `json:"keyring"`
+	Supervisor *SupervisorState `json:"supervisor,omitempty"`
+}
+
+type StatusAgent struct {
+	PIDFile string `json:"pid_file"`
+	PID     int    `json:"pid"`
+	Running bool   `json:"running"`
+}
+
+type StatusMember struct {
+	Name   string `json:"name"`
+	Addr   string `json:"addr"`
+	Role   string `json:"role"`
+	Status string `json:"status"`
+}
+
+type StatusRaft struct {
+	CommitIndex  string `json:"commit_index"`
+	LastLogIndex string `json:"last_log_index"`
+}
+
+type StatusKeyring struct {
+	Installed []string `json:"installed"`
+	Expected  []string `json:"expected"`
+	Match     bool     `json:"match"`
+}
+
+type StatusChecker struct {
+	config       config.Config
+	agentClient  agentClient
+	statusClient statusClient
+	logger       logger
+}
+
+func NewStatusChecker(cfg config.Config, agentClient agentClient, statusClient statusClient, logger logger) StatusChecker {
+	return StatusChecker{
+		config:       cfg,
+		agentClient:  agentClient,
+		statusClient: statusClient,
+		logger:       logger,
+	}
+}
+
+func (s StatusChecker) Check() Status {
+	status := Status{
+		Problems: []string{},
+		Members:  []StatusMember{},
+	}
+
+	s.checkAgent(&status)
+	s.checkMembers(&status)
+	s.checkLeader(&status)
+
+	if s.config.Consul.Agent.Mode == "server" {
+		s.checkRaft(&status)
+	}
+
+	s.checkKeyring(&status)
+	s.checkSupervisor(&status)
+
+	status.Healthy = len(status.Problems) == 0
+
+	s.logger.Info("status-checker.check.result", lager.Data{
+		"healthy":  status.Healthy,
+		"problems": status.Problems,
+	})
+
+	return status
+}
+
+func (s StatusChecker) checkAgent(status *Status) {
+	pidFile := s.config.Path.PIDFile
+	status.Agent.PIDFile = pidFile
+
+	s.logger.Info("status-checker.check.agent", lager.Data{
+		"pidfile": pidFile,
+	})
+
+	if pidFileContents, err := ioutil.ReadFile(pidFile); err == nil {
+		status.Agent.PID, _ = strconv.Atoi(strings.TrimSpace(string(pidFileContents)))
+	}
+
+	status.Agent.Running = utils.IsRunningProcess(pidFile)
+	if !status.Agent.Running {
+		status.Problems = append(status.Problems, fmt.Sprintf("consul agent from pid file %q is not running", pidFile))
+	}
+}
+
+func (s StatusChecker) checkMembers(status *Status) {
+	s.logger.Info("status-checker.check.members")
+
+	members, err := s.agentClient.Members(false)
+	if err != nil {
+		s.logger.Error("status-checker.check.members.failed", err)
+		status.Problems = append(status.Problems, fmt.Sprintf("failed to list members: %s", err))
+		return
+	}
+
+	for _, member := range members {
+		role := member.Tags["role"]
+		switch role {
+		case "consul":
+			role = "server"
+		case "node":
+			role = "client"
+		}
+
+		memberStatus := "unknown"
+		if member.Status >= 0 && member.Status < len(memberStatuses) {
+			memberStatus = memberStatuses[member.Status]
+		}
+
+		status.Members = append(status.Members, StatusMember{
+			Name:   member.Name,
+			Addr:   member.Addr,
+			Role:   role,
+			Status: memberStatus,
+		})
+	}
+
+	sort.Slice(status.Members, func(i, j int) bool {
+		return status.Members[i].Name < status.Members[j].Name
+	})
+}
+
+func (s StatusChecker) checkLeader(status *Status) {
+	s.logger.Info("status-checker.check.leader")
+
+	leader, err := s.statusClient.Leader()
+	if err != nil {
+		s.logger.Error("status-checker.check.leader.failed", err)
+		status.Problems = append(status.Problems, fmt.Sprintf("failed to determine leader: %s", err))
+		return
+	}
+
+	status.Leader = leader
+	if leader == "" {
+		status.Problems = append(status.Problems, "cluster has no leader")
+	}
+}
+
+func (s StatusChecker) checkRaft(status *Status) {
+	s.logger.Info("status-checker.check.raft")
+
+	raftStats, err := s.agentClient.RaftStats()
+	if err != nil {
+		s.logger.Error("status-checker.check.raft.failed", err)
+		status.Problems = append(status.Problems, fmt.Sprintf("failed to read raft stats: %s", err))
+		return
+	}
+
+	commitIndex, _ := raftStats["commit_index"].(string)
+	lastLogIndex, _ := raftStats["last_log_index"].(string)
+
+	status.Raft = &StatusRaft{
+		CommitIndex:  commitIndex,
+		LastLogIndex: lastLogIndex,
+	}
+
+	if commitIndex != lastLogIndex {
+		status.Problems = append(status.Problems, fmt.Sprintf("raft log not in sync: commit_index=%s last_log_index=%s", commitIndex, lastLogIndex))
+	}
+}
+
+func (s StatusChecker) checkKeyring(status *Status) {
+	status.Keyring.Installed = []string{}
+	status.Keyring.Expected = agent.EncryptKeys(s.config.Consul.EncryptKeys)
+	if status.Keyring.Expected == nil {
+		status.Keyring.Expected = []string{}
+		status.Keyring.Match = true
+		return
+	}
+
+	s.logger.Info("status-checker.check.keyring")
+
+	keys, err := s.agentClient.ListKeys()
+	if err != nil {
+		s.logger.Error("status-checker.check.keyring.failed", err)
+		status.Problems = append(status.Problems, fmt.Sprintf("failed to list keys: %s", err))
+		return
+	}
+
+	sort.Strings(keys)
+	status.Keyring.Installed = keys
+
+	expected := append([]string{}, status.Keyring.Expected...)
+	sort.Strings(expected)
+
+	status.Keyring.Match = strings.Join(keys, ",") == strings.Join(expected, ",")
+	if !status.Keyring.Match {
+		status.Problems = append(status.Problems, "installed keyring does not match encrypt_keys")
+	}
+}
+
+func (s StatusChecker) checkSupervisor(status *Status) {
+	state, err := ReadSupervisorState(s.config.Path.DataDir)
+	if err != nil {
+		return
+	}
+
+	status.Supervisor = &state
+}
@@ -0,0 +1,254 @@
+package chaperon_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/agent"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/chaperon"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/fakes"
+	"github.com/hashicorp/consul/api"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/pivotal-cf-experimental/gomegamatchers"
+)
+
+var _ = Describe("StatusChecker", func() {
+	Describe("Check", func() {
+		var (
+			pidFile       string
+			logger        *fakes.Logger
+			agentClient   *fakes.AgentClient
+			statusClient  *fakes.StatusClient
+			cfg           config.Config
+			statusChecker chaperon.StatusChecker
+		)
+
+		BeforeEach(func() {
+			file, err := ioutil.TempFile("", "pidfile")
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+			pidFile = file.Name()
+
+			_, err = file.WriteString(fmt.Sprintf("%d", os.Getpid()))
+			Expect(err).NotTo(HaveOccurred())
+
+			logger = &fakes.Logger{}
+
+			agentClient = &fakes.AgentClient{}
+			agentClient.MembersCall.Returns.Members = []*api.AgentMember{
+				{
+					Name:   "consul-1",
+					Addr:   "10.0.0.2",
+					Status: 1,
+					Tags:   map[string]string{"role": "consul"},
+				},
+				{
+					Name:   "consul-0",
+					Addr:   "10.0.0.1",
+					Status: 1,
+					Tags:   map[string]string{"role": "consul"},
+				},
+				{
+					Name:   "router-0",
+					Addr:   "10.0.0.3",
+					Status: 4,
+					Tags:   map[string]string{"role": "node"},
+				},
+			}
+			agentClient.RaftStatsCall.Returns.Stats = map[string]interface{}{
+				"commit_index":   "42",
+				"last_log_index": "42",
+			}
+			agentClient.ListKeysCall.Returns.Keys = agent.EncryptKeys([]string{"key-2", "key-1"})
+
+			statusClient = &fakes.StatusClient{}
+			statusClient.LeaderCall.Returns.Leader = "10.0.0.1:8300"
+
+			cfg = config.Config{}
+			cfg.Path.PIDFile = pidFile
+			cfg.Consul.Agent.Mode = "server"
+			cfg.Consul.EncryptKeys = []string{"key-1", "key-2"}
+
+			statusChecker = chaperon.NewStatusChecker(cfg, agentClient, statusClient, logger)
+		})
+
+		AfterEach(func() {
+			Expect(os.Remove(pidFile)).To(Succeed())
+		})
+
+		It("reports a healthy agent and cluster", func() {
+			status := statusChecker.Check()
+			Expect(status.Healthy).To(BeTrue())
+			Expect(status.Problems).To(BeEmpty())
+
+			Expect(status.Agent).To(Equal(chaperon.StatusAgent{
+				PIDFile: pidFile,
+				PID:     os.Getpid(),
+				Running: true,
+			}))
+
+			Expect(status.Members).To(Equal([]chaperon.StatusMember{
+				{Name: "consul-0", Addr: "10.0.0.1", Role: "server", Status: "alive"},
+				{Name: "consul-1", Addr: "10.0.0.2", Role: "server", Status: "alive"},
+				{Name: "router-0", Addr: "10.0.0.3", Role: "client", Status: "failed"},
+			}))
+			Expect(agentClient.MembersCall.Receives.WAN).To(BeFalse())
+
+			Expect(status.Leader).To(Equal("10.0.0.1:8300"))
+			Expect(status.Raft).To(Equal(&chaperon.StatusRaft{
+				CommitIndex:  "42",
+				LastLogIndex: "42",
+			}))
+			Expect(status.Keyring.Match).To(BeTrue())
+
+			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "status-checker.check.members",
+				},
+				{
+					Action: "status-checker.check.leader",
+				},
+				{
+					Action: "status-checker.check.raft",
+				},
+				{
+					Action: "status-checker.check.keyring",
+				},
+			}))
+		})
+
+		Context("when the agent is a client", func() {
+			It("does not check the raft stats", func() {
+				cfg.Consul.Agent.Mode = "client"
+				statusChecker = chaperon.NewStatusChecker(cfg, agentClient, statusClient, logger)
+
+				status := statusChecker.Check()
+				Expect(status.Healthy).To(BeTrue())
+				Expect(status.Raft).To(BeNil())
+				Expect(agentClient.RaftStatsCall.CallCount).To(Equal(0))
+			})
+		})
+
+		Context("when no encrypt keys are configured", func() {
+			It("does not check the keyring", func() {
+				cfg.Consul.EncryptKeys = nil
+				statusChecker = chaperon.NewStatusChecker(cfg, agentClient, statusClient, logger)
+
+				status := statusChecker.Check()
+				Expect(status.Healthy).To(BeTrue())
+				Expect(status.Keyring.Match).To(BeTrue())
+				Expect(agentClient.ListKeysCall.CallCount).To(Equal(0))
+			})
+		})
+
+		Context("when the agent is supervised", func() {
+			It("reports the restart count and last exit status", func() {
+				dataDir, err := ioutil.TempDir("", "data")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(dataDir)
+
+				err = ioutil.WriteFile(filepath.Join(dataDir, "supervisor.json"), []byte(`{
+					"restarts": 2,
+					"crash_loops": 0,
+					"last_exit_status": "exit status 1",
+					"last_exit_time": "2017-01-02T03:04:05Z"
+				}`), 0644)
+				Expect(err).NotTo(HaveOccurred())
+
+				cfg.Path.DataDir = dataDir
+				statusChecker = chaperon.NewStatusChecker(cfg, agentClient, statusClient, logger)
+
+				status := statusChecker.Check()
+				Expect(status.Healthy).To(BeTrue())
+				Expect(status.Supervisor).To(Equal(&chaperon.SupervisorState{
+					Restarts:       2,
+					LastExitStatus: "exit status 1",
+					LastExitTime:   time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC),
+				}))
+			})
+
+			It("does not report supervision when the agent is not supervised", func() {
+				status := statusChecker.Check()
+				Expect(status.Supervisor).To(BeNil())
+			})
+		})
+
+		Context("failure cases", func() {
+			It("reports the agent process is not running", func() {
+				Expect(ioutil.WriteFile(pidFile, []byte("-1"), 0644)).To(Succeed())
+
+				status := statusChecker.Check()
+				Expect(status.Healthy).To(BeFalse())
+				Expect(status.Agent.Running).To(BeFalse())
+				Expect(status.Problems).To(ConsistOf(fmt.Sprintf("consul agent from pid file %q is not running", pidFile)))
+			})
+
+			It("reports when the members cannot be listed", func() {
+				agentClient.MembersCall.Returns.Error = errors.New("members failed")
+
+				status := statusChecker.Check()
+				Expect(status.Healthy).To(BeFalse())
+				Expect(status.Problems).To(ConsistOf("failed to list members: members failed"))
+			})
+
+			It("reports when there is no leader", func() {
+				statusClient.LeaderCall.Returns.Leader = ""
+
+				status := statusChecker.Check()
+				Expect(status.Healthy).To(BeFalse())
+				Expect(status.Problems).To(ConsistOf("cluster has no leader"))
+			})
+
+			It("reports when the leader cannot be determined", func() {
+				statusClient.LeaderCall.Returns.Error = errors.New("No known Consul servers")
+
+				status := statusChecker.Check()
+				Expect(status.Healthy).To(BeFalse())
+				Expect(status.Problems).To(ConsistOf("failed to determine leader: No known Consul servers"))
+			})
+
+			It("reports when the raft log is not in sync", func() {
+				agentClient.RaftStatsCall.Returns.Stats = map[string]interface{}{
+					"commit_index":   "40",
+					"last_log_index": "42",
+				}
+
+				status := statusChecker.Check()
+				Expect(status.Healthy).To(BeFalse())
+				Expect(status.Problems).To(ConsistOf("raft log not in sync: commit_index=40 last_log_index=42"))
+			})
+
+			It("reports when the raft stats cannot be read", func() {
+				agentClient.RaftStatsCall.Returns.Error = errors.New("self failed")
+
+				status := statusChecker.Check()
+				Expect(status.Healthy).To(BeFalse())
+				Expect(status.Problems).To(ConsistOf("failed to read raft stats: self failed"))
+			})
+
+			It("reports when the installed keyring does not match the encrypt keys", func() {
+				agentClient.ListKeysCall.Returns.Keys = agent.EncryptKeys([]string{"key-1", "key-3"})
+
+				status := statusChecker.Check()
+				Expect(status.Healthy).To(BeFalse())
+				Expect(status.Keyring.Match).To(BeFalse())
+				Expect(status.Problems).To(ConsistOf("installed keyring does not match encrypt_keys"))
+			})
+
+			It("reports when the keys cannot be listed", func() {
+				agentClient.ListKeysCall.Returns.Error = errors.New("keyring failed")
+
+				status := statusChecker.Check()
+				Expect(status.Healthy).To(BeFalse())
+				Expect(status.Problems).To(ConsistOf("failed to list keys: keyring failed"))
+			})
+		})
+	})
+})
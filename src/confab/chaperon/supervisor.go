@@ -0,0 +1,309 @@
+package chaperon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
+)
+
+const supervisorStateFile = "supervisor.json"
+
+type supervisedRunner interface {
+	Wait() error
+	Stop() error
+	ExitStatus() string
+	Crashed() bool
+}
+
+type supervisorClock interface {
+	Now() time.Time
+	After(time.Duration) <-chan time.Time
+}
+
+type SupervisorConfig struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	MaxRestarts    int
+	RestartWindow  time.Duration
+	MaxCrashLoops  int
+	BootTimeout    time.Duration
+
+	// Server restarts the agent the way a server starts, verifying the raft
+	// log and the keyring before the pid is written.
+	Server bool
+}
+
+type SupervisorState struct {
+	Restarts       int       `json:"restarts"`
+	CrashLoops     int       `json:"crash_loops"`
+	LastExitStatus string    `json:"last_exit_status"`
+	LastExitTime   time.Time `json:"last_exit_time"`
+}
+
+type Supervisor struct {
+	controller controller
+	runner     supervisedRunner
+	clock      supervisorClock
+	config     SupervisorConfig
+	dataDir    string
+	logger     logger
+	stop       chan struct{}
+	stopOnce   sync.Once
+
+	// mu keeps restarts, reloads and stops from interleaving, as each of
+	// them reads or changes the controller and the agent client
+	mu sync.Mutex
+}
+
+func NewSupervisor(controller controller, runner supervisedRunner, clock supervisorClock, config SupervisorConfig, dataDir string, logger logger) *Supervisor {
+	return &Supervisor{
+		controller: controller,
+		runner:     runner,
+		clock:      clock,
+		config:     config,
+		dataDir:    dataDir,
+		logger:     logger,
+		stop:       make(chan struct{}),
+	}
+}
+
+// Supervise waits for the running agent to exit and restarts it in place when
+// it crashes. A restart that fails counts as a crash. Restarts back off
+// exponentially; more than MaxRestarts within RestartWindow is a crash loop,
+// after which the supervisor waits out the window before trying again. Stop
+// interrupts both waits. It returns an error after MaxCrashLoops crash loops
+// in a row, and nil once the agent exits cleanly or Stop is called.
+func (s *Supervisor) Supervise() error {
+	var (
+		state      SupervisorState
+		restarts   []time.Time
+		restartErr error
+	)
+
+	s.writeState(state)
+
+	for {
+		var now time.Time
+
+		if restartErr == nil {
+			s.logger.Info("supervisor.supervise.wait")
+			if err := s.runner.Wait(); err != nil {
+				s.logger.Error("supervisor.supervise.wait.failed", err)
+				return err
+			}
+
+			if s.stopping() {
+				s.logger.Info("supervisor.supervise.stopped")
+				return nil
+			}
+
+			now = s.clock.Now()
+			state.LastExitStatus = s.runner.ExitStatus()
+			state.LastExitTime = now
+
+			if !s.runner.Crashed() {
+				s.logger.Info("supervisor.supervise.agent-exited", lager.Data{
+					"exit-status": state.LastExitStatus,
+					"restarts":    state.Restarts,
+				})
+				s.writeState(state)
+				return nil
+			}
+
+			s.logger.Error("supervisor.supervise.agent-crashed", errors.New(state.LastExitStatus), lager.Data{
+				"restarts": state.Restarts,
+			})
+		} else {
+			// the agent was stopped after failing to restart, so there is no
+			// exit to wait for
+			now = s.clock.Now()
+			state.LastExitStatus = fmt.Sprintf("restart failed: %s", restartErr)
+			state.LastExitTime = now
+		}
+
+		restarts = recentRestarts(restarts, now, s.config.RestartWindow)
+		if len(restarts) == 0 {
+			state.CrashLoops = 0
+		}
+
+		if len(restarts) >= s.config.MaxRestarts {
+			state.CrashLoops++
+			s.writeState(state)
+
+			if state.CrashLoops >= s.config.MaxCrashLoops {
+				err := fmt.Errorf("consul agent is crash looping: restarted %d times within %s, %d times in a row",
+					len(restarts), s.config.RestartWindow, state.CrashLoops)
+				s.logger.Error("supervisor.supervise.give-up", err)
+				return err
+			}
+
+			s.logger.Info("supervisor.supervise.crash-loop", lager.Data{
+				"crash-loops": state.CrashLoops,
+				"cooldown":    s.config.RestartWindow.String(),
+			})
+			if !s.wait(s.config.RestartWindow) {
+				s.logger.Info("supervisor.supervise.stopped")
+				return nil
+			}
+			restarts = nil
+		}
+
+		backoff := s.backoff(len(restarts))
+		s.logger.Info("supervisor.supervise.backoff", lager.Data{
+			"backoff": backoff.String(),
+		})
+		if !s.wait(backoff) {
+			s.logger.Info("supervisor.supervise.stopped")
+			return nil
+		}
+
+		restarts = append(restarts, s.clock.Now())
+		state.Restarts++
+		s.writeState(state)
+
+		s.logger.Info("supervisor.supervise.restart", lager.Data{
+			"restarts": state.Restarts,
+		})
+		restartErr = s.restart()
+		if restartErr != nil {
+			s.logger.Error("supervisor.supervise.restart.failed", restartErr)
+
+			// the agent may have started without joining the cluster, stop it
+			// so that the failure is handled like any other crash
+			if err := s.runner.Stop(); err != nil {
+				s.logger.Error("supervisor.supervise.restart.stop.failed", err)
+			}
+			continue
+		}
+
+		s.logger.Info("supervisor.supervise.restart.success")
+	}
+}
+
+// Stop tells the supervisor not to restart the agent the next time it exits,
+// and ends a wait for the next restart.
+func (s *Supervisor) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// StopAgent stops supervising and stops the agent, waiting for a restart in
+// progress to finish first.
+func (s *Supervisor) StopAgent() {
+	s.Stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.controller.StopAgent()
+}
+
+// Reload runs reload between restarts, so that the configuration it applies
+// does not change while the agent is being restarted.
+func (s *Supervisor) Reload(reload func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return reload()
+}
+
+func (s *Supervisor) stopping() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait waits for duration and reports whether the supervisor may go on, which
+// it may not once Stop has been called.
+func (s *Supervisor) wait(duration time.Duration) bool {
+	select {
+	case <-s.stop:
+		return false
+	case <-s.clock.After(duration):
+		return !s.stopping()
+	}
+}
+
+func (s *Supervisor) restart() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	timeout := utils.NewTimeout(time.After(s.config.BootTimeout))
+	if err := s.controller.BootAgent(timeout); err != nil {
+		return err
+	}
+
+	if s.config.Server {
+		return s.controller.ConfigureServer(timeout)
+	}
+
+	return s.controller.ConfigureClient()
+}
+
+func (s *Supervisor) backoff(restarts int) time.Duration {
+	backoff := s.config.InitialBackoff
+	for i := 0; i < restarts && backoff < s.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > s.config.MaxBackoff {
+		backoff = s.config.MaxBackoff
+	}
+
+	return backoff
+}
+
+func (s *Supervisor) writeState(state SupervisorState) {
+	path := filepath.Join(s.dataDir, supervisorStateFile)
+
+	contents, err := json.Marshal(state)
+	if err != nil {
+		panic(err) // not tested, SupervisorState always marshals
+	}
+
+	if _, err := utils.WriteFileAtomically(path, contents, configFileMode, utils.DirOwner(s.dataDir)); err != nil {
+		s.logger.Error("supervisor.write-state.failed", err, lager.Data{
+			"path": path,
+		})
+	}
+}
+
+func recentRestarts(restarts []time.Time, now time.Time, window time.Duration) []time.Time {
+	var recent []time.Time
+	for _, restart := range restarts {
+		if now.Sub(restart) < window {
+			recent = append(recent, restart)
+		}
+	}
+
+	return recent
+}
+
+// ReadSupervisorState returns the state last recorded by a supervisor using
+// dataDir.
+func ReadSupervisorState(dataDir string) (SupervisorState, error) {
+	var state SupervisorState
+
+	contents, err := ioutil.ReadFile(filepath.Join(dataDir, supervisorStateFile))
+	if err != nil {
+		return state, err
+	}
+
+	if err := json.Unmarshal(contents, &state); err != nil {
+		return state, err
+	}
+
+	return state, nil
+}
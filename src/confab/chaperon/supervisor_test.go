@@ -0,0 +1,351 @@
+package chaperon_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/chaperon"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/fakes"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/pivotal-cf-experimental/gomegamatchers"
+)
+
+var _ = Describe("Supervisor", func() {
+	var (
+		dataDir     string
+		controller  *fakes.Controller
+		agentRunner *fakes.AgentRunner
+		clock       *fakes.Clock
+		logger      *fakes.Logger
+		supervisor  *chaperon.Supervisor
+	)
+
+	BeforeEach(func() {
+		var err error
+		dataDir, err = ioutil.TempDir("", "data")
+		Expect(err).NotTo(HaveOccurred())
+
+		controller = &fakes.Controller{}
+		agentRunner = &fakes.AgentRunner{}
+		agentRunner.ExitStatusCall.Returns.ExitStatus = "exit status 1"
+		agentRunner.CrashedCall.Returns.Crashed = true
+
+		clock = &fakes.Clock{}
+		clock.NowCall.Returns.Time = time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		logger = &fakes.Logger{}
+
+		supervisor = chaperon.NewSupervisor(controller, agentRunner, clock, chaperon.SupervisorConfig{
+			InitialBackoff: 1 * time.Second,
+			MaxBackoff:     4 * time.Second,
+			MaxRestarts:    4,
+			RestartWindow:  time.Minute,
+			MaxCrashLoops:  2,
+			BootTimeout:    time.Minute,
+		}, dataDir, logger)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dataDir)).To(Succeed())
+	})
+
+	It("returns when the agent exits cleanly", func() {
+		agentRunner.CrashedCall.Returns.Crashed = false
+		agentRunner.ExitStatusCall.Returns.ExitStatus = "exit status 0"
+
+		Expect(supervisor.Supervise()).To(Succeed())
+		Expect(controller.BootAgentCall.CallCount).To(Equal(0))
+
+		Expect(chaperon.ReadSupervisorState(dataDir)).To(Equal(chaperon.SupervisorState{
+			LastExitStatus: "exit status 0",
+			LastExitTime:   clock.NowCall.Returns.Time,
+		}))
+	})
+
+	It("writes the state file with the mode of the configuration", func() {
+		if runtime.GOOS == "windows" {
+			Skip("file modes are not enforced on Windows")
+		}
+
+		agentRunner.CrashedCall.Returns.Crashed = false
+		agentRunner.ExitStatusCall.Returns.ExitStatus = "exit status 0"
+
+		Expect(supervisor.Supervise()).To(Succeed())
+
+		info, err := os.Stat(filepath.Join(dataDir, "supervisor.json"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0640)))
+	})
+
+	It("returns without restarting when it has been stopped", func() {
+		supervisor.Stop()
+
+		Expect(supervisor.Supervise()).To(Succeed())
+		Expect(controller.BootAgentCall.CallCount).To(Equal(0))
+		Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+			{
+				Action: "supervisor.supervise.stopped",
+			},
+		}))
+	})
+
+	It("restarts a crashed agent with exponential backoff and gives up after repeated crash loops", func() {
+		err := supervisor.Supervise()
+		Expect(err).To(MatchError("consul agent is crash looping: restarted 4 times within 1m0s, 2 times in a row"))
+
+		Expect(controller.BootAgentCall.CallCount).To(Equal(8))
+		Expect(controller.ConfigureClientCall.CallCount).To(Equal(8))
+		Expect(controller.ConfigureServerCall.CallCount).To(Equal(0))
+		Expect(clock.AfterCall.Receives.Durations).To(Equal([]time.Duration{
+			1 * time.Second,
+			2 * time.Second,
+			4 * time.Second,
+			4 * time.Second,
+			time.Minute,
+			1 * time.Second,
+			2 * time.Second,
+			4 * time.Second,
+			4 * time.Second,
+		}))
+
+		Expect(chaperon.ReadSupervisorState(dataDir)).To(Equal(chaperon.SupervisorState{
+			Restarts:       8,
+			CrashLoops:     2,
+			LastExitStatus: "exit status 1",
+			LastExitTime:   clock.NowCall.Returns.Time,
+		}))
+
+		Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+			{
+				Action: "supervisor.supervise.agent-crashed",
+				Error:  errors.New("exit status 1"),
+				Data: []lager.Data{{
+					"restarts": 0,
+				}},
+			},
+			{
+				Action: "supervisor.supervise.backoff",
+				Data: []lager.Data{{
+					"backoff": "1s",
+				}},
+			},
+			{
+				Action: "supervisor.supervise.restart",
+				Data: []lager.Data{{
+					"restarts": 1,
+				}},
+			},
+			{
+				Action: "supervisor.supervise.restart.success",
+			},
+		}))
+
+		Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+			{
+				Action: "supervisor.supervise.crash-loop",
+				Data: []lager.Data{{
+					"crash-loops": 1,
+					"cooldown":    "1m0s",
+				}},
+			},
+			{
+				Action: "supervisor.supervise.backoff",
+				Data: []lager.Data{{
+					"backoff": "1s",
+				}},
+			},
+		}))
+
+		Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+			{
+				Action: "supervisor.supervise.agent-crashed",
+				Error:  errors.New("exit status 1"),
+				Data: []lager.Data{{
+					"restarts": 8,
+				}},
+			},
+			{
+				Action: "supervisor.supervise.give-up",
+				Error:  err,
+			},
+		}))
+	})
+
+	It("stops the agent when a restart fails and counts the failure as a crash", func() {
+		controller.BootAgentCall.Returns.Error = errors.New("failed to join")
+
+		err := supervisor.Supervise()
+		Expect(err).To(MatchError("consul agent is crash looping: restarted 4 times within 1m0s, 2 times in a row"))
+
+		Expect(agentRunner.WaitCall.CallCount).To(Equal(1))
+		Expect(controller.BootAgentCall.CallCount).To(Equal(8))
+		Expect(agentRunner.StopCall.CallCount).To(Equal(8))
+		Expect(controller.ConfigureClientCall.CallCount).To(Equal(0))
+
+		Expect(chaperon.ReadSupervisorState(dataDir)).To(Equal(chaperon.SupervisorState{
+			Restarts:       8,
+			CrashLoops:     2,
+			LastExitStatus: "restart failed: failed to join",
+			LastExitTime:   clock.NowCall.Returns.Time,
+		}))
+
+		Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+			{
+				Action: "supervisor.supervise.restart",
+				Data: []lager.Data{{
+					"restarts": 1,
+				}},
+			},
+			{
+				Action: "supervisor.supervise.restart.failed",
+				Error:  errors.New("failed to join"),
+			},
+			{
+				Action: "supervisor.supervise.backoff",
+				Data: []lager.Data{{
+					"backoff": "2s",
+				}},
+			},
+		}))
+	})
+
+	It("waits for the agent again once a failed restart is followed by a successful one", func() {
+		controller.BootAgentCall.Stub = func(utils.Timeout) error {
+			if controller.BootAgentCall.CallCount == 1 {
+				return errors.New("failed to join")
+			}
+			agentRunner.CrashedCall.Returns.Crashed = false
+			return nil
+		}
+
+		Expect(supervisor.Supervise()).To(Succeed())
+		Expect(controller.BootAgentCall.CallCount).To(Equal(2))
+		Expect(agentRunner.StopCall.CallCount).To(Equal(1))
+		Expect(agentRunner.WaitCall.CallCount).To(Equal(2))
+	})
+
+	It("configures a restarted server the way a server starts", func() {
+		supervisor = chaperon.NewSupervisor(controller, agentRunner, clock, chaperon.SupervisorConfig{
+			InitialBackoff: 1 * time.Second,
+			MaxBackoff:     4 * time.Second,
+			MaxRestarts:    4,
+			RestartWindow:  time.Minute,
+			MaxCrashLoops:  2,
+			BootTimeout:    time.Minute,
+			Server:         true,
+		}, dataDir, logger)
+
+		controller.BootAgentCall.Stub = func(utils.Timeout) error {
+			agentRunner.CrashedCall.Returns.Crashed = false
+			return nil
+		}
+
+		Expect(supervisor.Supervise()).To(Succeed())
+		Expect(controller.BootAgentCall.CallCount).To(Equal(1))
+		Expect(controller.ConfigureServerCall.CallCount).To(Equal(1))
+		Expect(controller.ConfigureServerCall.Receives.Timeout).To(Equal(controller.BootAgentCall.Receives.Timeout))
+		Expect(controller.ConfigureClientCall.CallCount).To(Equal(0))
+	})
+
+	It("counts a server that cannot be configured after a restart as a crash", func() {
+		supervisor = chaperon.NewSupervisor(controller, agentRunner, clock, chaperon.SupervisorConfig{
+			InitialBackoff: 1 * time.Second,
+			MaxBackoff:     4 * time.Second,
+			MaxRestarts:    4,
+			RestartWindow:  time.Minute,
+			MaxCrashLoops:  2,
+			BootTimeout:    time.Minute,
+			Server:         true,
+		}, dataDir, logger)
+
+		controller.ConfigureServerCall.Returns.Error = errors.New("not synced")
+
+		Expect(supervisor.Supervise()).To(MatchError(ContainSubstring("crash looping")))
+		Expect(controller.ConfigureServerCall.CallCount).To(Equal(8))
+		Expect(agentRunner.StopCall.CallCount).To(Equal(8))
+	})
+
+	It("stops waiting out a crash loop when it is stopped", func() {
+		cooldown := make(chan time.Time)
+		clock.AfterCall.Stub = func(duration time.Duration) <-chan time.Time {
+			if duration == time.Minute {
+				return cooldown
+			}
+
+			fired := make(chan time.Time, 1)
+			fired <- time.Time{}
+			return fired
+		}
+
+		done := make(chan error)
+		go func() {
+			done <- supervisor.Supervise()
+		}()
+
+		Eventually(func() int {
+			clock.AfterCall.Lock()
+			defer clock.AfterCall.Unlock()
+			return len(clock.AfterCall.Receives.Durations)
+		}).Should(Equal(5))
+
+		supervisor.Stop()
+
+		Eventually(done).Should(Receive(BeNil()))
+		Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+			Action: "supervisor.supervise.stopped",
+		}))
+	})
+
+	It("does not reload while the agent is being restarted", func() {
+		reloaded := make(chan struct{})
+		controller.BootAgentCall.Stub = func(utils.Timeout) error {
+			go supervisor.Reload(func() error {
+				close(reloaded)
+				return nil
+			})
+
+			Consistently(reloaded).ShouldNot(BeClosed())
+			agentRunner.CrashedCall.Returns.Crashed = false
+			return nil
+		}
+
+		Expect(supervisor.Supervise()).To(Succeed())
+		Eventually(reloaded).Should(BeClosed())
+	})
+
+	Describe("Reload", func() {
+		It("returns the error of the reload", func() {
+			err := supervisor.Reload(func() error {
+				return errors.New("reload failed")
+			})
+			Expect(err).To(MatchError("reload failed"))
+		})
+	})
+
+	Describe("StopAgent", func() {
+		It("stops the agent and does not restart it when it exits", func() {
+			supervisor.StopAgent()
+			Expect(controller.StopAgentCall.CallCount).To(Equal(1))
+
+			Expect(supervisor.Supervise()).To(Succeed())
+			Expect(controller.BootAgentCall.CallCount).To(Equal(0))
+		})
+	})
+
+	Context("when waiting for the agent fails", func() {
+		It("returns the error", func() {
+			agentRunner.WaitCall.Returns.Error = errors.New("wait failed")
+
+			Expect(supervisor.Supervise()).To(MatchError("wait failed"))
+		})
+	})
+})
@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
@@ -183,6 +184,11 @@ var _ = Describe("confab", func() {
 			consulConfig, err := ioutil.ReadFile(filepath.Join(consulConfigDir, "config.json"))
 			Expect(err).NotTo(HaveOccurred())
 
+			var identity map[string]interface{}
+			nodeIdentity, err := ioutil.ReadFile(filepath.Join(dataDir, "node-name.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(json.Unmarshal(nodeIdentity, &identity)).To(Succeed())
+
 			conf := map[string]interface{}{
 				"server":                 false,
 				"domain":                 "some-domain",
@@ -190,6 +196,7 @@ var _ = Describe("confab", func() {
 				"data_dir":               dataDir,
 				"log_level":              "debug",
 				"node_name":              "my-node-3",
+				"node_id":                identity["node_id"],
 				"rejoin_after_leave":     true,
 				"bind_addr":              "10.0.0.1",
 				"disable_remote_exec":    true,
@@ -276,7 +283,86 @@ var _ = Describe("confab", func() {
 			Eventually(func() error { wg.Wait(); return nil }).Should(Succeed())
 		})
 
-		It("the foreground process exits when the runner process exits", func() {
+		It("leaves gracefully when the foreground process is terminated", func() {
+			if Windows {
+				Skip("Signals cannot be sent to processes on Windows")
+			}
+
+			start := exec.Command(pathToConfab,
+				"start",
+				"--foreground",
+				"--recursor", "8.8.8.8",
+				"--recursor", "10.0.2.3",
+				"--config-file", configFile.Name(),
+				"--config-consul-link-file", configConsulLinkFile.Name(),
+			)
+			Eventually(start.Start, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+
+			Eventually(func() error {
+				_, err := os.Stat(pidFile.Name())
+				return err
+			}, COMMAND_TIMEOUT, time.Second*1).Should(Succeed())
+
+			pid, err := getPID(pidFile.Name())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(start.Process.Signal(syscall.SIGTERM)).To(Succeed())
+			Expect(start.Wait()).To(Succeed())
+
+			Expect(utils.IsPIDRunning(pid)).To(BeFalse())
+			Expect(pidFile.Name()).NotTo(BeAnExistingFile())
+
+			output, err := fakeAgentOutputFromFile(consulConfigDir, "fake-output.json")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output.LeaveCallCount).To(Equal(1))
+		})
+
+		It("reloads the configuration on SIGHUP without restarting the agent", func() {
+			if Windows {
+				Skip("Signals cannot be sent to processes on Windows")
+			}
+
+			start := exec.Command(pathToConfab,
+				"start",
+				"--foreground",
+				"--recursor", "8.8.8.8",
+				"--recursor", "10.0.2.3",
+				"--config-file", configFile.Name(),
+				"--config-consul-link-file", configConsulLinkFile.Name(),
+			)
+			Eventually(start.Start, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+
+			Eventually(func() error {
+				_, err := os.Stat(pidFile.Name())
+				return err
+			}, COMMAND_TIMEOUT, time.Second*1).Should(Succeed())
+
+			pid, err := getPID(pidFile.Name())
+			Expect(err).NotTo(HaveOccurred())
+
+			contents, err := ioutil.ReadFile(configFile.Name())
+			Expect(err).NotTo(HaveOccurred())
+
+			var configuration map[string]interface{}
+			Expect(json.Unmarshal(contents, &configuration)).To(Succeed())
+
+			services := configuration["consul"].(map[string]interface{})["agent"].(map[string]interface{})["services"].(map[string]interface{})
+			services["uaa"] = map[string]interface{}{}
+			delete(services, "router")
+			writeConfigurationFile(configFile.Name(), configuration)
+
+			Expect(start.Process.Signal(syscall.SIGHUP)).To(Succeed())
+
+			Eventually(filepath.Join(consulConfigDir, "service-uaa.json"), COMMAND_TIMEOUT).Should(BeAnExistingFile())
+			Eventually(filepath.Join(consulConfigDir, "service-router.json"), COMMAND_TIMEOUT).ShouldNot(BeAnExistingFile())
+			Expect(utils.IsPIDRunning(pid)).To(BeTrue())
+			Expect(getPID(pidFile.Name())).To(Equal(pid))
+
+			Expect(start.Process.Signal(syscall.SIGTERM)).To(Succeed())
+			Expect(start.Wait()).To(Succeed())
+		})
+
+		It("restarts the agent in place when it crashes in the foreground", func() {
 			start := exec.Command(pathToConfab,
 				"start",
 				"--foreground",
@@ -308,7 +394,35 @@ var _ = Describe("confab", func() {
 			killProcessAttachedToPort(8400)
 			killProcessAttachedToPort(8500)
 
-			Eventually(func() error { wg.Wait(); return nil }).Should(Succeed())
+			var restartedPID int
+			Eventually(func() (int, error) {
+				restartedPID, err = getPID(pidFile.Name())
+				return restartedPID, err
+			}, COMMAND_TIMEOUT, time.Millisecond*250).ShouldNot(Equal(pid))
+			Expect(utils.IsPIDRunning(restartedPID)).To(BeTrue())
+
+			restartedOutput, err := fakeAgentOutputFromFile(consulConfigDir, "fake-output-2.json")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restartedOutput.PID).To(Equal(restartedPID))
+			Expect(restartedOutput.Args).To(Equal([]string{
+				"agent",
+				fmt.Sprintf("-config-dir=%s", consulConfigDir),
+				"-recursor=8.8.8.8",
+				"-recursor=10.0.2.3",
+			}))
+
+			supervisorState, err := ioutil.ReadFile(filepath.Join(dataDir, "supervisor.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(supervisorState)).To(ContainSubstring(`"restarts":1`))
+
+			stop := exec.Command(pathToConfab,
+				"stop",
+				"--config-file", configFile.Name(),
+				"--config-consul-link-file", configConsulLinkFile.Name(),
+			)
+			Eventually(stop.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+
+			Eventually(func() error { wg.Wait(); return nil }, COMMAND_TIMEOUT).Should(Succeed())
 		})
 	})
 
@@ -554,6 +668,260 @@ var _ = Describe("confab", func() {
 				SelfCallCount:       2,
 			}))
 		})
+
+		It("defers draining a server while quorum cannot be confirmed", func() {
+			cmd := exec.Command(pathToConfab,
+				"start",
+				"--config-file", configFile.Name(),
+				"--config-consul-link-file", configConsulLinkFile.Name(),
+			)
+			Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+
+			stdout := bytes.NewBuffer([]byte{})
+			cmd = exec.Command(pathToConfab,
+				"drain",
+				"--config-file", configFile.Name(),
+				"--config-consul-link-file", configConsulLinkFile.Name(),
+			)
+			cmd.Stdout = stdout
+			Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+			Expect(stdout.String()).To(Equal("-10\n"))
+			Expect(utils.IsRunningProcess(pidFile.Name())).To(BeTrue())
+
+			cmd = exec.Command(pathToConfab,
+				"stop",
+				"--config-file", configFile.Name(),
+				"--config-consul-link-file", configConsulLinkFile.Name(),
+			)
+			Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+
+			Eventually(func() bool {
+				return utils.IsRunningProcess(pidFile.Name())
+			}, "5s").Should(BeFalse())
+		})
+	})
+
+	Context("when checking status", func() {
+		BeforeEach(func() {
+			writeConfigurationFile(configFile.Name(), map[string]interface{}{
+				"path": map[string]interface{}{
+					"agent_path":        pathToFakeAgent,
+					"consul_config_dir": consulConfigDir,
+					"pid_file":          pidFile.Name(),
+					"data_dir":          dataDir,
+				},
+				"consul": map[string]interface{}{
+					"agent": map[string]interface{}{
+						"servers": map[string]interface{}{
+							"lan": []string{"member-1", "member-2", "member-3"},
+						},
+					},
+				},
+			})
+		})
+
+		AfterEach(func() {
+			killProcessWithPIDFile(pidFile.Name())
+		})
+
+		It("reports the agent is unhealthy when it is not running", func() {
+			cmd := exec.Command(pathToConfab,
+				"status",
+				"--config-file", configFile.Name(),
+				"--config-consul-link-file", configConsulLinkFile.Name(),
+			)
+			buffer := bytes.NewBuffer([]byte{})
+			cmd.Stdout = buffer
+			Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).ShouldNot(Succeed())
+			Expect(buffer).To(ContainSubstring(fmt.Sprintf("agent:   not running (pid file %s)", pidFile.Name())))
+			Expect(buffer).To(ContainSubstring("status:  unhealthy"))
+		})
+
+		It("reports the status of a running agent as json", func() {
+			cmd := exec.Command(pathToConfab,
+				"start",
+				"--config-file", configFile.Name(),
+				"--config-consul-link-file", configConsulLinkFile.Name(),
+			)
+			Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+
+			pid, err := getPID(pidFile.Name())
+			Expect(err).NotTo(HaveOccurred())
+
+			cmd = exec.Command(pathToConfab,
+				"status",
+				"--json",
+				"--config-file", configFile.Name(),
+				"--config-consul-link-file", configConsulLinkFile.Name(),
+			)
+			buffer := bytes.NewBuffer([]byte{})
+			cmd.Stdout = buffer
+
+			// the fake agent never reports a leader
+			Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).ShouldNot(Succeed())
+
+			var status struct {
+				Healthy  bool
+				Problems []string
+				Agent    struct {
+					PID     int
+					Running bool
+				}
+				Members []struct {
+					Addr string
+					Role string
+				}
+			}
+			Expect(json.Unmarshal(buffer.Bytes(), &status)).To(Succeed())
+			Expect(status.Healthy).To(BeFalse())
+			Expect(status.Problems).To(ConsistOf("cluster has no leader"))
+			Expect(status.Agent.PID).To(Equal(pid))
+			Expect(status.Agent.Running).To(BeTrue())
+			Expect(status.Members).To(HaveLen(3))
+			Expect(status.Members[0].Role).To(Equal("server"))
+		})
+	})
+
+	Context("when validating", func() {
+		It("reports every problem in the configuration without starting consul", func() {
+			writeConfigurationFile(configFile.Name(), map[string]interface{}{
+				"path": map[string]interface{}{
+					"agent_path":        "/tmp/path/that/does/not/exist",
+					"consul_config_dir": consulConfigDir,
+					"pid_file":          pidFile.Name(),
+					"data_dir":          dataDir,
+				},
+				"consul": map[string]interface{}{
+					"agent": map[string]interface{}{
+						"mode": "server",
+						"dns_config": map[string]interface{}{
+							"max_stale": "banana",
+						},
+					},
+				},
+			})
+
+			cmd := exec.Command(pathToConfab,
+				"validate",
+				"--config-file", configFile.Name(),
+				"--config-consul-link-file", configConsulLinkFile.Name(),
+			)
+			buffer := bytes.NewBuffer([]byte{})
+			cmd.Stderr = buffer
+			Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).ShouldNot(Succeed())
+			Expect(buffer).To(ContainSubstring("invalid configuration"))
+			Expect(buffer).To(ContainSubstring("consul.agent.servers.lan: must not be empty"))
+			Expect(buffer).To(ContainSubstring(`consul.agent.dns_config.max_stale: must be a valid duration, got "banana"`))
+			Expect(buffer).To(ContainSubstring("consul.encrypt_keys: must not be empty for servers"))
+			Expect(buffer).To(ContainSubstring(filepath.Join(consulConfigDir, "certs", "ca.crt")))
+
+			Expect(filepath.Join(consulConfigDir, "config.json")).NotTo(BeAnExistingFile())
+		})
+	})
+
+	Context("when rendering", func() {
+		BeforeEach(func() {
+			writeConfigurationFile(configFile.Name(), map[string]interface{}{
+				"node": map[string]interface{}{
+					"name":  "my-node",
+					"index": 3,
+				},
+				"path": map[string]interface{}{
+					"agent_path":        pathToFakeAgent,
+					"consul_config_dir": consulConfigDir,
+					"pid_file":          pidFile.Name(),
+					"data_dir":          dataDir,
+				},
+				"consul": map[string]interface{}{
+					"agent": map[string]interface{}{
+						"services": map[string]interface{}{
+							"cloud_controller": map[string]interface{}{},
+						},
+					},
+				},
+			})
+		})
+
+		It("prints the generated files without writing anything", func() {
+			cmd := exec.Command(pathToConfab,
+				"render",
+				"--config-file", configFile.Name(),
+				"--config-consul-link-file", configConsulLinkFile.Name(),
+			)
+			buffer := bytes.NewBuffer([]byte{})
+			cmd.Stdout = buffer
+			Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+			Expect(buffer).To(ContainSubstring("==> config.json <=="))
+			Expect(buffer).To(ContainSubstring(`"node_name":"my-node-3"`))
+			Expect(buffer).To(ContainSubstring("==> service-cloud_controller.json <=="))
+			Expect(buffer).To(ContainSubstring(`"name":"cloud-controller"`))
+
+			Expect(filepath.Join(consulConfigDir, "config.json")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(consulConfigDir, "service-cloud_controller.json")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(dataDir, "node-name.json")).NotTo(BeAnExistingFile())
+		})
+
+		It("reports the differences with the files in consul_config_dir", func() {
+			err := ioutil.WriteFile(filepath.Join(consulConfigDir, "service-old.json"), []byte(`{"service":{"name":"old"}}`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			cmd := exec.Command(pathToConfab,
+				"render",
+				"--diff",
+				"--config-file", configFile.Name(),
+				"--config-consul-link-file", configConsulLinkFile.Name(),
+			)
+			buffer := bytes.NewBuffer([]byte{})
+			cmd.Stdout = buffer
+			Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).ShouldNot(Succeed())
+			Expect(buffer).To(ContainSubstring("config.json (added)"))
+			Expect(buffer).To(ContainSubstring("service-cloud_controller.json (added)"))
+			Expect(buffer).To(ContainSubstring("service-old.json (extra)"))
+			Expect(buffer).To(ContainSubstring(`-    "name": "old"`))
+		})
+	})
+
+	Context("when reidentifying", func() {
+		BeforeEach(func() {
+			writeConfigurationFile(configFile.Name(), map[string]interface{}{
+				"node": map[string]interface{}{
+					"name":  "my-node",
+					"index": 3,
+					"id":    "some-instance-id",
+				},
+				"path": map[string]interface{}{
+					"agent_path":        pathToFakeAgent,
+					"consul_config_dir": consulConfigDir,
+					"pid_file":          pidFile.Name(),
+					"data_dir":          dataDir,
+				},
+			})
+
+			err := ioutil.WriteFile(filepath.Join(dataDir, "node-name.json"),
+				[]byte(`{"node_name":"old-node","node_id":"8d3b2f1e-4c5a-4e6f-9a7b-1c2d3e4f5a6b","instance_id":"other-instance-id"}`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("gives the node a new identity", func() {
+			cmd := exec.Command(pathToConfab,
+				"reidentify",
+				"--config-file", configFile.Name(),
+				"--config-consul-link-file", configConsulLinkFile.Name(),
+			)
+			buffer := bytes.NewBuffer([]byte{})
+			cmd.Stdout = buffer
+			Eventually(cmd.Run, COMMAND_TIMEOUT, COMMAND_TIMEOUT).Should(Succeed())
+			Expect(buffer).To(ContainSubstring("node name: my-node-3"))
+
+			var identity map[string]interface{}
+			contents, err := ioutil.ReadFile(filepath.Join(dataDir, "node-name.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(json.Unmarshal(contents, &identity)).To(Succeed())
+			Expect(identity["node_name"]).To(Equal("my-node-3"))
+			Expect(identity["instance_id"]).To(Equal("some-instance-id"))
+			Expect(identity["node_id"]).NotTo(Equal("8d3b2f1e-4c5a-4e6f-9a7b-1c2d3e4f5a6b"))
+			Expect(buffer).To(ContainSubstring(fmt.Sprintf("node id:   %s", identity["node_id"])))
+		})
 	})
 
 	Context("failure cases", func() {
@@ -578,7 +946,7 @@ var _ = Describe("confab", func() {
 
 				usageLines := []string{
 					"usage: confab COMMAND OPTIONS",
-					"COMMAND: \"start\" or \"stop\"",
+					"COMMAND: \"start\", \"stop\", \"status\", \"validate\", \"render\", \"recover\", \"drain\", \"rotate-keys\", \"reidentify\" or \"healthcheck\"",
 					"-config-file",
 					"specifies the config file",
 				}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -8,7 +11,10 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"code.cloudfoundry.org/clock"
@@ -17,11 +23,15 @@ import (
 	"github.com/cloudfoundry-incubator/consul-release/src/confab/agent"
 	"github.com/cloudfoundry-incubator/consul-release/src/confab/chaperon"
 	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/healthcheck"
 	"github.com/cloudfoundry-incubator/consul-release/src/confab/status"
 	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
 	"github.com/hashicorp/consul/api"
 )
 
+// monitPath is where BOSH installs monit, which runs the consul_agent job.
+const monitPath = "/var/vcap/bosh/bin/monit"
+
 type runner interface {
 	Start(config.Config, utils.Timeout) error
 	Stop()
@@ -41,9 +51,20 @@ func (ss *stringSlice) Set(value string) error {
 
 var (
 	recursors            stringSlice
+	keys                 stringSlice
+	service              string
+	httpProbes           stringSlice
+	tcpProbes            stringSlice
+	pidFileProbes        stringSlice
+	commandProbes        stringSlice
+	probeTimeout         time.Duration
 	configFile           string
 	configConsulLinkFile string
 	foreground           bool
+	jsonOutput           bool
+	outputDir            string
+	diff                 bool
+	checkStatus          bool
 
 	stdout = log.New(os.Stdout, "", 0)
 	stderr = log.New(os.Stderr, "", 0)
@@ -55,6 +76,17 @@ func main() {
 	flagSet.StringVar(&configFile, "config-file", "", "specifies the config `file`")
 	flagSet.StringVar(&configConsulLinkFile, "config-consul-link-file", "", "specifies the consul link config `file`")
 	flagSet.BoolVar(&foreground, "foreground", false, "if true confab will wait for consul to exit")
+	flagSet.BoolVar(&jsonOutput, "json", false, "if true the status command will print JSON")
+	flagSet.StringVar(&outputDir, "output-dir", "", "if set the render command will write files to this `directory` instead of stdout")
+	flagSet.BoolVar(&diff, "diff", false, "if true the render command will compare the rendered files with consul_config_dir and exit 1 when they differ")
+	flagSet.Var(&keys, "key", "specifies an encryption `key` for the rotate-keys command, the first being the primary key, may be specified multiple times")
+	flagSet.StringVar(&service, "service", "", "specifies the `name` of the service the healthcheck command checks")
+	flagSet.Var(&httpProbes, "http", "specifies a `URL`, optionally prefixed with the expected status as in 204:URL, that the healthcheck command GETs, may be specified multiple times")
+	flagSet.Var(&tcpProbes, "tcp", "specifies an `address` the healthcheck command connects to, may be specified multiple times")
+	flagSet.Var(&pidFileProbes, "pid-file", "specifies a `file` holding the PID of a process the healthcheck command expects to be running, may be specified multiple times")
+	flagSet.Var(&commandProbes, "command", "specifies a `command` the healthcheck command runs, exiting 0 when passing and 1 when warning, may be specified multiple times")
+	flagSet.DurationVar(&probeTimeout, "probe-timeout", 2*time.Second, "specifies how long the healthcheck command waits for each probe, 0 for no limit")
+	flagSet.BoolVar(&checkStatus, "check-status", false, "if true the drain command continues a drain that was previously deferred")
 
 	if len(os.Args) < 2 {
 		printUsageAndExit("invalid number of arguments", flagSet)
@@ -64,22 +96,45 @@ func main() {
 		os.Exit(1)
 	}
 
-	configFileContents, err := ioutil.ReadFile(configFile)
-	if err != nil {
-		stderr.Printf("error reading configuration file: %s", err)
-		os.Exit(1)
+	if os.Args[1] == "healthcheck" {
+		runHealthcheck()
+		return
 	}
 
-	configConsulLinkFileContents, err := ioutil.ReadFile(configConsulLinkFile)
+	cfg, err := readConfig()
 	if err != nil {
 		stderr.Printf("error reading configuration file: %s", err)
 		os.Exit(1)
 	}
 
-	cfg, err := config.ConfigFromJSON(configFileContents, configConsulLinkFileContents)
-	if err != nil {
-		stderr.Printf("error reading configuration file: %s", err)
-		os.Exit(1)
+	if os.Args[1] == "validate" {
+		if err := config.Validate(cfg); err != nil {
+			stderr.Printf("invalid configuration:\n%s", err)
+			os.Exit(1)
+		}
+
+		stdout.Println("configuration is valid")
+		return
+	}
+
+	if os.Args[1] == "start" {
+		if err := config.ValidateAgent(cfg); err != nil {
+			stderr.Printf("invalid configuration:\n%s", err)
+			os.Exit(1)
+		}
+	}
+
+	logSink := os.Stdout
+	if os.Args[1] == "status" || os.Args[1] == "render" || os.Args[1] == "drain" {
+		logSink = os.Stderr
+	}
+
+	logger := lager.NewLogger("confab")
+	logger.RegisterSink(lager.NewWriterSink(logSink, lager.INFO))
+
+	if os.Args[1] == "render" {
+		render(cfg, logger)
+		return
 	}
 
 	path, err := exec.LookPath(cfg.Path.AgentPath)
@@ -91,17 +146,16 @@ func main() {
 		printUsageAndExit("\"pid_file\" cannot be empty", flagSet)
 	}
 
-	logger := lager.NewLogger("confab")
-	logger.RegisterSink(lager.NewWriterSink(os.Stdout, lager.INFO))
-
 	agentRunner := &agent.Runner{
-		Path:      path,
-		PIDFile:   cfg.Path.PIDFile,
-		ConfigDir: cfg.Path.ConsulConfigDir,
-		Recursors: recursors,
-		Stdout:    os.Stdout,
-		Stderr:    os.Stderr,
-		Logger:    logger,
+		Path:             path,
+		PIDFile:          cfg.Path.PIDFile,
+		ConfigDir:        cfg.Path.ConsulConfigDir,
+		Recursors:        recursors,
+		Stdout:           os.Stdout,
+		Stderr:           os.Stderr,
+		Logger:           logger,
+		InterruptTimeout: time.Duration(cfg.Confab.InterruptTimeoutInSeconds) * time.Second,
+		TerminateTimeout: time.Duration(cfg.Confab.TerminateTimeoutInSeconds) * time.Second,
 	}
 
 	clientConfig := api.DefaultConfig()
@@ -124,6 +178,15 @@ func main() {
 		}
 	}
 
+	// with a default acl policy of deny, confab needs a token to manage the
+	// agent, and only servers are given the master token
+	if cfg.Consul.ACL.Enabled() {
+		clientConfig.Token = cfg.Consul.ACL.AgentToken
+		if cfg.Consul.Agent.Mode == "server" && cfg.Consul.ACL.MasterToken != "" {
+			clientConfig.Token = cfg.Consul.ACL.MasterToken
+		}
+	}
+
 	consulAPIClient, err := api.NewClient(clientConfig)
 	if err != nil {
 		panic(err) // not tested, NewClient never errors
@@ -131,11 +194,25 @@ func main() {
 
 	agentClient := &agent.Client{
 		ExpectedMembers:   cfg.Consul.Agent.Servers.LAN,
+		Datacenter:        cfg.Consul.Agent.Datacenter,
+		MinJoinedServers:  cfg.Confab.MinJoinedServers,
+		RemoveStalePeers:  cfg.Confab.RemoveStaleRaftPeers,
+		JoinedWAN:         cfg.Consul.Agent.Mode == "server" && len(cfg.Consul.Agent.Servers.WAN) > 0,
 		ConsulAPIAgent:    consulAPIClient.Agent(),
 		ConsulAPIOperator: consulAPIClient.Operator(),
-		Logger:            logger,
+		ConsulAPIACL:      consulAPIClient.ACL(),
+		Join: agent.JoinConfig{
+			Policy:         agent.JoinPolicy(cfg.Confab.JoinPolicy),
+			Attempts:       cfg.Confab.JoinAttempts,
+			AttemptTimeout: time.Duration(cfg.Confab.JoinAttemptTimeoutInSeconds) * time.Second,
+			InitialBackoff: 1 * time.Second,
+		},
+		Sleeper: clock.NewClock(),
+		Logger:  logger,
 	}
 
+	statusClient := status.Client{ConsulAPIStatus: consulAPIClient.Status()}
+
 	retrier := utils.NewRetrier(clock.NewClock(), 1*time.Second)
 
 	controller := chaperon.Controller{
@@ -144,18 +221,21 @@ func main() {
 		Retrier:        retrier,
 		EncryptKeys:    cfg.Consul.EncryptKeys,
 		Logger:         logger,
-		ServiceDefiner: config.ServiceDefiner{logger},
+		ServiceDefiner: config.ServiceDefiner{Logger: logger},
 		ConfigDir:      cfg.Path.ConsulConfigDir,
 		Config:         cfg,
 	}
 
-	keyringRemover := chaperon.NewKeyringRemover(cfg.Path.KeyringFile, logger)
+	keyringRemover := chaperon.NewKeyringRemover(cfg.Path.KeyringFile, clock.NewClock(), logger)
 	configWriter := chaperon.NewConfigWriter(cfg.Path.ConsulConfigDir, logger)
 
-	var r runner = chaperon.NewClient(controller, keyringRemover, configWriter)
+	var r runner = chaperon.NewClient(&controller, keyringRemover, configWriter)
 	if controller.Config.Consul.Agent.Mode == "server" {
-		bootstrapChecker := chaperon.NewBootstrapChecker(logger, agentClient, status.Client{ConsulAPIStatus: consulAPIClient.Status()}, time.Sleep)
-		r = chaperon.NewServer(controller, configWriter, bootstrapChecker)
+		bootstrapChecker := chaperon.NewBootstrapChecker(logger, agentClient, statusClient, time.Sleep)
+		r = chaperon.NewServer(&controller, configWriter, bootstrapChecker)
+		if cfg.Confab.ServerStartStrategy == config.ServerStartStrategyBootstrapExpect {
+			r = chaperon.NewBootstrapExpectServer(&controller, configWriter, bootstrapChecker, logger)
+		}
 	}
 
 	switch os.Args[1] {
@@ -183,7 +263,19 @@ func main() {
 			os.Exit(1)
 		}
 		if foreground {
-			if err := agentRunner.Wait(); err != nil {
+			supervisor := chaperon.NewSupervisor(&controller, agentRunner, clock.NewClock(), chaperon.SupervisorConfig{
+				InitialBackoff: 1 * time.Second,
+				MaxBackoff:     1 * time.Minute,
+				MaxRestarts:    cfg.Confab.MaxRestarts,
+				RestartWindow:  time.Duration(cfg.Confab.RestartWindowInSeconds) * time.Second,
+				MaxCrashLoops:  cfg.Confab.MaxCrashLoops,
+				BootTimeout:    time.Duration(cfg.Confab.TimeoutInSeconds) * time.Second,
+				Server:         cfg.Consul.Agent.Mode == "server",
+			}, cfg.Path.DataDir, logger)
+
+			reloader := chaperon.NewReloader(configWriter, config.ServiceDefiner{Logger: logger}, agentRunner, agentClient, retrier, logger)
+
+			if err := superviseForeground(cfg, supervisor, reloader, &controller, agentClient, logger); err != nil {
 				stderr.Printf("error during wait: %s", err)
 				r.Stop()
 				os.Exit(1)
@@ -191,17 +283,381 @@ func main() {
 		}
 	case "stop":
 		r.Stop()
+	case "drain":
+		drainer := chaperon.NewDrainer(controller, agentClient, clock.NewClock(), chaperon.DrainerConfig{
+			RetryInterval: 10 * time.Second,
+			Deadline:      time.Duration(cfg.Confab.DrainTimeoutInSeconds) * time.Second,
+		}, cfg.Path.DataDir, logger)
+
+		stdout.Println(drainer.Drain(cfg, checkStatus))
+	case "recover":
+		if cfg.Consul.Agent.Mode != "server" {
+			stderr.Println("recover can only be run on a consul server")
+			os.Exit(1)
+		}
+
+		timeout := utils.NewTimeout(time.After(time.Duration(cfg.Confab.TimeoutInSeconds) * time.Second))
+
+		tlsConfig, err := newServerTLSConfig(cfg)
+		if err != nil {
+			stderr.Printf("error setting up TLS config: %s", err)
+			os.Exit(1)
+		}
+
+		serverStatusClient := status.ServerClient{TLSConfig: tlsConfig}
+
+		// the agent outlives confab recover, so its output goes to the job's
+		// log files rather than to the operator's terminal
+		stdoutLog, err := openLog(filepath.Join(cfg.Path.LogDir, "consul_agent.stdout.log"))
+		if err != nil {
+			stderr.Printf("error opening log file: %s", err)
+			os.Exit(1)
+		}
+		defer stdoutLog.Close()
+
+		stderrLog, err := openLog(filepath.Join(cfg.Path.LogDir, "consul_agent.stderr.log"))
+		if err != nil {
+			stderr.Printf("error opening log file: %s", err)
+			os.Exit(1)
+		}
+		defer stderrLog.Close()
+
+		agentRunner.Stdout = stdoutLog
+		agentRunner.Stderr = stderrLog
+
+		monit := chaperon.NewMonit(monitPath, "consul_agent", logger)
+
+		recoverer := chaperon.NewRecoverer(controller, agentRunner, agentClient, statusClient, serverStatusClient, monit, retrier, logger)
+		if err := recoverer.Recover(cfg, timeout); err != nil {
+			stderr.Printf("error during recover: %s", err)
+			os.Exit(1)
+		}
+	case "rotate-keys":
+		rotateKeys := cfg.Consul.EncryptKeys
+		if len(keys) > 0 {
+			rotateKeys = keys
+		}
+
+		timeout := utils.NewTimeout(time.After(time.Duration(cfg.Confab.TimeoutInSeconds) * time.Second))
+
+		keyRotator := chaperon.NewKeyRotator(agentClient, retrier, clock.NewClock(), chaperon.KeyRotatorConfig{
+			SettleTime: time.Duration(cfg.Confab.KeyRotationSettleInSeconds) * time.Second,
+		}, logger)
+		if err := keyRotator.Rotate(rotateKeys, timeout); err != nil {
+			stderr.Printf("error during rotate-keys: %s", err)
+			os.Exit(1)
+		}
+	case "reidentify":
+		if utils.IsRunningProcess(agentRunner.PIDFile) {
+			stderr.Println("consul_agent is running, please stop it first")
+			os.Exit(1)
+		}
+
+		identity, err := configWriter.Reidentify(cfg)
+		if err != nil {
+			stderr.Printf("error during reidentify: %s", err)
+			os.Exit(1)
+		}
+
+		stdout.Printf("node name: %s\nnode id:   %s\n", identity.NodeName, identity.NodeID)
+	case "status":
+		agentStatus := chaperon.NewStatusChecker(cfg, agentClient, statusClient, logger).Check()
+
+		if jsonOutput {
+			output, err := json.MarshalIndent(agentStatus, "", "  ")
+			if err != nil {
+				panic(err) // not tested, Status always marshals
+			}
+			stdout.Println(string(output))
+		} else {
+			printStatus(agentStatus)
+		}
+
+		if !agentStatus.Healthy {
+			os.Exit(1)
+		}
 	default:
 		printUsageAndExit(fmt.Sprintf("invalid COMMAND %q", os.Args[1]), flagSet)
 	}
 }
 
+// runHealthcheck runs the probes given as flags and exits with the status of
+// a consul script check. Invalid flags are critical rather than the usage
+// error exit status, which consul would treat as a warning.
+func runHealthcheck() {
+	if service == "" {
+		stderr.Println("healthcheck requires a \"service\"")
+		os.Exit(healthcheck.Critical)
+	}
+
+	var probes []healthcheck.Probe
+	for _, argument := range httpProbes {
+		probe, err := healthcheck.HTTPProbe(argument)
+		if err != nil {
+			stderr.Println(err)
+			os.Exit(healthcheck.Critical)
+		}
+		probes = append(probes, probe)
+	}
+	for _, address := range tcpProbes {
+		probes = append(probes, healthcheck.Probe{TCP: address})
+	}
+	for _, pidFile := range pidFileProbes {
+		probes = append(probes, healthcheck.Probe{PIDFile: pidFile})
+	}
+	for _, command := range commandProbes {
+		probes = append(probes, healthcheck.Probe{Command: command})
+	}
+
+	checker := healthcheck.Checker{
+		Probes:  probes,
+		Timeout: probeTimeout,
+	}
+
+	status, results := checker.Check()
+	stdout.Printf("%s is %s", service, healthcheck.StatusName(status))
+	for _, result := range results {
+		stdout.Printf("%s: %s: %s", healthcheck.StatusName(result.Status), result.Probe, strings.TrimSpace(result.Output))
+	}
+
+	os.Exit(status)
+}
+
+func readConfig() (config.Config, error) {
+	configFileContents, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return config.Config{}, err
+	}
+
+	configConsulLinkFileContents, err := ioutil.ReadFile(configConsulLinkFile)
+	if err != nil {
+		return config.Config{}, err
+	}
+
+	return config.ConfigFromJSON(configFileContents, configConsulLinkFileContents)
+}
+
+// superviseForeground supervises the agent until it exits, stopping it with a
+// graceful leave on SIGTERM or SIGINT and reloading the configuration on
+// SIGHUP. A reloaded configuration is also used by later restarts.
+func superviseForeground(cfg config.Config, supervisor *chaperon.Supervisor, reloader chaperon.Reloader, controller *chaperon.Controller, agentClient *agent.Client, logger lager.Logger) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- supervisor.Supervise()
+	}()
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				logger.Info("foreground.stop", lager.Data{
+					"signal": sig.String(),
+				})
+				supervisor.StopAgent()
+				return <-done
+			}
+
+			logger.Info("foreground.reload")
+			newCfg, err := readConfig()
+			if err != nil {
+				logger.Error("foreground.reload.read-config.failed", err)
+				continue
+			}
+
+			if err := config.ValidateAgent(newCfg); err != nil {
+				logger.Error("foreground.reload.validate.failed", err)
+				continue
+			}
+
+			// the supervisor restarts the agent with the controller and the
+			// agent client, so they are only changed between restarts
+			err = supervisor.Reload(func() error {
+				timeout := utils.NewTimeout(time.After(time.Duration(newCfg.Confab.TimeoutInSeconds) * time.Second))
+				if err := reloader.Reload(cfg, newCfg, timeout); err != nil {
+					return err
+				}
+
+				applyConfig(newCfg, controller, agentClient)
+				return nil
+			})
+			if err != nil {
+				logger.Error("foreground.reload.failed", err)
+				continue
+			}
+
+			cfg = newCfg
+			logger.Info("foreground.reload.success")
+		}
+	}
+}
+
+// newServerTLSConfig returns the TLS config for connections to the server RPC
+// port of other servers. Servers require TLS and verify outgoing connections
+// against the name consul gives servers, server.<datacenter>.<domain>.
+func newServerTLSConfig(cfg config.Config) (*tls.Config, error) {
+	certsDir := filepath.Join(cfg.Path.ConsulConfigDir, "certs")
+
+	cert, err := tls.LoadX509KeyPair(filepath.Join(certsDir, "server.crt"), filepath.Join(certsDir, "server.key"))
+	if err != nil {
+		return nil, err
+	}
+
+	caCert, err := ioutil.ReadFile(filepath.Join(certsDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificates found in %s", filepath.Join(certsDir, "ca.crt"))
+	}
+
+	datacenter := cfg.Consul.Agent.Datacenter
+	if datacenter == "" {
+		datacenter = "dc1"
+	}
+
+	domain := strings.TrimSuffix(cfg.Consul.Agent.Domain, ".")
+	if domain == "" {
+		domain = "consul"
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      rootCAs,
+		ServerName:   fmt.Sprintf("server.%s.%s", datacenter, domain),
+	}, nil
+}
+
+func openLog(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+}
+
+// applyConfig updates the settings the controller and agent client took from
+// the configuration when confab started.
+func applyConfig(cfg config.Config, controller *chaperon.Controller, agentClient *agent.Client) {
+	controller.Config = cfg
+	controller.EncryptKeys = cfg.Consul.EncryptKeys
+
+	agentClient.ExpectedMembers = cfg.Consul.Agent.Servers.LAN
+	agentClient.Datacenter = cfg.Consul.Agent.Datacenter
+	agentClient.MinJoinedServers = cfg.Confab.MinJoinedServers
+	agentClient.RemoveStalePeers = cfg.Confab.RemoveStaleRaftPeers
+	agentClient.JoinedWAN = cfg.Consul.Agent.Mode == "server" && len(cfg.Consul.Agent.Servers.WAN) > 0
+	agentClient.Join.Policy = agent.JoinPolicy(cfg.Confab.JoinPolicy)
+	agentClient.Join.Attempts = cfg.Confab.JoinAttempts
+	agentClient.Join.AttemptTimeout = time.Duration(cfg.Confab.JoinAttemptTimeoutInSeconds) * time.Second
+}
+
 func printUsageAndExit(message string, flagSet *flag.FlagSet) {
 	stderr.Printf("%s\n\n", message)
-	stderr.Println("usage: confab COMMAND OPTIONS\n")
-	stderr.Println("COMMAND: \"start\" or \"stop\"")
+	stderr.Println("usage: confab COMMAND OPTIONS")
+	stderr.Println()
+	stderr.Println("COMMAND: \"start\", \"stop\", \"status\", \"validate\", \"render\", \"recover\", \"drain\", \"rotate-keys\", \"reidentify\" or \"healthcheck\"")
 	stderr.Println("\nOPTIONS:")
 	flagSet.PrintDefaults()
 	stderr.Println()
 	os.Exit(1)
 }
+
+func render(cfg config.Config, logger lager.Logger) {
+	renderer := chaperon.NewRenderer(
+		chaperon.NewConfigWriter(cfg.Path.ConsulConfigDir, logger),
+		config.ServiceDefiner{Logger: logger},
+		logger,
+	)
+
+	files, err := renderer.Render(cfg)
+	if err != nil {
+		stderr.Printf("error during render: %s", err)
+		os.Exit(1)
+	}
+
+	if diff {
+		diffs, err := renderer.Diff(cfg.Path.ConsulConfigDir, files)
+		if err != nil {
+			stderr.Printf("error during render: %s", err)
+			os.Exit(1)
+		}
+
+		changed := false
+		for _, fileDiff := range diffs {
+			if fileDiff.Status == chaperon.FileUnchanged {
+				continue
+			}
+
+			changed = true
+			stdout.Printf("%s (%s)\n", fileDiff.Name, fileDiff.Status)
+			for _, line := range fileDiff.Lines {
+				stdout.Println(line)
+			}
+		}
+
+		if changed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if outputDir != "" {
+		if err := renderer.WriteFiles(outputDir, files); err != nil {
+			stderr.Printf("error during render: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	for _, file := range files {
+		stdout.Printf("==> %s <==\n", file.Name)
+		stdout.Println(strings.TrimRight(string(file.Contents), "\n"))
+	}
+}
+
+func printStatus(agentStatus chaperon.Status) {
+	if agentStatus.Agent.Running {
+		stdout.Printf("agent:   running (pid %d)\n", agentStatus.Agent.PID)
+	} else {
+		stdout.Printf("agent:   not running (pid file %s)\n", agentStatus.Agent.PIDFile)
+	}
+
+	leader := agentStatus.Leader
+	if leader == "" {
+		leader = "none"
+	}
+	stdout.Printf("leader:  %s\n", leader)
+
+	if agentStatus.Raft != nil {
+		stdout.Printf("raft:    commit_index=%s last_log_index=%s\n", agentStatus.Raft.CommitIndex, agentStatus.Raft.LastLogIndex)
+	}
+
+	if agentStatus.Supervisor != nil {
+		stdout.Printf("restarts: %d (last exit: %s)\n", agentStatus.Supervisor.Restarts, agentStatus.Supervisor.LastExitStatus)
+	}
+
+	keyring := "match"
+	if !agentStatus.Keyring.Match {
+		keyring = "mismatch"
+	}
+	stdout.Printf("keyring: %s\n", keyring)
+
+	stdout.Println("members:")
+	for _, member := range agentStatus.Members {
+		stdout.Printf("  %s\t%s\t%s\t%s\n", member.Name, member.Addr, member.Role, member.Status)
+	}
+
+	if agentStatus.Healthy {
+		stdout.Println("status:  healthy")
+		return
+	}
+
+	stdout.Println("status:  unhealthy")
+	for _, problem := range agentStatus.Problems {
+		stdout.Printf("  - %s\n", problem)
+	}
+}
@@ -12,13 +12,74 @@ type Config struct {
 	Path   ConfigPath
 }
 
+const (
+	ServerStartStrategyBootstrap       = "bootstrap"
+	ServerStartStrategyBootstrapExpect = "bootstrap_expect"
+
+	JoinPolicyOne      = "one"
+	JoinPolicyMajority = "majority"
+	JoinPolicyAll      = "all"
+
+	ScriptChecksLocal = "local"
+	ScriptChecksAll   = "all"
+)
+
 type ConfigConfab struct {
-	TimeoutInSeconds int `json:"timeout_in_seconds"`
+	TimeoutInSeconds            int    `json:"timeout_in_seconds"`
+	InterruptTimeoutInSeconds   int    `json:"interrupt_timeout_in_seconds"`
+	TerminateTimeoutInSeconds   int    `json:"terminate_timeout_in_seconds"`
+	MaxRestarts                 int    `json:"max_restarts"`
+	RestartWindowInSeconds      int    `json:"restart_window_in_seconds"`
+	MaxCrashLoops               int    `json:"max_crash_loops"`
+	ServerStartStrategy         string `json:"server_start_strategy"`
+	DrainTimeoutInSeconds       int    `json:"drain_timeout_in_seconds"`
+	JoinPolicy                  string `json:"join_policy"`
+	JoinAttempts                int    `json:"join_attempts"`
+	JoinAttemptTimeoutInSeconds int    `json:"join_attempt_timeout_in_seconds"`
+	MinJoinedServers            int    `json:"min_joined_servers"`
+	RemoveStaleRaftPeers        bool   `json:"remove_stale_raft_peers"`
+	KeyRotationSettleInSeconds  int    `json:"key_rotation_settle_in_seconds"`
 }
 
 type ConfigConsul struct {
 	Agent       ConfigConsulAgent
-	EncryptKeys []string `json:"encrypt_keys"`
+	EncryptKeys []string        `json:"encrypt_keys"`
+	ACL         ConfigConsulACL `json:"acl"`
+}
+
+type ConfigConsulACL struct {
+	Datacenter    string                          `json:"datacenter"`
+	MasterToken   string                          `json:"master_token"`
+	AgentToken    string                          `json:"agent_token"`
+	DefaultPolicy string                          `json:"default_policy"`
+	DownPolicy    string                          `json:"down_policy"`
+	Policies      map[string]string               `json:"policies"`
+	Tokens        map[string]ConfigConsulACLToken `json:"tokens"`
+}
+
+// ConfigConsulACLToken is a token confab creates on the servers. Policy names
+// one of the ACL policies, whose rules are given to the token.
+type ConfigConsulACLToken struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Policy string `json:"policy"`
+}
+
+// Enabled reports whether the ACL system is configured, which it is once an
+// ACL datacenter is given.
+func (a ConfigConsulACL) Enabled() bool {
+	return a.Datacenter != ""
+}
+
+// TokenID returns the ID of the declared token called name, or name itself
+// when no such token is declared, so that services can either name a token or
+// give its ID.
+func (a ConfigConsulACL) TokenID(name string) string {
+	if token, ok := a.Tokens[name]; ok {
+		return token.ID
+	}
+
+	return name
 }
 
 type ConfigPath struct {
@@ -27,29 +88,35 @@ type ConfigPath struct {
 	PIDFile         string `json:"pid_file"`
 	KeyringFile     string `json:"keyring_file"`
 	DataDir         string `json:"data_dir"`
+	LogDir          string `json:"log_dir"`
 }
 
 type ConfigNode struct {
 	Name       string `json:"name"`
 	Index      int    `json:"index"`
+	ID         string `json:"id"`
+	Deployment string `json:"deployment"`
 	ExternalIP string `json:"external_ip"`
 	Zone       string `json:"zone"`
 }
 
 type ConfigConsulAgent struct {
-	Servers         ConfigConsulAgentServers     `json:"servers"`
-	Services        map[string]ServiceDefinition `json:"services"`
-	Mode            string                       `json:"mode"`
-	Domain          string                       `json:"domain"`
-	Datacenter      string                       `json:"datacenter"`
-	LogLevel        string                       `json:"log_level"`
-	ProtocolVersion int                          `json:"protocol_version"`
-	DnsConfig       ConfigConsulAgentDnsConfig   `json:"dns_config"`
-	Telemetry       ConfigConsulTelemetry        `json:"telemetry"`
-	Bootstrap       bool                         `json:"bootstrap"`
-	NodeName        string                       `json:"node_name"`
-	RequireSSL      bool                         `json:"require_ssl"`
-	Ports           ConfigConsulAgentPorts       `json:"ports"`
+	Servers         ConfigConsulAgentServers      `json:"servers"`
+	Services        map[string]ServiceDefinition  `json:"services"`
+	Mode            string                        `json:"mode"`
+	Domain          string                        `json:"domain"`
+	Datacenter      string                        `json:"datacenter"`
+	LogLevel        string                        `json:"log_level"`
+	ProtocolVersion int                           `json:"protocol_version"`
+	RaftProtocol    int                           `json:"raft_protocol"`
+	DnsConfig       ConfigConsulAgentDnsConfig    `json:"dns_config"`
+	Telemetry       ConfigConsulTelemetry         `json:"telemetry"`
+	Bootstrap       bool                          `json:"bootstrap"`
+	NodeName        string                        `json:"node_name"`
+	RequireSSL      bool                          `json:"require_ssl"`
+	Ports           ConfigConsulAgentPorts        `json:"ports"`
+	DefaultCheck    ConfigConsulAgentDefaultCheck `json:"default_check"`
+	ScriptChecks    string                        `json:"script_checks"`
 }
 
 type ConfigConsulAgentPorts struct {
@@ -63,6 +130,20 @@ type ConfigConsulAgentDnsConfig struct {
 	ServiceTTL      string `json:"service_ttl"`
 }
 
+// ConfigConsulAgentDefaultCheck configures the check of services that do not
+// define one. Script and Interpreter may refer to the {service}, {node_name}
+// and {node_index} placeholders, and Interpreter to {script}. HTTPPath is
+// requested by HTTP checks, and TTL is given to TTL checks. Fields that are
+// not set fall back to the defaults of the platform.
+type ConfigConsulAgentDefaultCheck struct {
+	Script      string `json:"script"`
+	Interpreter string `json:"interpreter"`
+	Interval    string `json:"interval"`
+	Timeout     string `json:"timeout"`
+	TTL         string `json:"ttl"`
+	HTTPPath    string `json:"http_path"`
+}
+
 type ConfigConsulTelemetry struct {
 	StatsdAddress string `json:"statsd_address"`
 }
@@ -78,6 +159,7 @@ func defaultConfig() Config {
 			AgentPath:       "/var/vcap/packages/consul/bin/consul",
 			ConsulConfigDir: "/var/vcap/jobs/consul_agent/config",
 			PIDFile:         "/var/vcap/sys/run/consul_agent/consul_agent.pid",
+			LogDir:          "/var/vcap/sys/log/consul_agent",
 		},
 		Consul: ConfigConsul{
 			Agent: ConfigConsulAgent{
@@ -94,7 +176,20 @@ func defaultConfig() Config {
 			},
 		},
 		Confab: ConfigConfab{
-			TimeoutInSeconds: 55,
+			TimeoutInSeconds:            55,
+			InterruptTimeoutInSeconds:   10,
+			TerminateTimeoutInSeconds:   5,
+			MaxRestarts:                 5,
+			RestartWindowInSeconds:      300,
+			MaxCrashLoops:               3,
+			ServerStartStrategy:         ServerStartStrategyBootstrap,
+			DrainTimeoutInSeconds:       600,
+			JoinPolicy:                  JoinPolicyOne,
+			JoinAttempts:                3,
+			JoinAttemptTimeoutInSeconds: 5,
+			MinJoinedServers:            1,
+			RemoveStaleRaftPeers:        false,
+			KeyRotationSettleInSeconds:  30,
 		},
 	}
 }
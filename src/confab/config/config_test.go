@@ -17,6 +17,8 @@ var _ = Describe("Config", func() {
 					"node": {
 						"name": "nodename",
 						"index": 1234,
+						"id": "b5c7d4a1-6f0e-4d2c-8e3b-9a1f2c3d4e5f",
+						"deployment": "cf",
 						"external_ip": "10.0.0.1",
 						"zone": "z1"
 					},
@@ -25,7 +27,8 @@ var _ = Describe("Config", func() {
 						"consul_config_dir": "/consul/config/dir",
 						"pid_file": "/path/to/pidfile",
 						"keyring_file": "/path/to/keyring",
-						"data_dir": "/path/to/data/dir"
+						"data_dir": "/path/to/data/dir",
+						"log_dir": "/path/to/log/dir"
 					},
 					"consul": {
 						"agent": {
@@ -45,6 +48,12 @@ var _ = Describe("Config", func() {
 							"telemetry": {
 								"statsd_address": "myhost:8125"
 							},
+							"default_check": {
+								"script": "/opt/{service}/bin/check",
+								"interpreter": "bash",
+								"interval": "10s",
+								"timeout": "5s"
+							},
 							"dns_config": {
 								"allow_stale": true,
 								"max_stale": "15s",
@@ -53,10 +62,40 @@ var _ = Describe("Config", func() {
 							},
 							"require_ssl": true
 						},
-						"encrypt_keys": ["key-1", "key-2"]
+						"encrypt_keys": ["key-1", "key-2"],
+						"acl": {
+							"datacenter": "dc1",
+							"master_token": "master-token",
+							"agent_token": "agent-token",
+							"default_policy": "deny",
+							"down_policy": "extend-cache",
+							"policies": {
+								"router": "service \"gorouter\" { policy = \"write\" }"
+							},
+							"tokens": {
+								"router": {
+									"id": "router-token",
+									"type": "client",
+									"policy": "router"
+								}
+							}
+						}
 					},
 					"confab": {
-						"timeout_in_seconds": 30
+						"timeout_in_seconds": 30,
+						"interrupt_timeout_in_seconds": 20,
+						"terminate_timeout_in_seconds": 15,
+						"max_restarts": 2,
+						"restart_window_in_seconds": 60,
+						"max_crash_loops": 1,
+						"server_start_strategy": "bootstrap_expect",
+						"drain_timeout_in_seconds": 120,
+						"join_policy": "majority",
+						"join_attempts": 5,
+						"join_attempt_timeout_in_seconds": 10,
+						"min_joined_servers": 2,
+						"remove_stale_raft_peers": true,
+						"key_rotation_settle_in_seconds": 60
 					}
 				}`)
 
@@ -69,10 +108,13 @@ var _ = Describe("Config", func() {
 						PIDFile:         "/path/to/pidfile",
 						KeyringFile:     "/path/to/keyring",
 						DataDir:         "/path/to/data/dir",
+						LogDir:          "/path/to/log/dir",
 					},
 					Node: config.ConfigNode{
 						Name:       "nodename",
 						Index:      1234,
+						ID:         "b5c7d4a1-6f0e-4d2c-8e3b-9a1f2c3d4e5f",
+						Deployment: "cf",
 						ExternalIP: "10.0.0.1",
 						Zone:       "z1",
 					},
@@ -94,6 +136,12 @@ var _ = Describe("Config", func() {
 							Telemetry: config.ConfigConsulTelemetry{
 								StatsdAddress: "myhost:8125",
 							},
+							DefaultCheck: config.ConfigConsulAgentDefaultCheck{
+								Script:      "/opt/{service}/bin/check",
+								Interpreter: "bash",
+								Interval:    "10s",
+								Timeout:     "5s",
+							},
 							DnsConfig: config.ConfigConsulAgentDnsConfig{
 								AllowStale:      true,
 								MaxStale:        "15s",
@@ -103,9 +151,39 @@ var _ = Describe("Config", func() {
 							RequireSSL: true,
 						},
 						EncryptKeys: []string{"key-1", "key-2"},
+						ACL: config.ConfigConsulACL{
+							Datacenter:    "dc1",
+							MasterToken:   "master-token",
+							AgentToken:    "agent-token",
+							DefaultPolicy: "deny",
+							DownPolicy:    "extend-cache",
+							Policies: map[string]string{
+								"router": `service "gorouter" { policy = "write" }`,
+							},
+							Tokens: map[string]config.ConfigConsulACLToken{
+								"router": {
+									ID:     "router-token",
+									Type:   "client",
+									Policy: "router",
+								},
+							},
+						},
 					},
 					Confab: config.ConfigConfab{
-						TimeoutInSeconds: 30,
+						TimeoutInSeconds:            30,
+						InterruptTimeoutInSeconds:   20,
+						TerminateTimeoutInSeconds:   15,
+						MaxRestarts:                 2,
+						RestartWindowInSeconds:      60,
+						MaxCrashLoops:               1,
+						ServerStartStrategy:         "bootstrap_expect",
+						DrainTimeoutInSeconds:       120,
+						JoinPolicy:                  "majority",
+						JoinAttempts:                5,
+						JoinAttemptTimeoutInSeconds: 10,
+						MinJoinedServers:            2,
+						RemoveStaleRaftPeers:        true,
+						KeyRotationSettleInSeconds:  60,
 					},
 				}))
 			})
@@ -121,6 +199,7 @@ var _ = Describe("Config", func() {
 				cfg.Path.PIDFile = filepath.ToSlash(cfg.Path.PIDFile)
 				cfg.Path.KeyringFile = filepath.ToSlash(cfg.Path.KeyringFile)
 				cfg.Path.DataDir = filepath.ToSlash(cfg.Path.DataDir)
+				cfg.Path.LogDir = filepath.ToSlash(cfg.Path.LogDir)
 
 				Expect(err).NotTo(HaveOccurred())
 				Expect(cfg).To(Equal(config.Config{
@@ -130,6 +209,7 @@ var _ = Describe("Config", func() {
 						PIDFile:         "/var/vcap/sys/run/consul_agent/consul_agent.pid",
 						KeyringFile:     "/var/vcap/data/consul_agent/serf/local.keyring",
 						DataDir:         "/var/vcap/data/consul_agent",
+						LogDir:          "/var/vcap/sys/log/consul_agent",
 					},
 					Consul: config.ConfigConsul{
 						Agent: config.ConfigConsulAgent{
@@ -146,7 +226,20 @@ var _ = Describe("Config", func() {
 						},
 					},
 					Confab: config.ConfigConfab{
-						TimeoutInSeconds: 55,
+						TimeoutInSeconds:            55,
+						InterruptTimeoutInSeconds:   10,
+						TerminateTimeoutInSeconds:   5,
+						MaxRestarts:                 5,
+						RestartWindowInSeconds:      300,
+						MaxCrashLoops:               3,
+						ServerStartStrategy:         "bootstrap",
+						DrainTimeoutInSeconds:       600,
+						JoinPolicy:                  "one",
+						JoinAttempts:                3,
+						JoinAttemptTimeoutInSeconds: 5,
+						MinJoinedServers:            1,
+						RemoveStaleRaftPeers:        false,
+						KeyRotationSettleInSeconds:  30,
 					},
 				}))
 			})
@@ -15,6 +15,7 @@ type ConsulConfig struct {
 	DataDir              string                  `json:"data_dir"`
 	LogLevel             string                  `json:"log_level"`
 	NodeName             string                  `json:"node_name"`
+	NodeID               string                  `json:"node_id,omitempty"`
 	Ports                ConsulConfigPorts       `json:"ports"`
 	RejoinAfterLeave     bool                    `json:"rejoin_after_leave"`
 	BindAddr             string                  `json:"bind_addr"`
@@ -30,8 +31,14 @@ type ConsulConfig struct {
 	Encrypt              *string                 `json:"encrypt,omitempty"`
 	DnsConfig            ConsulConfigDnsConfig   `json:"dns_config"`
 	Bootstrap            *bool                   `json:"bootstrap,omitempty"`
+	BootstrapExpect      *int                    `json:"bootstrap_expect,omitempty"`
 	Performance          ConsulConfigPerformance `json:"performance"`
 	Telemetry            *ConsulConfigTelemetry  `json:"telemetry,omitempty"`
+	ACLDatacenter        string                  `json:"acl_datacenter,omitempty"`
+	ACLMasterToken       string                  `json:"acl_master_token,omitempty"`
+	ACLAgentToken        string                  `json:"acl_agent_token,omitempty"`
+	ACLDefaultPolicy     string                  `json:"acl_default_policy,omitempty"`
+	ACLDownPolicy        string                  `json:"acl_down_policy,omitempty"`
 	TLSMinVersion        string                  `json:"tls_min_version"`
 }
 
@@ -60,7 +67,7 @@ type ConsulConfigTelemetry struct {
 	StatsdAddress string `json:"statsd_address,omitempty"`
 }
 
-func GenerateConfiguration(config Config, configDir, nodeName string) ConsulConfig {
+func GenerateConfiguration(config Config, configDir, nodeName, nodeID string) ConsulConfig {
 	lan := config.Consul.Agent.Servers.LAN
 	if lan == nil {
 		lan = []string{}
@@ -85,6 +92,7 @@ func GenerateConfiguration(config Config, configDir, nodeName string) ConsulConf
 		DataDir:            config.Path.DataDir,
 		LogLevel:           config.Consul.Agent.LogLevel,
 		NodeName:           nodeName,
+		NodeID:             nodeID,
 		RejoinAfterLeave:   true,
 		BindAddr:           config.Node.ExternalIP,
 		DisableRemoteExec:  true,
@@ -136,8 +144,28 @@ func GenerateConfiguration(config Config, configDir, nodeName string) ConsulConf
 		consulConfig.Encrypt = encryptKey(config.Consul.EncryptKeys[0])
 	}
 
+	if acl := config.Consul.ACL; acl.Enabled() {
+		consulConfig.ACLDatacenter = acl.Datacenter
+		consulConfig.ACLAgentToken = acl.AgentToken
+		consulConfig.ACLDefaultPolicy = acl.DefaultPolicy
+		consulConfig.ACLDownPolicy = acl.DownPolicy
+
+		// the master token is only read by servers, and is kept off clients
+		if isServer {
+			consulConfig.ACLMasterToken = acl.MasterToken
+		}
+	}
+
 	if isServer {
-		consulConfig.Bootstrap = boolPtr(config.Consul.Agent.Bootstrap)
+		if config.Confab.ServerStartStrategy == ServerStartStrategyBootstrapExpect {
+			// a bootstrap_expect of 0 would never elect a leader,
+			// BootstrapExpectServer refuses to start without lan servers
+			if len(lan) > 0 {
+				consulConfig.BootstrapExpect = intPtr(len(lan))
+			}
+		} else {
+			consulConfig.Bootstrap = boolPtr(config.Consul.Agent.Bootstrap)
+		}
 	}
 
 	return consulConfig
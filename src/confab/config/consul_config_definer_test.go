@@ -16,7 +16,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 
 		BeforeEach(func() {
 			configDir = "/var/vcap/jobs/consul_agent/config"
-			consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "")
+			consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "", "")
 		})
 
 		Describe("datacenter", func() {
@@ -32,7 +32,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 								Datacenter: "my-datacenter",
 							},
 						},
-					}, configDir, "")
+					}, configDir, "", "")
 					Expect(consulConfig.Datacenter).To(Equal("my-datacenter"))
 				})
 			})
@@ -53,12 +53,57 @@ var _ = Describe("ConsulConfigDefiner", func() {
 								},
 							},
 						},
-					}, configDir, "")
+					}, configDir, "", "")
 					Expect(consulConfig.Telemetry.StatsdAddress).To(Equal("some-statsd-address"))
 				})
 			})
 		})
 
+		Describe("acl", func() {
+			var acl config.ConfigConsulACL
+
+			BeforeEach(func() {
+				acl = config.ConfigConsulACL{
+					Datacenter:    "dc1",
+					MasterToken:   "master-token",
+					AgentToken:    "agent-token",
+					DefaultPolicy: "deny",
+					DownPolicy:    "extend-cache",
+				}
+			})
+
+			It("does not configure acls by default", func() {
+				Expect(consulConfig.ACLDatacenter).To(BeEmpty())
+				Expect(consulConfig.ACLMasterToken).To(BeEmpty())
+			})
+
+			It("configures acls for servers", func() {
+				consulConfig = config.GenerateConfiguration(config.Config{
+					Consul: config.ConfigConsul{
+						Agent: config.ConfigConsulAgent{Mode: "server"},
+						ACL:   acl,
+					},
+				}, configDir, "", "")
+				Expect(consulConfig.ACLDatacenter).To(Equal("dc1"))
+				Expect(consulConfig.ACLMasterToken).To(Equal("master-token"))
+				Expect(consulConfig.ACLAgentToken).To(Equal("agent-token"))
+				Expect(consulConfig.ACLDefaultPolicy).To(Equal("deny"))
+				Expect(consulConfig.ACLDownPolicy).To(Equal("extend-cache"))
+			})
+
+			It("does not give clients the master token", func() {
+				consulConfig = config.GenerateConfiguration(config.Config{
+					Consul: config.ConfigConsul{
+						Agent: config.ConfigConsulAgent{Mode: "client"},
+						ACL:   acl,
+					},
+				}, configDir, "", "")
+				Expect(consulConfig.ACLDatacenter).To(Equal("dc1"))
+				Expect(consulConfig.ACLAgentToken).To(Equal("agent-token"))
+				Expect(consulConfig.ACLMasterToken).To(BeEmpty())
+			})
+		})
+
 		Describe("domain", func() {
 			It("it gets the domain suffix from the config", func() {
 				config := config.GenerateConfiguration(config.Config{
@@ -67,7 +112,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 							Domain: "some-domain",
 						},
 					},
-				}, configDir, "")
+				}, configDir, "", "")
 
 				Expect(config.Domain).To(Equal("some-domain"))
 			})
@@ -96,7 +141,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 									},
 								},
 							},
-						}, configDir, "")
+						}, configDir, "", "")
 						Expect(consulConfig.DnsConfig.RecursorTimeout).To(Equal("10s"))
 					})
 				})
@@ -113,7 +158,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 									},
 								},
 							},
-						}, configDir, "")
+						}, configDir, "", "")
 						Expect(consulConfig.DnsConfig.AllowStale).To(BeTrue())
 					})
 				})
@@ -130,7 +175,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 									},
 								},
 							},
-						}, configDir, "")
+						}, configDir, "", "")
 						Expect(consulConfig.DnsConfig.MaxStale).To(Equal("15s"))
 					})
 				})
@@ -147,7 +192,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 									},
 								},
 							},
-						}, configDir, "")
+						}, configDir, "", "")
 						Expect(consulConfig.DnsConfig.ServiceTTL.AllServices).To(Equal("15s"))
 					})
 				})
@@ -167,7 +212,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 								LogLevel: "some-log-level",
 							},
 						},
-					}, configDir, "")
+					}, configDir, "", "")
 					Expect(consulConfig.LogLevel).To(Equal("some-log-level"))
 				})
 			})
@@ -175,11 +220,23 @@ var _ = Describe("ConsulConfigDefiner", func() {
 
 		Describe("node_name", func() {
 			It("uses the specified node name", func() {
-				consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "some-node-name")
+				consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "some-node-name", "")
 				Expect(consulConfig.NodeName).To(Equal("some-node-name"))
 			})
 		})
 
+		Describe("node_id", func() {
+			It("uses the specified node id", func() {
+				consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "some-node-name", "0b2b4a7f-3c58-4c8e-9a3e-2a3c1f6b0d9e")
+				Expect(consulConfig.NodeID).To(Equal("0b2b4a7f-3c58-4c8e-9a3e-2a3c1f6b0d9e"))
+			})
+
+			It("leaves the node id to consul when none is specified", func() {
+				consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "some-node-name", "")
+				Expect(consulConfig.NodeID).To(BeEmpty())
+			})
+		})
+
 		Describe("server", func() {
 			It("defaults to false", func() {
 				Expect(consulConfig.Server).To(BeFalse())
@@ -193,7 +250,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 								Mode: "server",
 							},
 						},
-					}, configDir, "")
+					}, configDir, "", "")
 					Expect(consulConfig.Server).To(BeTrue())
 				})
 			})
@@ -206,7 +263,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 								Mode: "banana",
 							},
 						},
-					}, configDir, "")
+					}, configDir, "", "")
 					Expect(consulConfig.Server).To(BeFalse())
 				})
 			})
@@ -229,7 +286,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 						Node: config.ConfigNode{
 							ExternalIP: "0.0.0.0",
 						},
-					}, configDir, "")
+					}, configDir, "", "")
 					Expect(consulConfig.BindAddr).To(Equal("0.0.0.0"))
 				})
 			})
@@ -263,7 +320,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 									},
 								},
 							},
-						}, configDir, "")
+						}, configDir, "", "")
 						Expect(consulConfig.Ports.DNS).To(Equal(5300))
 					})
 				})
@@ -277,7 +334,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 								RequireSSL: true,
 							},
 						},
-					}, configDir, "")
+					}, configDir, "", "")
 				})
 
 				Describe("HTTP port", func() {
@@ -307,7 +364,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 								ProtocolVersion: 21,
 							},
 						},
-					}, configDir, "")
+					}, configDir, "", "")
 					Expect(consulConfig.Protocol).To(Equal(21))
 				})
 			})
@@ -315,7 +372,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 
 		Describe("verify_outgoing", func() {
 			It("is true", func() {
-				consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "")
+				consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "", "")
 				Expect(consulConfig.VerifyOutgoing).NotTo(BeNil())
 				Expect(*consulConfig.VerifyOutgoing).To(BeTrue())
 			})
@@ -323,7 +380,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 
 		Describe("verify_incoming", func() {
 			It("is true", func() {
-				consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "")
+				consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "", "")
 				Expect(consulConfig.VerifyIncoming).NotTo(BeNil())
 				Expect(*consulConfig.VerifyIncoming).To(BeTrue())
 			})
@@ -331,7 +388,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 
 		Describe("verify_server_hostname", func() {
 			It("is true", func() {
-				consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "")
+				consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "", "")
 				Expect(consulConfig.VerifyServerHostname).NotTo(BeNil())
 				Expect(*consulConfig.VerifyServerHostname).To(BeTrue())
 			})
@@ -355,7 +412,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 								Mode: "server",
 							},
 						},
-					}, configDir, "")
+					}, configDir, "", "")
 					Expect(consulConfig.KeyFile).NotTo(BeNil())
 					Expect(filepath.ToSlash(*consulConfig.KeyFile)).To(
 						Equal("/var/vcap/jobs/consul_agent/config/certs/server.key"))
@@ -364,7 +421,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 
 			Context("when `consul.agent.mode` is not `server`", func() {
 				It("is the location of the agent.key file", func() {
-					consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "")
+					consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "", "")
 					Expect(consulConfig.KeyFile).NotTo(BeNil())
 					Expect(filepath.ToSlash(*consulConfig.KeyFile)).To(
 						Equal("/var/vcap/jobs/consul_agent/config/certs/agent.key"))
@@ -381,7 +438,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 								Mode: "server",
 							},
 						},
-					}, configDir, "")
+					}, configDir, "", "")
 					Expect(consulConfig.CertFile).NotTo(BeNil())
 					Expect(filepath.ToSlash(*consulConfig.CertFile)).To(
 						Equal("/var/vcap/jobs/consul_agent/config/certs/server.crt"))
@@ -390,7 +447,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 
 			Context("when `consul.agent.mode` is not `server`", func() {
 				It("is the location of the agent.key file", func() {
-					consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "")
+					consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "", "")
 					Expect(consulConfig.CertFile).NotTo(BeNil())
 					Expect(filepath.ToSlash(*consulConfig.CertFile)).To(
 						Equal("/var/vcap/jobs/consul_agent/config/certs/agent.crt"))
@@ -401,7 +458,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 		Describe("encrypt", func() {
 			Context("when `consul.encrypt_keys` is empty", func() {
 				It("is nil", func() {
-					consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "")
+					consulConfig = config.GenerateConfiguration(config.Config{}, configDir, "", "")
 					Expect(consulConfig.Encrypt).To(BeNil())
 				})
 			})
@@ -413,7 +470,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 							Consul: config.ConfigConsul{
 								EncryptKeys: []string{"banana"},
 							},
-						}, configDir, "")
+						}, configDir, "", "")
 					Expect(consulConfig.Encrypt).NotTo(BeNil())
 					Expect(*consulConfig.Encrypt).To(Equal("enqzXBmgKOy13WIGsmUk+g=="))
 				})
@@ -424,7 +481,7 @@ var _ = Describe("ConsulConfigDefiner", func() {
 							Consul: config.ConfigConsul{
 								EncryptKeys: []string{"enqzXBmgKOy13WIGsmUk+g=="},
 							},
-						}, configDir, "")
+						}, configDir, "", "")
 					Expect(consulConfig.Encrypt).NotTo(BeNil())
 					Expect(*consulConfig.Encrypt).To(Equal("enqzXBmgKOy13WIGsmUk+g=="))
 				})
@@ -454,8 +511,64 @@ var _ = Describe("ConsulConfigDefiner", func() {
 								},
 							},
 						},
-					}, configDir, "")
+					}, configDir, "", "")
 					Expect(*consulConfig.Bootstrap).To(BeTrue())
+					Expect(consulConfig.BootstrapExpect).To(BeNil())
+				})
+
+				Context("when the server start strategy is `bootstrap_expect`", func() {
+					It("sets bootstrap_expect to the number of lan servers instead of bootstrap", func() {
+						consulConfig = config.GenerateConfiguration(config.Config{
+							Confab: config.ConfigConfab{
+								ServerStartStrategy: "bootstrap_expect",
+							},
+							Consul: config.ConfigConsul{
+								Agent: config.ConfigConsulAgent{
+									Bootstrap: true,
+									Mode:      "server",
+									Servers: config.ConfigConsulAgentServers{
+										LAN: []string{
+											"first-server",
+											"second-server",
+											"third-server",
+										},
+									},
+								},
+							},
+						}, configDir, "", "")
+						Expect(consulConfig.Bootstrap).To(BeNil())
+						Expect(*consulConfig.BootstrapExpect).To(Equal(3))
+					})
+
+					It("does not set bootstrap_expect when there are no lan servers", func() {
+						consulConfig = config.GenerateConfiguration(config.Config{
+							Confab: config.ConfigConfab{
+								ServerStartStrategy: "bootstrap_expect",
+							},
+							Consul: config.ConfigConsul{
+								Agent: config.ConfigConsulAgent{
+									Mode: "server",
+								},
+							},
+						}, configDir, "", "")
+						Expect(consulConfig.BootstrapExpect).To(BeNil())
+					})
+				})
+			})
+
+			Context("when the server start strategy is `bootstrap_expect` and the agent is a client", func() {
+				It("does not set bootstrap_expect", func() {
+					consulConfig = config.GenerateConfiguration(config.Config{
+						Confab: config.ConfigConfab{
+							ServerStartStrategy: "bootstrap_expect",
+						},
+						Consul: config.ConfigConsul{
+							Agent: config.ConfigConsulAgent{
+								Mode: "client",
+							},
+						},
+					}, configDir, "", "")
+					Expect(consulConfig.BootstrapExpect).To(BeNil())
 				})
 			})
 		})
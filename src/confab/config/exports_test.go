@@ -3,22 +3,16 @@ package config
 import (
 	"os"
 	"runtime"
-)
-
-func SetCreateFile(f func(string) (*os.File, error)) {
-	createFile = f
-}
 
-func ResetCreateFile() {
-	createFile = os.Create
-}
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
+)
 
-func SetSyncFile(f func(*os.File) error) {
-	syncFile = f
+func SetWriteFile(f func(string, []byte, os.FileMode, *utils.FileOwner) (bool, error)) {
+	writeFile = f
 }
 
-func ResetSyncFile() {
-	syncFile = syncFileFn
+func ResetWriteFile() {
+	writeFile = utils.WriteFileAtomically
 }
 
 func SetGOOS(os string) {
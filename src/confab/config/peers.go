@@ -0,0 +1,44 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+)
+
+const serverRPCPort = "8300"
+
+// DefaultRaftProtocol is the raft protocol the packaged consul runs, which is
+// assumed when consul.agent.raft_protocol is not set.
+const DefaultRaftProtocol = 2
+
+// GeneratePeers returns the contents of raft/peers.json listing every LAN
+// server, for recovering a cluster that has lost quorum. The format follows
+// consul.agent.raft_protocol rather than the gossip protocol_version. Raft
+// protocol 3 identifies peers by node ID, which cannot be derived from the
+// server addresses.
+func GeneratePeers(config Config) ([]byte, error) {
+	raftProtocol := config.Consul.Agent.RaftProtocol
+	if raftProtocol == 0 {
+		raftProtocol = DefaultRaftProtocol
+	}
+
+	if raftProtocol >= 3 {
+		return nil, errors.New("peers.json for raft protocol 3 requires node IDs, which cannot be derived from consul.agent.servers.lan")
+	}
+
+	if len(config.Consul.Agent.Servers.LAN) == 0 {
+		return nil, errors.New("consul.agent.servers.lan must not be empty")
+	}
+
+	peers := []string{}
+	for _, server := range config.Consul.Agent.Servers.LAN {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, serverRPCPort)
+		}
+
+		peers = append(peers, server)
+	}
+
+	return json.Marshal(peers)
+}
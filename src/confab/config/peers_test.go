@@ -0,0 +1,49 @@
+package config_test
+
+import (
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GeneratePeers", func() {
+	var cfg config.Config
+
+	BeforeEach(func() {
+		cfg = config.Config{}
+		cfg.Consul.Agent.ProtocolVersion = 2
+		cfg.Consul.Agent.Servers.LAN = []string{"10.0.0.1", "10.0.0.2:8300", "consul-2.example.com"}
+	})
+
+	It("lists the server rpc address of every lan server", func() {
+		peers, err := config.GeneratePeers(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(peers).To(MatchJSON(`["10.0.0.1:8300", "10.0.0.2:8300", "consul-2.example.com:8300"]`))
+	})
+
+	It("bases the format on the raft protocol rather than the gossip protocol version", func() {
+		cfg.Consul.Agent.ProtocolVersion = 3
+		cfg.Consul.Agent.RaftProtocol = 2
+
+		peers, err := config.GeneratePeers(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(peers).To(MatchJSON(`["10.0.0.1:8300", "10.0.0.2:8300", "consul-2.example.com:8300"]`))
+	})
+
+	Context("failure cases", func() {
+		It("returns an error for raft protocol 3", func() {
+			cfg.Consul.Agent.RaftProtocol = 3
+
+			_, err := config.GeneratePeers(cfg)
+			Expect(err).To(MatchError(ContainSubstring("raft protocol 3 requires node IDs")))
+		})
+
+		It("returns an error when there are no lan servers", func() {
+			cfg.Consul.Agent.Servers.LAN = []string{}
+
+			_, err := config.GeneratePeers(cfg)
+			Expect(err).To(MatchError("consul.agent.servers.lan must not be empty"))
+		})
+	})
+})
@@ -1,25 +1,31 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 
 	"code.cloudfoundry.org/lager"
 	"golang.org/x/net/idna"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
 )
 
-var createFile = os.Create
-var syncFile = syncFileFn
+var writeFile = utils.WriteFileAtomically
 var goos = runtime.GOOS
 
-func syncFileFn(f *os.File) error {
-	return f.Sync()
-}
+// definitionFileMode keeps definitions, which may hold ACL tokens, from being
+// read by other users.
+const definitionFileMode = 0640
 
 type logger interface {
 	Info(action string, data ...lager.Data)
@@ -37,6 +43,28 @@ type ServiceDefinition struct {
 	EnableTagOverride bool                     `json:"enableTagOverride,omitempty"`
 	ID                string                   `json:"id,omitempty"`
 	Token             string                   `json:"token,omitempty"`
+
+	// DefaultCheckType and HealthProbes configure the default check and are
+	// not passed to consul.
+	DefaultCheckType string               `json:"default_check_type,omitempty"`
+	HealthProbes     []ServiceHealthProbe `json:"health_probes,omitempty"`
+}
+
+const (
+	DefaultCheckTypeScript = "script"
+	DefaultCheckTypeHTTP   = "http"
+	DefaultCheckTypeTCP    = "tcp"
+	DefaultCheckTypeTTL    = "ttl"
+)
+
+// ServiceHealthProbe is a probe run by the default check through
+// "confab healthcheck". Exactly one of HTTP, TCP, PIDFile or Command is set.
+type ServiceHealthProbe struct {
+	HTTP           string `json:"http,omitempty"`
+	ExpectedStatus int    `json:"expected_status,omitempty"`
+	TCP            string `json:"tcp,omitempty"`
+	PIDFile        string `json:"pid_file,omitempty"`
+	Command        string `json:"command,omitempty"`
 }
 
 type ServiceDefinitionCheck struct {
@@ -61,17 +89,12 @@ type ServiceDefiner struct {
 
 func (s ServiceDefiner) GenerateDefinitions(config Config) ([]ServiceDefinition, error) {
 	definitions := []ServiceDefinition{}
+	defaultCheck := config.Consul.Agent.DefaultCheck.withDefaults()
 
 	for name, service := range config.Consul.Agent.Services {
 		s.Logger.Info("service-definer.generate-definitions.define", lager.Data{
 			"service": name,
 		})
-		var command string
-		if goos == "windows" {
-			command = "powershell -Command /var/vcap/jobs/%s/bin/dns_health_check.ps1; Exit $LASTEXITCODE"
-		} else {
-			command = "/var/vcap/jobs/%s/bin/dns_health_check"
-		}
 		tags := []string{
 			fmt.Sprintf("%s-%d", strings.Replace(config.Node.Name, "_", "-", -1), config.Node.Index),
 		}
@@ -88,31 +111,36 @@ func (s ServiceDefiner) GenerateDefinitions(config Config) ([]ServiceDefinition,
 
 			tags = append(tags, encodedZone)
 		}
+
+		check := service.Check
+		if check == nil {
+			var err error
+			check, err = defaultServiceCheck(name, service, config.Node, defaultCheck)
+			if err != nil {
+				s.Logger.Error("service-definer.generate-definitions.default-check.failed", err, lager.Data{
+					"service": name,
+				})
+				return nil, err
+			}
+		}
+
 		definition := ServiceDefinition{
-			ServiceName: name,
-			Name:        strings.Replace(name, "_", "-", -1),
-			Check: &ServiceDefinitionCheck{
-				Name:     "dns_health_check",
-				Script:   fmt.Sprintf(command, name),
-				Interval: "3s",
-			},
+			ServiceName:       name,
+			Name:              strings.Replace(name, "_", "-", -1),
+			Check:             check,
 			Checks:            service.Checks,
 			Tags:              tags,
 			Address:           service.Address,
 			Port:              service.Port,
 			EnableTagOverride: service.EnableTagOverride,
 			ID:                service.ID,
-			Token:             service.Token,
+			Token:             config.Consul.ACL.TokenID(service.Token),
 		}
 
 		if service.Name != "" {
 			definition.Name = service.Name
 		}
 
-		if service.Check != nil {
-			definition.Check = service.Check
-		}
-
 		if service.Tags != nil {
 			definition.Tags = service.Tags
 		}
@@ -123,14 +151,340 @@ func (s ServiceDefiner) GenerateDefinitions(config Config) ([]ServiceDefinition,
 	return definitions, nil
 }
 
+// defaultServiceCheck returns the check of a service that does not define
+// one. HTTP and TCP checks are made against the service's port on the node's
+// external IP, so that they work when consul disables scripts.
+func defaultServiceCheck(name string, service ServiceDefinition, node ConfigNode, defaultCheck ConfigConsulAgentDefaultCheck) (*ServiceDefinitionCheck, error) {
+	check := &ServiceDefinitionCheck{
+		Name:     "dns_health_check",
+		Interval: defaultCheck.Interval,
+		Timeout:  defaultCheck.Timeout,
+	}
+
+	switch service.DefaultCheckType {
+	case DefaultCheckTypeHTTP, DefaultCheckTypeTCP:
+		if service.Port <= 0 {
+			return nil, fmt.Errorf("service %s has no port for its %s default check", name, service.DefaultCheckType)
+		}
+
+		address := net.JoinHostPort(node.ExternalIP, strconv.Itoa(service.Port))
+		if service.DefaultCheckType == DefaultCheckTypeHTTP {
+			check.HTTP = fmt.Sprintf("http://%s%s", address, defaultCheck.HTTPPath)
+		} else {
+			check.TCP = address
+		}
+	case DefaultCheckTypeTTL:
+		return &ServiceDefinitionCheck{
+			Name: "dns_health_check",
+			TTL:  defaultCheck.TTL,
+		}, nil
+	default:
+		check.Script = healthCheckCommand(name, service.HealthProbes, defaultCheck.command(name, node), defaultCheck.Timeout)
+	}
+
+	return check, nil
+}
+
+// withDefaults returns the check with the fields that are not set replaced by
+// the defaults of the platform, which run the dns_health_check script of the
+// service's job every 3 seconds, request /health for HTTP checks and give TTL
+// checks 30 seconds to be updated.
+func (c ConfigConsulAgentDefaultCheck) withDefaults() ConfigConsulAgentDefaultCheck {
+	script := "/var/vcap/jobs/{service}/bin/dns_health_check"
+	interpreter := ""
+	if goos == "windows" {
+		script = "/var/vcap/jobs/{service}/bin/dns_health_check.ps1"
+		interpreter = "powershell -Command {script}; Exit $LASTEXITCODE"
+	}
+
+	if c.Script == "" {
+		c.Script = script
+	}
+
+	if c.Interpreter == "" {
+		c.Interpreter = interpreter
+	}
+
+	if c.Interval == "" {
+		c.Interval = "3s"
+	}
+
+	if c.HTTPPath == "" {
+		c.HTTPPath = "/health"
+	}
+
+	if c.TTL == "" {
+		c.TTL = "30s"
+	}
+
+	return c
+}
+
+// command returns the command the check runs for the named service, with the
+// placeholders of Script and Interpreter replaced. The script is appended to
+// an Interpreter that does not refer to {script}.
+func (c ConfigConsulAgentDefaultCheck) command(service string, node ConfigNode) string {
+	placeholders := []string{
+		"{service}", service,
+		"{node_name}", node.Name,
+		"{node_index}", strconv.Itoa(node.Index),
+	}
+
+	script := strings.NewReplacer(placeholders...).Replace(c.Script)
+	if c.Interpreter == "" {
+		return script
+	}
+
+	if !strings.Contains(c.Interpreter, "{script}") {
+		return strings.NewReplacer(placeholders...).Replace(c.Interpreter) + " " + script
+	}
+
+	return strings.NewReplacer(append(placeholders, "{script}", script)...).Replace(c.Interpreter)
+}
+
+// healthCheckCommand returns the script of the default check, which runs the
+// service's probes through "confab healthcheck" with the timeout of the check.
+// Services without probes are checked by running command, which is only
+// limited by the timeout of the check, as it was before it was wrapped.
+func healthCheckCommand(name string, probes []ServiceHealthProbe, command, timeout string) string {
+	confab := "/var/vcap/packages/confab/bin/confab"
+	if goos == "windows" {
+		confab = `C:\var\vcap\packages\confab-windows\bin\confab.exe`
+	}
+
+	args := []string{confab, "healthcheck", "--service", name}
+	switch {
+	case timeout != "":
+		args = append(args, "--probe-timeout", timeout)
+	case len(probes) == 0:
+		args = append(args, "--probe-timeout", "0")
+	}
+
+	if len(probes) == 0 {
+		args = append(args, "--command", command)
+	}
+
+	for _, probe := range probes {
+		switch {
+		case probe.HTTP != "" && probe.ExpectedStatus != 0:
+			args = append(args, "--http", fmt.Sprintf("%d:%s", probe.ExpectedStatus, probe.HTTP))
+		case probe.HTTP != "":
+			args = append(args, "--http", probe.HTTP)
+		case probe.TCP != "":
+			args = append(args, "--tcp", probe.TCP)
+		case probe.PIDFile != "":
+			args = append(args, "--pid-file", probe.PIDFile)
+		case probe.Command != "":
+			args = append(args, "--command", probe.Command)
+		}
+	}
+
+	for i, arg := range args {
+		args[i] = quoteArgument(arg)
+	}
+
+	return strings.Join(args, " ")
+}
+
+// quoteArgument quotes arg for the shell consul runs checks with, /bin/sh or
+// cmd on Windows, unless arg is safe to pass as is.
+func quoteArgument(arg string) string {
+	safeRunes := "-_./:=@%+,"
+	if goos == "windows" {
+		safeRunes += `\`
+	}
+
+	safe := arg != "" && strings.IndexFunc(arg, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+			strings.ContainsRune(safeRunes, r))
+	}) == -1
+	if safe {
+		return arg
+	}
+
+	if goos == "windows" {
+		return `"` + strings.Replace(arg, `"`, `\"`, -1) + `"`
+	}
+
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+// RenderDefinitions returns the contents WriteDefinitions would write for each
+// definition, keyed by file name.
+func (s ServiceDefiner) RenderDefinitions(definitions []ServiceDefinition) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for _, definition := range definitions {
+		name := DefinitionFileName(definition)
+
+		var buffer bytes.Buffer
+		err := json.NewEncoder(&buffer).Encode(map[string]ServiceDefinition{
+			"service": definition,
+		})
+		if err != nil {
+			s.Logger.Error("service-definer.render-definitions.encode.failed", err, lager.Data{
+				"file": name,
+			})
+			return nil, err
+		}
+
+		files[name] = buffer.Bytes()
+	}
+
+	return files, nil
+}
+
+// definitionsManifest names the file in which WriteDefinitions records the
+// definition files it wrote. Consul only loads files ending in .json from its
+// config dir, so it ignores the manifest.
+const definitionsManifest = "service-definitions.manifest"
+
+// RemoveStaleDefinitions removes the definition files in configDir that are
+// not written for definitions, which are those recorded in the manifest and
+// any other service-*.json file. It returns the ids of the services that were
+// defined by the removed files.
+func (s ServiceDefiner) RemoveStaleDefinitions(configDir string, definitions []ServiceDefinition) ([]string, error) {
+	current := map[string]bool{}
+	for _, definition := range definitions {
+		current[DefinitionFileName(definition)] = true
+	}
+
+	manifest := s.readManifest(configDir)
+
+	paths, err := filepath.Glob(filepath.Join(configDir, "service-*.json"))
+	if err != nil {
+		panic(err) // not tested, the pattern is always valid
+	}
+
+	candidates := map[string]bool{}
+	for name := range manifest {
+		candidates[name] = true
+	}
+	for _, path := range paths {
+		candidates[filepath.Base(path)] = true
+	}
+
+	var names []string
+	for name := range candidates {
+		if !current[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var serviceIDs []string
+	for _, name := range names {
+		path := filepath.Join(configDir, name)
+
+		serviceID, ok := manifest[name]
+		if !ok {
+			serviceID = definedServiceID(path)
+		}
+
+		s.Logger.Info("service-definer.remove-stale-definitions.remove", lager.Data{
+			"path":    path,
+			"service": serviceID,
+		})
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			s.Logger.Error("service-definer.remove-stale-definitions.remove.failed", err, lager.Data{
+				"path": path,
+			})
+			return nil, err
+		}
+
+		if serviceID != "" {
+			serviceIDs = append(serviceIDs, serviceID)
+		}
+	}
+
+	return serviceIDs, nil
+}
+
+func (s ServiceDefiner) readManifest(configDir string) map[string]string {
+	manifest := map[string]string{}
+
+	contents, err := ioutil.ReadFile(filepath.Join(configDir, definitionsManifest))
+	if err != nil {
+		return manifest
+	}
+
+	if err := json.Unmarshal(contents, &manifest); err != nil {
+		s.Logger.Error("service-definer.read-manifest.failed", err)
+	}
+
+	return manifest
+}
+
+func (s ServiceDefiner) writeManifest(configDir string, definitions []ServiceDefinition, owner *utils.FileOwner) error {
+	manifest := map[string]string{}
+	for _, definition := range definitions {
+		manifest[DefinitionFileName(definition)] = serviceID(definition)
+	}
+
+	contents, err := json.Marshal(manifest)
+	if err != nil {
+		panic(err) // not tested, the manifest always marshals
+	}
+
+	path := filepath.Join(configDir, definitionsManifest)
+	if _, err := writeFile(path, contents, definitionFileMode, owner); err != nil {
+		s.Logger.Error("service-definer.write-definitions.write-manifest.failed", err, lager.Data{
+			"path": path,
+		})
+		return err
+	}
+
+	return nil
+}
+
+// serviceID returns the id consul registers the service with, which is its
+// name unless it is given an id.
+func serviceID(definition ServiceDefinition) string {
+	if definition.ID != "" {
+		return definition.ID
+	}
+
+	return definition.Name
+}
+
+// definedServiceID returns the id of the service defined by the file at path,
+// or an empty string when the file does not hold a service definition.
+func definedServiceID(path string) string {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	var file struct {
+		Service ServiceDefinition `json:"service"`
+	}
+	if err := json.Unmarshal(contents, &file); err != nil {
+		return ""
+	}
+
+	return serviceID(file.Service)
+}
+
+func DefinitionFileName(definition ServiceDefinition) string {
+	return fmt.Sprintf("service-%s.json", definition.ServiceName)
+}
+
+// WriteDefinitions writes a definition file per definition to configDir and
+// records them in the manifest. Files that already hold their definition are
+// not rewritten.
 func (s ServiceDefiner) WriteDefinitions(configDir string, definitions []ServiceDefinition) error {
+	owner := utils.DirOwner(configDir)
+
 	for _, definition := range definitions {
-		path := filepath.Join(configDir, fmt.Sprintf("service-%s.json", definition.ServiceName))
+		path := filepath.Join(configDir, DefinitionFileName(definition))
 		s.Logger.Info("service-definer.write-definitions.write", lager.Data{
 			"path": path,
 		})
 
-		file, err := createFile(path)
+		var buffer bytes.Buffer
+		err := json.NewEncoder(&buffer).Encode(map[string]ServiceDefinition{
+			"service": definition,
+		})
 		if err != nil {
 			err = errors.New(err.Error())
 			s.Logger.Error("service-definer.write-definitions.write.failed", err, lager.Data{
@@ -139,27 +493,26 @@ func (s ServiceDefiner) WriteDefinitions(configDir string, definitions []Service
 			return err
 		}
 
-		err = json.NewEncoder(file).Encode(map[string]ServiceDefinition{
-			"service": definition,
-		})
+		written, err := writeFile(path, buffer.Bytes(), definitionFileMode, owner)
 		if err != nil {
 			err = errors.New(err.Error())
 			s.Logger.Error("service-definer.write-definitions.write.failed", err, lager.Data{
 				"path": path,
 			})
-			file.Close()
-			return err
-		}
-		if err := syncFile(file); err != nil {
-			file.Close()
 			return err
 		}
 
-		file.Close()
+		if !written {
+			s.Logger.Info("service-definer.write-definitions.write.unchanged", lager.Data{
+				"path": path,
+			})
+			continue
+		}
 
 		s.Logger.Info("service-definer.write-definitions.write.success", lager.Data{
 			"path": path,
 		})
 	}
-	return nil
+
+	return s.writeManifest(configDir, definitions, owner)
 }
@@ -11,6 +11,7 @@ import (
 
 	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
 	"github.com/cloudfoundry-incubator/consul-release/src/confab/fakes"
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -31,8 +32,7 @@ var _ = Describe("ServiceDefiner", func() {
 	})
 
 	AfterEach(func() {
-		config.ResetCreateFile()
-		config.ResetSyncFile()
+		config.ResetWriteFile()
 	})
 
 	Describe("GenerateDefinitions", func() {
@@ -104,7 +104,7 @@ var _ = Describe("ServiceDefiner", func() {
 						Name:        "router",
 						Check: &config.ServiceDefinitionCheck{
 							Name:     "dns_health_check",
-							Script:   "/var/vcap/jobs/router/bin/dns_health_check",
+							Script:   "/var/vcap/packages/confab/bin/confab healthcheck --service router --probe-timeout 0 --command /var/vcap/jobs/router/bin/dns_health_check",
 							Interval: "3s",
 						},
 						Tags: []string{"some-node-0", "z1"},
@@ -135,7 +135,7 @@ var _ = Describe("ServiceDefiner", func() {
 							Name:        "router",
 							Check: &config.ServiceDefinitionCheck{
 								Name:     "dns_health_check",
-								Script:   "/var/vcap/jobs/router/bin/dns_health_check",
+								Script:   "/var/vcap/packages/confab/bin/confab healthcheck --service router --probe-timeout 0 --command /var/vcap/jobs/router/bin/dns_health_check",
 								Interval: "3s",
 							},
 							Tags: []string{"some-node-0", "xn--jedi-nen-i1a27a"},
@@ -175,7 +175,7 @@ var _ = Describe("ServiceDefiner", func() {
 						Name:        "router",
 						Check: &config.ServiceDefinitionCheck{
 							Name:     "dns_health_check",
-							Script:   "powershell -Command /var/vcap/jobs/router/bin/dns_health_check.ps1; Exit $LASTEXITCODE",
+							Script:   `C:\var\vcap\packages\confab-windows\bin\confab.exe healthcheck --service router --probe-timeout 0 --command "powershell -Command /var/vcap/jobs/router/bin/dns_health_check.ps1; Exit $LASTEXITCODE"`,
 							Interval: "3s",
 						},
 						Tags: []string{"some-node-0"},
@@ -184,6 +184,209 @@ var _ = Describe("ServiceDefiner", func() {
 			})
 		})
 
+		Context("when the default check is configured", func() {
+			BeforeEach(func() {
+				config.SetGOOS("linux")
+			})
+
+			AfterEach(func() {
+				config.ResetGOOS()
+			})
+
+			It("generates the check from the configured template", func() {
+				definitions, err := definer.GenerateDefinitions(config.Config{
+					Node: config.ConfigNode{
+						Name:  "some_node",
+						Index: 2,
+					},
+					Consul: config.ConfigConsul{
+						Agent: config.ConfigConsulAgent{
+							Services: map[string]config.ServiceDefinition{
+								"router": {},
+							},
+							DefaultCheck: config.ConfigConsulAgentDefaultCheck{
+								Script:      "/opt/{service}/check-{node_name}-{node_index}",
+								Interpreter: "bash",
+								Interval:    "10s",
+								Timeout:     "5s",
+							},
+						},
+					},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(definitions[0].Check).To(Equal(&config.ServiceDefinitionCheck{
+					Name:     "dns_health_check",
+					Script:   "/var/vcap/packages/confab/bin/confab healthcheck --service router --probe-timeout 5s --command 'bash /opt/router/check-some_node-2'",
+					Interval: "10s",
+					Timeout:  "5s",
+				}))
+			})
+
+			It("substitutes the script into an interpreter that refers to it", func() {
+				definitions, err := definer.GenerateDefinitions(config.Config{
+					Consul: config.ConfigConsul{
+						Agent: config.ConfigConsulAgent{
+							Services: map[string]config.ServiceDefinition{
+								"router": {},
+							},
+							DefaultCheck: config.ConfigConsulAgentDefaultCheck{
+								Interpreter: "timeout 2 {script} --service {service}",
+							},
+						},
+					},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(definitions[0].Check.Script).To(Equal("/var/vcap/packages/confab/bin/confab healthcheck --service router" +
+					" --probe-timeout 0 --command 'timeout 2 /var/vcap/jobs/router/bin/dns_health_check --service router'"))
+				Expect(definitions[0].Check.Interval).To(Equal("3s"))
+			})
+		})
+
+		Context("when the service has a default check type", func() {
+			var cfg config.Config
+
+			BeforeEach(func() {
+				cfg = config.Config{
+					Node: config.ConfigNode{
+						Name:       "some_node",
+						ExternalIP: "10.0.0.5",
+					},
+					Consul: config.ConfigConsul{
+						Agent: config.ConfigConsulAgent{
+							Services: map[string]config.ServiceDefinition{},
+							DefaultCheck: config.ConfigConsulAgentDefaultCheck{
+								Timeout: "1s",
+							},
+						},
+					},
+				}
+			})
+
+			It("checks the service port on the external ip without a script", func() {
+				cfg.Consul.Agent.Services["router"] = config.ServiceDefinition{DefaultCheckType: "http", Port: 8080}
+				cfg.Consul.Agent.Services["database"] = config.ServiceDefinition{DefaultCheckType: "tcp", Port: 5432}
+
+				definitions, err := definer.GenerateDefinitions(cfg)
+				Expect(err).ToNot(HaveOccurred())
+
+				checks := map[string]*config.ServiceDefinitionCheck{}
+				for _, definition := range definitions {
+					Expect(definition.DefaultCheckType).To(BeEmpty())
+					checks[definition.ServiceName] = definition.Check
+				}
+
+				Expect(checks).To(Equal(map[string]*config.ServiceDefinitionCheck{
+					"router": {
+						Name:     "dns_health_check",
+						HTTP:     "http://10.0.0.5:8080/health",
+						Interval: "3s",
+						Timeout:  "1s",
+					},
+					"database": {
+						Name:     "dns_health_check",
+						TCP:      "10.0.0.5:5432",
+						Interval: "3s",
+						Timeout:  "1s",
+					},
+				}))
+			})
+
+			It("requests the configured http path", func() {
+				cfg.Consul.Agent.DefaultCheck.HTTPPath = "/v1/ready"
+				cfg.Consul.Agent.Services["router"] = config.ServiceDefinition{DefaultCheckType: "http", Port: 8080}
+
+				definitions, err := definer.GenerateDefinitions(cfg)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(definitions[0].Check.HTTP).To(Equal("http://10.0.0.5:8080/v1/ready"))
+			})
+
+			It("gives a ttl check the default check ttl", func() {
+				cfg.Consul.Agent.Services["router"] = config.ServiceDefinition{DefaultCheckType: "ttl"}
+
+				definitions, err := definer.GenerateDefinitions(cfg)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(definitions[0].Check).To(Equal(&config.ServiceDefinitionCheck{
+					Name: "dns_health_check",
+					TTL:  "30s",
+				}))
+
+				cfg.Consul.Agent.DefaultCheck.TTL = "1m"
+
+				definitions, err = definer.GenerateDefinitions(cfg)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(definitions[0].Check.TTL).To(Equal("1m"))
+			})
+
+			It("returns an error when the service has no port", func() {
+				cfg.Consul.Agent.Services["router"] = config.ServiceDefinition{DefaultCheckType: "tcp"}
+
+				_, err := definer.GenerateDefinitions(cfg)
+				Expect(err).To(MatchError("service router has no port for its tcp default check"))
+			})
+
+			It("does not need a port when the service defines its own check", func() {
+				cfg.Consul.Agent.Services["router"] = config.ServiceDefinition{
+					DefaultCheckType: "http",
+					Check:            &config.ServiceDefinitionCheck{Name: "router", TTL: "10s"},
+				}
+
+				definitions, err := definer.GenerateDefinitions(cfg)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(definitions[0].Check).To(Equal(&config.ServiceDefinitionCheck{Name: "router", TTL: "10s"}))
+			})
+		})
+
+		Context("when the service has health probes", func() {
+			BeforeEach(func() {
+				config.SetGOOS("linux")
+			})
+
+			AfterEach(func() {
+				config.ResetGOOS()
+			})
+
+			It("runs the probes through confab healthcheck without passing them to consul", func() {
+				definitions, err := definer.GenerateDefinitions(config.Config{
+					Node: config.ConfigNode{
+						Name:  "some_node",
+						Index: 0,
+					},
+					Consul: config.ConfigConsul{
+						Agent: config.ConfigConsulAgent{
+							Services: map[string]config.ServiceDefinition{
+								"router": {
+									HealthProbes: []config.ServiceHealthProbe{
+										{HTTP: "http://127.0.0.1:8080/health"},
+										{HTTP: "http://127.0.0.1:8080/ready", ExpectedStatus: 204},
+										{TCP: "127.0.0.1:80"},
+										{PIDFile: "/var/vcap/sys/run/router/router.pid"},
+										{Command: "test -f /var/vcap/data/router/ready && echo 'ready'"},
+									},
+								},
+							},
+						},
+					},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(definitions).To(HaveLen(1))
+				Expect(definitions[0].HealthProbes).To(BeEmpty())
+				Expect(definitions[0].Check).To(Equal(&config.ServiceDefinitionCheck{
+					Name: "dns_health_check",
+					Script: "/var/vcap/packages/confab/bin/confab healthcheck --service router" +
+						" --http http://127.0.0.1:8080/health" +
+						" --http 204:http://127.0.0.1:8080/ready" +
+						" --tcp 127.0.0.1:80" +
+						" --pid-file /var/vcap/sys/run/router/router.pid" +
+						` --command 'test -f /var/vcap/data/router/ready && echo '\''ready'\'''`,
+					Interval: "3s",
+				}))
+
+				files, err := definer.RenderDefinitions(definitions)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(files["service-router.json"])).NotTo(ContainSubstring("health_probes"))
+			})
+		})
+
 		It("generates a definition with the service name dasherized", func() {
 			definitions, err := definer.GenerateDefinitions(config.Config{
 				Node: config.ConfigNode{
@@ -200,9 +403,9 @@ var _ = Describe("ServiceDefiner", func() {
 			})
 			Expect(err).ToNot(HaveOccurred())
 
-			script := "/var/vcap/jobs/cloud_controller/bin/dns_health_check"
+			script := "/var/vcap/packages/confab/bin/confab healthcheck --service cloud_controller --probe-timeout 0 --command /var/vcap/jobs/cloud_controller/bin/dns_health_check"
 			if Windows {
-				script = "powershell -Command /var/vcap/jobs/cloud_controller/bin/dns_health_check.ps1; Exit $LASTEXITCODE"
+				script = `C:\var\vcap\packages\confab-windows\bin\confab.exe healthcheck --service cloud_controller --probe-timeout 0 --command "powershell -Command /var/vcap/jobs/cloud_controller/bin/dns_health_check.ps1; Exit $LASTEXITCODE"`
 			}
 
 			Expect(definitions).To(ConsistOf([]config.ServiceDefinition{
@@ -276,9 +479,9 @@ var _ = Describe("ServiceDefiner", func() {
 			})
 			Expect(err).ToNot(HaveOccurred())
 
-			script := "/var/vcap/jobs/uaa/bin/dns_health_check"
+			script := "/var/vcap/packages/confab/bin/confab healthcheck --service uaa --probe-timeout 0 --command /var/vcap/jobs/uaa/bin/dns_health_check"
 			if Windows {
-				script = "powershell -Command /var/vcap/jobs/uaa/bin/dns_health_check.ps1; Exit $LASTEXITCODE"
+				script = `C:\var\vcap\packages\confab-windows\bin\confab.exe healthcheck --service uaa --probe-timeout 0 --command "powershell -Command /var/vcap/jobs/uaa/bin/dns_health_check.ps1; Exit $LASTEXITCODE"`
 			}
 
 			Expect(definitions).To(ConsistOf([]config.ServiceDefinition{
@@ -318,9 +521,9 @@ var _ = Describe("ServiceDefiner", func() {
 			})
 			Expect(err).ToNot(HaveOccurred())
 
-			script := "/var/vcap/jobs/cell/bin/dns_health_check"
+			script := "/var/vcap/packages/confab/bin/confab healthcheck --service cell --probe-timeout 0 --command /var/vcap/jobs/cell/bin/dns_health_check"
 			if Windows {
-				script = "powershell -Command /var/vcap/jobs/cell/bin/dns_health_check.ps1; Exit $LASTEXITCODE"
+				script = `C:\var\vcap\packages\confab-windows\bin\confab.exe healthcheck --service cell --probe-timeout 0 --command "powershell -Command /var/vcap/jobs/cell/bin/dns_health_check.ps1; Exit $LASTEXITCODE"`
 			}
 
 			Expect(definitions).To(ConsistOf([]config.ServiceDefinition{
@@ -355,9 +558,9 @@ var _ = Describe("ServiceDefiner", func() {
 			})
 			Expect(err).ToNot(HaveOccurred())
 
-			script := "/var/vcap/jobs/dea/bin/dns_health_check"
+			script := "/var/vcap/packages/confab/bin/confab healthcheck --service dea --probe-timeout 0 --command /var/vcap/jobs/dea/bin/dns_health_check"
 			if Windows {
-				script = "powershell -Command /var/vcap/jobs/dea/bin/dns_health_check.ps1; Exit $LASTEXITCODE"
+				script = `C:\var\vcap\packages\confab-windows\bin\confab.exe healthcheck --service dea --probe-timeout 0 --command "powershell -Command /var/vcap/jobs/dea/bin/dns_health_check.ps1; Exit $LASTEXITCODE"`
 			}
 
 			Expect(definitions).To(ConsistOf([]config.ServiceDefinition{
@@ -392,9 +595,9 @@ var _ = Describe("ServiceDefiner", func() {
 			})
 			Expect(err).ToNot(HaveOccurred())
 
-			script := "/var/vcap/jobs/dea/bin/dns_health_check"
+			script := "/var/vcap/packages/confab/bin/confab healthcheck --service dea --probe-timeout 0 --command /var/vcap/jobs/dea/bin/dns_health_check"
 			if Windows {
-				script = "powershell -Command /var/vcap/jobs/dea/bin/dns_health_check.ps1; Exit $LASTEXITCODE"
+				script = `C:\var\vcap\packages\confab-windows\bin\confab.exe healthcheck --service dea --probe-timeout 0 --command "powershell -Command /var/vcap/jobs/dea/bin/dns_health_check.ps1; Exit $LASTEXITCODE"`
 			}
 
 			Expect(definitions).To(ConsistOf([]config.ServiceDefinition{
@@ -430,9 +633,9 @@ var _ = Describe("ServiceDefiner", func() {
 			})
 			Expect(err).ToNot(HaveOccurred())
 
-			script := "/var/vcap/jobs/router/bin/dns_health_check"
+			script := "/var/vcap/packages/confab/bin/confab healthcheck --service router --probe-timeout 0 --command /var/vcap/jobs/router/bin/dns_health_check"
 			if Windows {
-				script = "powershell -Command /var/vcap/jobs/router/bin/dns_health_check.ps1; Exit $LASTEXITCODE"
+				script = `C:\var\vcap\packages\confab-windows\bin\confab.exe healthcheck --service router --probe-timeout 0 --command "powershell -Command /var/vcap/jobs/router/bin/dns_health_check.ps1; Exit $LASTEXITCODE"`
 			}
 
 			Expect(definitions).To(ConsistOf([]config.ServiceDefinition{
@@ -468,9 +671,9 @@ var _ = Describe("ServiceDefiner", func() {
 			})
 			Expect(err).ToNot(HaveOccurred())
 
-			script := "/var/vcap/jobs/router/bin/dns_health_check"
+			script := "/var/vcap/packages/confab/bin/confab healthcheck --service router --probe-timeout 0 --command /var/vcap/jobs/router/bin/dns_health_check"
 			if Windows {
-				script = "powershell -Command /var/vcap/jobs/router/bin/dns_health_check.ps1; Exit $LASTEXITCODE"
+				script = `C:\var\vcap\packages\confab-windows\bin\confab.exe healthcheck --service router --probe-timeout 0 --command "powershell -Command /var/vcap/jobs/router/bin/dns_health_check.ps1; Exit $LASTEXITCODE"`
 			}
 
 			Expect(definitions).To(ConsistOf([]config.ServiceDefinition{
@@ -506,9 +709,9 @@ var _ = Describe("ServiceDefiner", func() {
 			})
 			Expect(err).ToNot(HaveOccurred())
 
-			script := "/var/vcap/jobs/router/bin/dns_health_check"
+			script := "/var/vcap/packages/confab/bin/confab healthcheck --service router --probe-timeout 0 --command /var/vcap/jobs/router/bin/dns_health_check"
 			if Windows {
-				script = "powershell -Command /var/vcap/jobs/router/bin/dns_health_check.ps1; Exit $LASTEXITCODE"
+				script = `C:\var\vcap\packages\confab-windows\bin\confab.exe healthcheck --service router --probe-timeout 0 --command "powershell -Command /var/vcap/jobs/router/bin/dns_health_check.ps1; Exit $LASTEXITCODE"`
 			}
 
 			Expect(definitions).To(ConsistOf([]config.ServiceDefinition{
@@ -526,6 +729,28 @@ var _ = Describe("ServiceDefiner", func() {
 			}))
 		})
 
+		It("uses the id of a declared acl token named by the Token field", func() {
+			definitions, err := definer.GenerateDefinitions(config.Config{
+				Consul: config.ConfigConsul{
+					Agent: config.ConfigConsulAgent{
+						Services: map[string]config.ServiceDefinition{
+							"router": {
+								Token: "router",
+							},
+						},
+					},
+					ACL: config.ConfigConsulACL{
+						Tokens: map[string]config.ConfigConsulACLToken{
+							"router": {ID: "router-token-id"},
+						},
+					},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(definitions).To(HaveLen(1))
+			Expect(definitions[0].Token).To(Equal("router-token-id"))
+		})
+
 		It("generates definitions with the Token field specified", func() {
 			definitions, err := definer.GenerateDefinitions(config.Config{
 				Node: config.ConfigNode{
@@ -544,9 +769,9 @@ var _ = Describe("ServiceDefiner", func() {
 			})
 			Expect(err).ToNot(HaveOccurred())
 
-			script := "/var/vcap/jobs/router/bin/dns_health_check"
+			script := "/var/vcap/packages/confab/bin/confab healthcheck --service router --probe-timeout 0 --command /var/vcap/jobs/router/bin/dns_health_check"
 			if Windows {
-				script = "powershell -Command /var/vcap/jobs/router/bin/dns_health_check.ps1; Exit $LASTEXITCODE"
+				script = `C:\var\vcap\packages\confab-windows\bin\confab.exe healthcheck --service router --probe-timeout 0 --command "powershell -Command /var/vcap/jobs/router/bin/dns_health_check.ps1; Exit $LASTEXITCODE"`
 			}
 
 			Expect(definitions).To(ConsistOf([]config.ServiceDefinition{
@@ -620,6 +845,37 @@ var _ = Describe("ServiceDefiner", func() {
 		})
 	})
 
+	Describe("RenderDefinitions", func() {
+		It("renders a definition file per service without writing it", func() {
+			files, err := definer.RenderDefinitions([]config.ServiceDefinition{
+				{
+					ServiceName: "cloud_controller",
+					Name:        "cloud-controller",
+				},
+				{
+					ServiceName: "api",
+					Name:        "api",
+					Tags:        []string{"node-0"},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(HaveLen(2))
+
+			Expect(string(files["service-cloud_controller.json"])).To(MatchJSON(`{
+				"service": {
+					"name": "cloud-controller"
+				}
+			}`))
+
+			Expect(string(files["service-api.json"])).To(MatchJSON(`{
+				"service": {
+					"name": "api",
+					"tags": ["node-0"]
+				}
+			}`))
+		})
+	})
+
 	Describe("WriteDefinitions", func() {
 		var tempDir string
 		BeforeEach(func() {
@@ -806,12 +1062,13 @@ var _ = Describe("ServiceDefiner", func() {
 			}`))
 		})
 
-		It("syncs the file", func() {
-			syncFileCallCount := 0
-			config.SetSyncFile(func(*os.File) error {
-				syncFileCallCount++
-				return nil
+		It("writes the files atomically without letting other users read them", func() {
+			var modes []os.FileMode
+			config.SetWriteFile(func(path string, contents []byte, mode os.FileMode, owner *utils.FileOwner) (bool, error) {
+				modes = append(modes, mode)
+				return utils.WriteFileAtomically(path, contents, mode, owner)
 			})
+
 			err := definer.WriteDefinitions(tempDir, []config.ServiceDefinition{
 				{
 					ServiceName: "some-service",
@@ -819,19 +1076,30 @@ var _ = Describe("ServiceDefiner", func() {
 			})
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(syncFileCallCount).To(Equal(1))
+			Expect(modes).To(Equal([]os.FileMode{0640, 0640}))
+			Expect(filepath.Join(tempDir, "service-definitions.manifest")).To(BeAnExistingFile())
+		})
+
+		It("does not rewrite definitions that have not changed", func() {
+			definitions := []config.ServiceDefinition{
+				{
+					ServiceName: "some-service",
+				},
+			}
+			Expect(definer.WriteDefinitions(tempDir, definitions)).To(Succeed())
+			Expect(definer.WriteDefinitions(tempDir, definitions)).To(Succeed())
+
+			Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+				Action: "service-definer.write-definitions.write.unchanged",
+				Data: []lager.Data{{
+					"path": filepath.Join(tempDir, "service-some-service.json"),
+				}},
+			}))
 		})
 
 		Context("failure cases", func() {
-			It("errors when the file cannot be created", func() {
+			It("errors when the config dir does not exist", func() {
 				const RandomPath = "/some/random/path"
-				var errFmt string
-				if Windows {
-					errFmt = "open %s: The system cannot find the path specified."
-				} else {
-					errFmt = "open %s: no such file or directory"
-				}
-				errMsg := fmt.Errorf(errFmt, filepath.Join(RandomPath, "service-cloud_controller.json"))
 
 				err := definer.WriteDefinitions(RandomPath, []config.ServiceDefinition{
 					{
@@ -853,68 +1121,119 @@ var _ = Describe("ServiceDefiner", func() {
 					},
 					{
 						Action: "service-definer.write-definitions.write.failed",
-						Error:  errMsg,
+						Error:  err,
 						Data: []lager.Data{{
 							"path": filepath.FromSlash("/some/random/path/service-cloud_controller.json"),
 						}},
 					},
 				}))
-
 			})
 
-			It("errors when the file cannot be written to", func() {
-				config.SetCreateFile(func(path string) (*os.File, error) {
-					file, err := os.Create(path)
-					if err != nil {
-						return nil, err
-					}
-
-					err = file.Close()
-					if err != nil {
-						return nil, err
-					}
-
-					return file, nil
-
+			It("errors when the file cannot be written", func() {
+				config.SetWriteFile(func(string, []byte, os.FileMode, *utils.FileOwner) (bool, error) {
+					return false, errors.New("something bad happened")
 				})
 
 				err := definer.WriteDefinitions(tempDir, []config.ServiceDefinition{
 					{
-						ServiceName: "cloud_controller",
+						ServiceName: "some-service",
 					},
 				})
+				Expect(err).To(MatchError("something bad happened"))
+				Expect(logger.Messages()).To(ContainElement(fakes.LoggerMessage{
+					Action: "service-definer.write-definitions.write.failed",
+					Error:  errors.New("something bad happened"),
+					Data: []lager.Data{{
+						"path": filepath.Join(tempDir, "service-some-service.json"),
+					}},
+				}))
+			})
+		})
+	})
 
-				Expect(err).To(MatchError(ContainSubstring("file already closed")))
+	Describe("RemoveStaleDefinitions", func() {
+		var tempDir string
 
-				errPath := filepath.Join(tempDir, "service-cloud_controller.json")
-				Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
-					{
-						Action: "service-definer.write-definitions.write",
-						Data: []lager.Data{{
-							"path": errPath,
-						}},
-					},
-					{
-						Action: "service-definer.write-definitions.write.failed",
-						Error:  fmt.Errorf("write %s: %s", errPath, "file already closed"),
-						Data: []lager.Data{{
-							"path": errPath,
-						}},
-					},
-				}))
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "conf-dir")
+			Expect(err).NotTo(HaveOccurred())
+
+			err = definer.WriteDefinitions(tempDir, []config.ServiceDefinition{
+				{
+					ServiceName: "cloud_controller",
+					Name:        "cloud-controller",
+				},
+				{
+					ServiceName: "router",
+					Name:        "gorouter",
+					ID:          "router-id",
+				},
 			})
+			Expect(err).NotTo(HaveOccurred())
+		})
 
-			It("returns an error when it fails to sync the file", func() {
-				config.SetSyncFile(func(*os.File) error {
-					return errors.New("something bad happened")
-				})
-				err := definer.WriteDefinitions(tempDir, []config.ServiceDefinition{
-					{
-						ServiceName: "some-service",
-					},
-				})
-				Expect(err).To(MatchError("something bad happened"))
+		AfterEach(func() {
+			Expect(os.RemoveAll(tempDir)).To(Succeed())
+		})
+
+		It("removes the definitions that are no longer generated and returns their service ids", func() {
+			err := ioutil.WriteFile(filepath.Join(tempDir, "service-old.json"), []byte(`{"service":{"name":"old"}}`), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = ioutil.WriteFile(filepath.Join(tempDir, "config.json"), []byte("{}"), 0644)
+			Expect(err).NotTo(HaveOccurred())
+
+			serviceIDs, err := definer.RemoveStaleDefinitions(tempDir, []config.ServiceDefinition{
+				{
+					ServiceName: "cloud_controller",
+					Name:        "cloud-controller",
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(serviceIDs).To(Equal([]string{"old", "router-id"}))
+
+			Expect(filepath.Join(tempDir, "service-cloud_controller.json")).To(BeAnExistingFile())
+			Expect(filepath.Join(tempDir, "config.json")).To(BeAnExistingFile())
+			Expect(filepath.Join(tempDir, "service-old.json")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(tempDir, "service-router.json")).NotTo(BeAnExistingFile())
+
+			Expect(logger.Messages()).To(ContainSequence([]fakes.LoggerMessage{
+				{
+					Action: "service-definer.remove-stale-definitions.remove",
+					Data: []lager.Data{{
+						"path":    filepath.Join(tempDir, "service-old.json"),
+						"service": "old",
+					}},
+				},
+				{
+					Action: "service-definer.remove-stale-definitions.remove",
+					Data: []lager.Data{{
+						"path":    filepath.Join(tempDir, "service-router.json"),
+						"service": "router-id",
+					}},
+				},
+			}))
+		})
+
+		It("returns the service ids of manifest entries whose files are already gone", func() {
+			Expect(os.Remove(filepath.Join(tempDir, "service-router.json"))).To(Succeed())
+
+			serviceIDs, err := definer.RemoveStaleDefinitions(tempDir, []config.ServiceDefinition{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(serviceIDs).To(Equal([]string{"cloud-controller", "router-id"}))
+		})
+
+		It("does not remove anything when every definition is still generated", func() {
+			serviceIDs, err := definer.RemoveStaleDefinitions(tempDir, []config.ServiceDefinition{
+				{ServiceName: "router"},
+				{ServiceName: "cloud_controller"},
 			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(serviceIDs).To(BeEmpty())
+
+			Expect(filepath.Join(tempDir, "service-cloud_controller.json")).To(BeAnExistingFile())
+			Expect(filepath.Join(tempDir, "service-router.json")).To(BeAnExistingFile())
 		})
 	})
 })
@@ -0,0 +1,354 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var dnsLabelRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+var placeholderRegexp = regexp.MustCompile(`\{[a-z_]*\}`)
+
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	var messages []string
+	for _, validationError := range e {
+		messages = append(messages, validationError.Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+func (e *ValidationErrors) add(path string, format string, args ...interface{}) {
+	*e = append(*e, ValidationError{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Validate returns the errors of every setting and of the certificates in
+// the consul config dir.
+func Validate(config Config) error {
+	var errs ValidationErrors
+
+	agent := config.Consul.Agent
+
+	if agent.Mode != "client" && agent.Mode != "server" {
+		errs.add("consul.agent.mode", "must be %q or %q, got %q", "client", "server", agent.Mode)
+	}
+
+	if len(agent.Servers.LAN) == 0 {
+		errs.add("consul.agent.servers.lan", "must not be empty")
+	}
+
+	validateAgent(&errs, agent)
+
+	if agent.Mode == "server" && len(config.Consul.EncryptKeys) == 0 {
+		errs.add("consul.encrypt_keys", "must not be empty for servers")
+	}
+
+	for i, key := range config.Consul.EncryptKeys {
+		if key == "" {
+			errs.add(fmt.Sprintf("consul.encrypt_keys[%d]", i), "must not be empty")
+		}
+	}
+
+	switch config.Confab.ServerStartStrategy {
+	case ServerStartStrategyBootstrap, ServerStartStrategyBootstrapExpect:
+	default:
+		errs.add("confab.server_start_strategy", "must be %q or %q, got %q",
+			ServerStartStrategyBootstrap, ServerStartStrategyBootstrapExpect, config.Confab.ServerStartStrategy)
+	}
+
+	switch config.Confab.JoinPolicy {
+	case JoinPolicyOne, JoinPolicyMajority, JoinPolicyAll:
+	default:
+		errs.add("confab.join_policy", "must be %q, %q or %q, got %q",
+			JoinPolicyOne, JoinPolicyMajority, JoinPolicyAll, config.Confab.JoinPolicy)
+	}
+
+	if config.Confab.MinJoinedServers < 1 {
+		errs.add("confab.min_joined_servers", "must be at least 1, got %d", config.Confab.MinJoinedServers)
+	}
+
+	if config.Confab.KeyRotationSettleInSeconds < 0 {
+		errs.add("confab.key_rotation_settle_in_seconds", "must not be negative, got %d", config.Confab.KeyRotationSettleInSeconds)
+	}
+
+	validateACL(&errs, config.Consul.ACL)
+
+	validateCerts(&errs, config)
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// ValidateAgent returns the errors of the consul.agent settings that are
+// rendered into the consul configuration and service definitions. Start and
+// reload check them before writing any configuration.
+func ValidateAgent(config Config) error {
+	var errs ValidationErrors
+
+	validateAgent(&errs, config.Consul.Agent)
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func validateAgent(errs *ValidationErrors, agent ConfigConsulAgent) {
+	validateDuration(errs, "consul.agent.dns_config.max_stale", agent.DnsConfig.MaxStale)
+	validateDuration(errs, "consul.agent.dns_config.recursor_timeout", agent.DnsConfig.RecursorTimeout)
+	validateDuration(errs, "consul.agent.dns_config.service_ttl", agent.DnsConfig.ServiceTTL)
+
+	if agent.Ports.DNS < -1 || agent.Ports.DNS > 65535 {
+		errs.add("consul.agent.ports.dns", "must be between 1 and 65535, 0 for the default port 53, or -1 to disable dns, got %d", agent.Ports.DNS)
+	}
+
+	if agent.RaftProtocol < 0 || agent.RaftProtocol > 3 {
+		errs.add("consul.agent.raft_protocol", "must be between 1 and 3, got %d", agent.RaftProtocol)
+	}
+
+	validateDefaultCheck(errs, "consul.agent.default_check", agent.DefaultCheck)
+
+	// the packaged consul 0.7.4 always runs script checks and refuses to start
+	// with enable_script_checks or enable_local_script_checks
+	switch agent.ScriptChecks {
+	case "":
+	case ScriptChecksLocal, ScriptChecksAll:
+		errs.add("consul.agent.script_checks", "is not supported by the packaged consul 0.7.4, which always runs script checks")
+	default:
+		errs.add("consul.agent.script_checks", "must be %q or %q, got %q", ScriptChecksLocal, ScriptChecksAll, agent.ScriptChecks)
+	}
+
+	var serviceNames []string
+	for name := range agent.Services {
+		serviceNames = append(serviceNames, name)
+	}
+	sort.Strings(serviceNames)
+
+	for _, name := range serviceNames {
+		validateService(errs, fmt.Sprintf("consul.agent.services.%s", name), name, agent.Services[name])
+	}
+}
+
+func validateACL(errs *ValidationErrors, acl ConfigConsulACL) {
+	switch acl.DefaultPolicy {
+	case "", "allow", "deny":
+	default:
+		errs.add("consul.acl.default_policy", "must be %q or %q, got %q", "allow", "deny", acl.DefaultPolicy)
+	}
+
+	switch acl.DownPolicy {
+	case "", "allow", "deny", "extend-cache":
+	default:
+		errs.add("consul.acl.down_policy", "must be %q, %q or %q, got %q", "allow", "deny", "extend-cache", acl.DownPolicy)
+	}
+
+	if len(acl.Tokens) > 0 && acl.MasterToken == "" {
+		errs.add("consul.acl.master_token", "must not be empty when tokens are declared")
+	}
+
+	var names []string
+	for name := range acl.Tokens {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		token := acl.Tokens[name]
+		path := fmt.Sprintf("consul.acl.tokens.%s", name)
+
+		if token.ID == "" {
+			errs.add(path+".id", "must not be empty")
+		}
+
+		switch token.Type {
+		case "", "client", "management":
+		default:
+			errs.add(path+".type", "must be %q or %q, got %q", "client", "management", token.Type)
+		}
+
+		if _, ok := acl.Policies[token.Policy]; token.Policy != "" && !ok {
+			errs.add(path+".policy", "must be one of consul.acl.policies, got %q", token.Policy)
+		}
+	}
+}
+
+func validateDuration(errs *ValidationErrors, path, value string) {
+	if value == "" {
+		return
+	}
+
+	if _, err := time.ParseDuration(value); err != nil {
+		errs.add(path, "must be a valid duration, got %q", value)
+	}
+}
+
+func validateService(errs *ValidationErrors, path, key string, service ServiceDefinition) {
+	name := strings.Replace(key, "_", "-", -1)
+	namePath := path
+	if service.Name != "" {
+		name = service.Name
+		namePath = path + ".name"
+	}
+
+	if !dnsLabelRegexp.MatchString(name) {
+		errs.add(namePath, "must be a valid DNS label, got %q", name)
+	}
+
+	switch service.DefaultCheckType {
+	case "", DefaultCheckTypeScript, DefaultCheckTypeTTL:
+	case DefaultCheckTypeHTTP, DefaultCheckTypeTCP:
+		if service.Port <= 0 && service.Check == nil {
+			errs.add(path+".port", "must be set for a %s default check", service.DefaultCheckType)
+		}
+	case "grpc":
+		errs.add(path+".default_check_type", "grpc is not supported by the packaged consul 0.7.4")
+	default:
+		errs.add(path+".default_check_type", "must be %q, %q, %q or %q, got %q",
+			DefaultCheckTypeScript, DefaultCheckTypeHTTP, DefaultCheckTypeTCP, DefaultCheckTypeTTL,
+			service.DefaultCheckType)
+	}
+
+	if service.Check != nil {
+		validateCheck(errs, path+".check", *service.Check)
+	}
+
+	for i, check := range service.Checks {
+		validateCheck(errs, fmt.Sprintf("%s.checks[%d]", path, i), check)
+	}
+}
+
+func validateCheck(errs *ValidationErrors, path string, check ServiceDefinitionCheck) {
+	validateDuration(errs, path+".interval", check.Interval)
+	validateDuration(errs, path+".timeout", check.Timeout)
+	validateDuration(errs, path+".ttl", check.TTL)
+}
+
+func validateDefaultCheck(errs *ValidationErrors, path string, check ConfigConsulAgentDefaultCheck) {
+	validatePlaceholders(errs, path+".script", check.Script, "{service}", "{node_name}", "{node_index}")
+	validatePlaceholders(errs, path+".interpreter", check.Interpreter, "{service}", "{node_name}", "{node_index}", "{script}")
+	validateDuration(errs, path+".interval", check.Interval)
+	validateDuration(errs, path+".timeout", check.Timeout)
+	validateDuration(errs, path+".ttl", check.TTL)
+
+	if check.HTTPPath != "" && !strings.HasPrefix(check.HTTPPath, "/") {
+		errs.add(path+".http_path", "must start with /, got %q", check.HTTPPath)
+	}
+}
+
+func validatePlaceholders(errs *ValidationErrors, path, value string, placeholders ...string) {
+	for _, placeholder := range placeholderRegexp.FindAllString(value, -1) {
+		known := false
+		for _, p := range placeholders {
+			if placeholder == p {
+				known = true
+			}
+		}
+
+		if !known {
+			errs.add(path, "must only use the placeholders %s, got %q", strings.Join(placeholders, ", "), placeholder)
+		}
+	}
+}
+
+// validateCerts checks the certificates rendered from the consul.ca_cert and
+// consul.agent_cert or consul.server_cert properties, which consul only loads
+// when require_ssl is set.
+func validateCerts(errs *ValidationErrors, config Config) {
+	if !config.Consul.Agent.RequireSSL {
+		return
+	}
+
+	certsDir := filepath.Join(config.Path.ConsulConfigDir, "certs")
+
+	certName, keyName := "agent.crt", "agent.key"
+	certProperty, keyProperty := "consul.agent_cert", "consul.agent_key"
+	if config.Consul.Agent.Mode == "server" {
+		certName, keyName = "server.crt", "server.key"
+		certProperty, keyProperty = "consul.server_cert", "consul.server_key"
+	}
+
+	caPool, err := loadCertPool(filepath.Join(certsDir, "ca.crt"))
+	if err != nil {
+		errs.add("consul.ca_cert", "%s", err)
+	}
+
+	keyPair, err := tls.LoadX509KeyPair(filepath.Join(certsDir, certName), filepath.Join(certsDir, keyName))
+	if err != nil {
+		errs.add(certProperty, "could not load key pair with %s: %s", keyProperty, err)
+		return
+	}
+
+	if caPool == nil {
+		return
+	}
+
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		errs.add(certProperty, "could not parse certificate: %s", err)
+		return
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     caPool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		errs.add(certProperty, "is not signed by consul.ca_cert: %s", err)
+	}
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	contents, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	count := 0
+	for {
+		var block *pem.Block
+		block, contents = pem.Decode(contents)
+		if block == nil {
+			break
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse certificate: %s", err)
+		}
+		pool.AddCert(cert)
+		count++
+	}
+
+	if count == 0 {
+		return nil, errors.New("does not contain a PEM-encoded certificate")
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,351 @@
+package config_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validate", func() {
+	var (
+		configDir string
+		certsDir  string
+		cfg       config.Config
+	)
+
+	BeforeEach(func() {
+		var err error
+		configDir, err = ioutil.TempDir("", "config")
+		Expect(err).NotTo(HaveOccurred())
+
+		certsDir = filepath.Join(configDir, "certs")
+		Expect(os.Mkdir(certsDir, os.ModePerm)).To(Succeed())
+
+		caCert, caKey := generateCertificate(nil, nil)
+		writePEM(filepath.Join(certsDir, "ca.crt"), "CERTIFICATE", caCert.Raw)
+
+		for _, name := range []string{"server", "agent"} {
+			cert, key := generateCertificate(caCert, caKey)
+			writePEM(filepath.Join(certsDir, name+".crt"), "CERTIFICATE", cert.Raw)
+			writePEM(filepath.Join(certsDir, name+".key"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+		}
+
+		cfg, err = config.ConfigFromJSON([]byte(`{
+			"path": {
+				"consul_config_dir": "`+filepath.ToSlash(configDir)+`"
+			},
+			"consul": {
+				"agent": {
+					"mode": "server",
+					"require_ssl": true,
+					"servers": {
+						"lan": ["server1", "server2", "server3"]
+					},
+					"services": {
+						"cloud_controller": {
+							"checks": [{
+								"name": "do_something",
+								"script": "/var/vcap/jobs/cloudcontroller/bin/do_something",
+								"interval": "5m"
+							}]
+						},
+						"router": {
+							"name": "gorouter"
+						}
+					}
+				},
+				"encrypt_keys": ["key-1"]
+			}
+		}`), []byte("{}"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(configDir)).To(Succeed())
+	})
+
+	It("accepts a valid configuration", func() {
+		Expect(config.Validate(cfg)).To(Succeed())
+	})
+
+	It("accepts a valid client configuration", func() {
+		cfg.Consul.Agent.Mode = "client"
+		cfg.Consul.EncryptKeys = nil
+
+		Expect(config.Validate(cfg)).To(Succeed())
+	})
+
+	It("reports every problem with the path of the bad field", func() {
+		cfg.Consul.Agent.Mode = "banana"
+		cfg.Consul.Agent.Servers.LAN = []string{}
+		cfg.Consul.Agent.DnsConfig.MaxStale = "30 seconds"
+		cfg.Consul.Agent.DnsConfig.RecursorTimeout = "5"
+		cfg.Consul.Agent.Ports.DNS = 65536
+		cfg.Consul.Agent.Services["router"] = config.ServiceDefinition{
+			Name: "go_router",
+		}
+		cfg.Consul.Agent.Services["cloud_controller"] = config.ServiceDefinition{
+			Checks: []config.ServiceDefinitionCheck{{
+				Name:     "do_something",
+				Interval: "often",
+			}},
+		}
+		cfg.Consul.EncryptKeys = []string{""}
+
+		err := config.Validate(cfg)
+		Expect(err).To(BeAssignableToTypeOf(config.ValidationErrors{}))
+		Expect(err.(config.ValidationErrors)).To(ConsistOf(
+			config.ValidationError{
+				Path:    "consul.agent.mode",
+				Message: `must be "client" or "server", got "banana"`,
+			},
+			config.ValidationError{
+				Path:    "consul.agent.servers.lan",
+				Message: "must not be empty",
+			},
+			config.ValidationError{
+				Path:    "consul.agent.dns_config.max_stale",
+				Message: `must be a valid duration, got "30 seconds"`,
+			},
+			config.ValidationError{
+				Path:    "consul.agent.dns_config.recursor_timeout",
+				Message: `must be a valid duration, got "5"`,
+			},
+			config.ValidationError{
+				Path:    "consul.agent.ports.dns",
+				Message: "must be between 1 and 65535, 0 for the default port 53, or -1 to disable dns, got 65536",
+			},
+			config.ValidationError{
+				Path:    "consul.agent.services.cloud_controller.checks[0].interval",
+				Message: `must be a valid duration, got "often"`,
+			},
+			config.ValidationError{
+				Path:    "consul.agent.services.router.name",
+				Message: `must be a valid DNS label, got "go_router"`,
+			},
+			config.ValidationError{
+				Path:    "consul.encrypt_keys[0]",
+				Message: "must not be empty",
+			},
+		))
+		Expect(err).To(MatchError(ContainSubstring("consul.agent.mode: must be \"client\" or \"server\", got \"banana\"\n")))
+	})
+
+	It("requires encrypt keys for servers", func() {
+		cfg.Consul.EncryptKeys = []string{}
+
+		Expect(config.Validate(cfg)).To(MatchError("consul.encrypt_keys: must not be empty for servers"))
+	})
+
+	It("requires a known server start strategy", func() {
+		cfg.Confab.ServerStartStrategy = "banana"
+
+		Expect(config.Validate(cfg)).To(MatchError(`confab.server_start_strategy: must be "bootstrap" or "bootstrap_expect", got "banana"`))
+
+		cfg.Confab.ServerStartStrategy = "bootstrap_expect"
+		Expect(config.Validate(cfg)).To(Succeed())
+	})
+
+	It("requires a known raft protocol", func() {
+		cfg.Consul.Agent.RaftProtocol = 4
+
+		Expect(config.Validate(cfg)).To(MatchError("consul.agent.raft_protocol: must be between 1 and 3, got 4"))
+
+		cfg.Consul.Agent.RaftProtocol = 2
+		Expect(config.Validate(cfg)).To(Succeed())
+	})
+
+	It("validates the default check", func() {
+		cfg.Consul.Agent.DefaultCheck = config.ConfigConsulAgentDefaultCheck{
+			Script:      "/var/vcap/jobs/{job}/bin/{service}_check",
+			Interpreter: "bash -c {script} {service}",
+			Interval:    "3",
+			TTL:         "soon",
+			HTTPPath:    "health",
+		}
+
+		Expect(config.Validate(cfg)).To(MatchError(`consul.agent.default_check.script: must only use the placeholders {service}, {node_name}, {node_index}, got "{job}"
+consul.agent.default_check.interval: must be a valid duration, got "3"
+consul.agent.default_check.ttl: must be a valid duration, got "soon"
+consul.agent.default_check.http_path: must start with /, got "health"`))
+	})
+
+	It("validates the default check types of services", func() {
+		cfg.Consul.Agent.ScriptChecks = "banana"
+		cfg.Consul.Agent.Services["router"] = config.ServiceDefinition{
+			Name:             "gorouter",
+			DefaultCheckType: "http",
+		}
+		cfg.Consul.Agent.Services["uaa"] = config.ServiceDefinition{
+			DefaultCheckType: "banana",
+		}
+		cfg.Consul.Agent.Services["locket"] = config.ServiceDefinition{
+			DefaultCheckType: "grpc",
+			Port:             8891,
+		}
+
+		Expect(config.Validate(cfg)).To(MatchError(`consul.agent.script_checks: must be "local" or "all", got "banana"
+consul.agent.services.locket.default_check_type: grpc is not supported by the packaged consul 0.7.4
+consul.agent.services.router.port: must be set for a http default check
+consul.agent.services.uaa.default_check_type: must be "script", "http", "tcp" or "ttl", got "banana"`))
+	})
+
+	It("rejects enabling script checks, which the packaged consul does not know", func() {
+		for _, scriptChecks := range []string{"local", "all"} {
+			cfg.Consul.Agent.ScriptChecks = scriptChecks
+			Expect(config.Validate(cfg)).To(MatchError("consul.agent.script_checks: is not supported by the packaged consul 0.7.4, which always runs script checks"))
+		}
+	})
+
+	It("validates the acl settings", func() {
+		cfg.Consul.ACL = config.ConfigConsulACL{
+			Datacenter:    "dc1",
+			DefaultPolicy: "banana",
+			Policies: map[string]string{
+				"router": `service "gorouter" { policy = "write" }`,
+			},
+			Tokens: map[string]config.ConfigConsulACLToken{
+				"router": {ID: "router-token", Policy: "router"},
+				"uaa":    {Type: "banana", Policy: "uaa"},
+			},
+		}
+
+		Expect(config.Validate(cfg)).To(MatchError(`consul.acl.default_policy: must be "allow" or "deny", got "banana"
+consul.acl.master_token: must not be empty when tokens are declared
+consul.acl.tokens.uaa.id: must not be empty
+consul.acl.tokens.uaa.type: must be "client" or "management", got "banana"
+consul.acl.tokens.uaa.policy: must be one of consul.acl.policies, got "uaa"`))
+	})
+
+	It("requires a known join policy", func() {
+		cfg.Confab.JoinPolicy = "banana"
+
+		Expect(config.Validate(cfg)).To(MatchError(`confab.join_policy: must be "one", "majority" or "all", got "banana"`))
+
+		cfg.Confab.JoinPolicy = "majority"
+		Expect(config.Validate(cfg)).To(Succeed())
+	})
+
+	It("requires at least one joined server", func() {
+		cfg.Confab.MinJoinedServers = 0
+
+		Expect(config.Validate(cfg)).To(MatchError("confab.min_joined_servers: must be at least 1, got 0"))
+	})
+
+	It("requires a non-negative key rotation settle time", func() {
+		cfg.Confab.KeyRotationSettleInSeconds = -1
+
+		Expect(config.Validate(cfg)).To(MatchError("confab.key_rotation_settle_in_seconds: must not be negative, got -1"))
+	})
+
+	It("validates the agent settings without the rest of the configuration", func() {
+		cfg.Consul.EncryptKeys = []string{""}
+		Expect(os.Remove(filepath.Join(certsDir, "ca.crt"))).To(Succeed())
+
+		Expect(config.ValidateAgent(cfg)).To(Succeed())
+
+		cfg.Consul.Agent.DefaultCheck.Script = "/var/vcap/jobs/{job}/bin/dns_health_check"
+		Expect(config.ValidateAgent(cfg)).To(MatchError(`consul.agent.default_check.script: must only use the placeholders {service}, {node_name}, {node_index}, got "{job}"`))
+	})
+
+	It("uses the service key as the name when no name is given", func() {
+		cfg.Consul.Agent.Services["bad.service"] = config.ServiceDefinition{}
+
+		Expect(config.Validate(cfg)).To(MatchError(`consul.agent.services.bad.service: must be a valid DNS label, got "bad.service"`))
+	})
+
+	Context("certificates", func() {
+		It("reports a missing CA certificate", func() {
+			caFile := filepath.Join(certsDir, "ca.crt")
+			Expect(os.Remove(caFile)).To(Succeed())
+
+			err := config.Validate(cfg)
+			Expect(err).To(MatchError(ContainSubstring("consul.ca_cert: open " + caFile)))
+		})
+
+		It("reports a CA file without certificates", func() {
+			caFile := filepath.Join(certsDir, "ca.crt")
+			Expect(ioutil.WriteFile(caFile, []byte("not a certificate"), 0644)).To(Succeed())
+
+			err := config.Validate(cfg)
+			Expect(err).To(MatchError("consul.ca_cert: does not contain a PEM-encoded certificate"))
+		})
+
+		It("reports a certificate that does not match its key", func() {
+			Expect(os.Rename(filepath.Join(certsDir, "agent.key"), filepath.Join(certsDir, "server.key"))).To(Succeed())
+
+			err := config.Validate(cfg)
+			Expect(err).To(MatchError(ContainSubstring("consul.server_cert: could not load key pair with consul.server_key")))
+		})
+
+		It("reports a certificate that is not signed by the CA", func() {
+			otherCA, _ := generateCertificate(nil, nil)
+			writePEM(filepath.Join(certsDir, "ca.crt"), "CERTIFICATE", otherCA.Raw)
+
+			err := config.Validate(cfg)
+			Expect(err).To(MatchError(ContainSubstring("consul.server_cert: is not signed by consul.ca_cert")))
+		})
+
+		It("validates the agent certificate for clients", func() {
+			cfg.Consul.Agent.Mode = "client"
+			Expect(os.Remove(filepath.Join(certsDir, "agent.key"))).To(Succeed())
+
+			err := config.Validate(cfg)
+			Expect(err).To(MatchError(ContainSubstring("consul.agent_cert: could not load key pair with consul.agent_key")))
+		})
+
+		It("does not check the certificates when ssl is not required", func() {
+			cfg.Consul.Agent.RequireSSL = false
+			Expect(os.RemoveAll(certsDir)).To(Succeed())
+
+			Expect(config.Validate(cfg)).To(Succeed())
+		})
+	})
+})
+
+func generateCertificate(parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).NotTo(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "consul"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	if parent == nil {
+		template.IsCA = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent = template
+		parentKey = key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	Expect(err).NotTo(HaveOccurred())
+
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+
+	return cert, key
+}
+
+func writePEM(path, blockType string, bytes []byte) {
+	contents := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes})
+	Expect(ioutil.WriteFile(path, contents, 0644)).To(Succeed())
+}
@@ -44,7 +44,8 @@ func (s *Server) ServeHTTP() {
 		var members []api.AgentMember
 		for _, member := range s.Members {
 			members = append(members, api.AgentMember{
-				Addr: member,
+				Addr:   member,
+				Status: 1,
 				Tags: map[string]string{
 					"role": "consul",
 				},
@@ -61,6 +62,9 @@ func (s *Server) ServeHTTP() {
 						"commit_index":   "5",
 						"last_log_index": "2"
 					}
+				},
+				"Member": {
+					"Addr": "` + s.address() + `"
 				}
 			}`))
 		} else {
@@ -70,6 +74,9 @@ func (s *Server) ServeHTTP() {
 						"commit_index":   "2",
 						"last_log_index": "2"
 					}
+				},
+				"Member": {
+					"Addr": "` + s.address() + `"
 				}
 			}`))
 		}
@@ -89,6 +96,18 @@ func (s *Server) ServeHTTP() {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`""`)) //s.Members[0]
 	})
+	mux.HandleFunc("/v1/operator/raft/configuration", func(w http.ResponseWriter, req *http.Request) {
+		var configuration api.RaftConfiguration
+		for _, member := range s.Members {
+			configuration.Servers = append(configuration.Servers, &api.RaftServer{
+				ID:      member,
+				Node:    member,
+				Address: member + ":8300",
+				Voter:   true,
+			})
+		}
+		json.NewEncoder(w).Encode(configuration)
+	})
 	mux.HandleFunc("/v1/operator/keyring", func(w http.ResponseWriter, req *http.Request) {
 		switch req.Method {
 		case "GET":
@@ -117,6 +136,15 @@ func (s *Server) ServeHTTP() {
 	server.Serve(s.HTTPListener)
 }
 
+// address is the address the fake agent reports for itself.
+func (s *Server) address() string {
+	if len(s.Members) == 0 {
+		return ""
+	}
+
+	return s.Members[0]
+}
+
 func (s Server) Exit() error {
 	err := s.HTTPListener.Close()
 	if err != nil {
@@ -1,6 +1,9 @@
 package fakes
 
-import "github.com/hashicorp/consul/api"
+import (
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/agent"
+	"github.com/hashicorp/consul/api"
+)
 
 type AgentClient struct {
 	VerifyJoinedCalls struct {
@@ -17,10 +20,43 @@ type AgentClient struct {
 		}
 	}
 
+	VerifyRaftPeersCall struct {
+		CallCount int
+		Returns   struct {
+			Errors []error
+			Error  error
+		}
+	}
+
+	ACLTokenCall struct {
+		CallCount int
+		Receives  struct {
+			ID    string
+			Token string
+		}
+		Returns struct {
+			ACLEntries []*api.ACLEntry
+			ACLEntry   *api.ACLEntry
+			Error      error
+		}
+	}
+
+	SetACLTokenCall struct {
+		CallCount int
+		Receives  struct {
+			Entries []*api.ACLEntry
+			Token   string
+		}
+		Returns struct {
+			Error error
+		}
+	}
+
 	SetKeysCall struct {
-		Receives struct {
-			Keys        []string
-			KeyringFile string
+		CallCount int
+		Receives  struct {
+			Keys    []string
+			DataDir string
 		}
 		Returns struct {
 			Error error
@@ -34,6 +70,16 @@ type AgentClient struct {
 		}
 	}
 
+	DeregisterServiceCall struct {
+		CallCount int
+		Receives  struct {
+			ServiceIDs []string
+		}
+		Returns struct {
+			Error error
+		}
+	}
+
 	MembersCall struct {
 		CallCount int
 		Receives  struct {
@@ -48,7 +94,9 @@ type AgentClient struct {
 	JoinMembersCall struct {
 		CallCount int
 		Returns   struct {
-			Error error
+			Outcomes []agent.JoinOutcome
+			Error    error
+			Errors   []error
 		}
 	}
 	SelfCall struct {
@@ -65,10 +113,19 @@ type AgentClient struct {
 			Error error
 		}
 	}
+	KeyringCall struct {
+		CallCount int
+		Returns   struct {
+			Keyrings [][]*api.KeyringResponse
+			Keyring  []*api.KeyringResponse
+			Error    error
+		}
+	}
 	InstallKeyCall struct {
 		CallCount int
 		Receives  struct {
-			Key string
+			Key  string
+			Keys []string
 		}
 		Returns struct {
 			Error error
@@ -77,7 +134,8 @@ type AgentClient struct {
 	UseKeyCall struct {
 		CallCount int
 		Receives  struct {
-			Key string
+			Key  string
+			Keys []string
 		}
 		Returns struct {
 			Error error
@@ -86,12 +144,34 @@ type AgentClient struct {
 	RemoveKeyCall struct {
 		CallCount int
 		Receives  struct {
-			Key string
+			Key  string
+			Keys []string
 		}
 		Returns struct {
 			Error error
 		}
 	}
+	RaftStatsCall struct {
+		CallCount int
+		Returns   struct {
+			Stats map[string]interface{}
+			Error error
+		}
+	}
+	NodeNameCall struct {
+		CallCount int
+		Returns   struct {
+			NodeName string
+			Error    error
+		}
+	}
+	RaftConfigurationCall struct {
+		CallCount int
+		Returns   struct {
+			RaftConfiguration *api.RaftConfiguration
+			Error             error
+		}
+	}
 }
 
 func (c *AgentClient) Self() error {
@@ -121,9 +201,37 @@ func (c *AgentClient) VerifySynced() error {
 	return err
 }
 
-func (c *AgentClient) SetKeys(keys []string, keyringFile string) error {
+func (c *AgentClient) VerifyRaftPeers() error {
+	err := c.VerifyRaftPeersCall.Returns.Error
+	if len(c.VerifyRaftPeersCall.Returns.Errors) > c.VerifyRaftPeersCall.CallCount {
+		err = c.VerifyRaftPeersCall.Returns.Errors[c.VerifyRaftPeersCall.CallCount]
+	}
+	c.VerifyRaftPeersCall.CallCount++
+	return err
+}
+
+func (c *AgentClient) ACLToken(id, token string) (*api.ACLEntry, error) {
+	entry := c.ACLTokenCall.Returns.ACLEntry
+	if len(c.ACLTokenCall.Returns.ACLEntries) > c.ACLTokenCall.CallCount {
+		entry = c.ACLTokenCall.Returns.ACLEntries[c.ACLTokenCall.CallCount]
+	}
+	c.ACLTokenCall.CallCount++
+	c.ACLTokenCall.Receives.ID = id
+	c.ACLTokenCall.Receives.Token = token
+	return entry, c.ACLTokenCall.Returns.Error
+}
+
+func (c *AgentClient) SetACLToken(entry *api.ACLEntry, token string) error {
+	c.SetACLTokenCall.CallCount++
+	c.SetACLTokenCall.Receives.Entries = append(c.SetACLTokenCall.Receives.Entries, entry)
+	c.SetACLTokenCall.Receives.Token = token
+	return c.SetACLTokenCall.Returns.Error
+}
+
+func (c *AgentClient) SetKeys(keys []string, dataDir string) error {
+	c.SetKeysCall.CallCount++
 	c.SetKeysCall.Receives.Keys = keys
-	c.SetKeysCall.Receives.KeyringFile = keyringFile
+	c.SetKeysCall.Receives.DataDir = dataDir
 	return c.SetKeysCall.Returns.Error
 }
 
@@ -132,15 +240,25 @@ func (c *AgentClient) Leave() error {
 	return c.LeaveCall.Returns.Error
 }
 
+func (c *AgentClient) DeregisterService(serviceID string) error {
+	c.DeregisterServiceCall.CallCount++
+	c.DeregisterServiceCall.Receives.ServiceIDs = append(c.DeregisterServiceCall.Receives.ServiceIDs, serviceID)
+	return c.DeregisterServiceCall.Returns.Error
+}
+
 func (c *AgentClient) Members(wan bool) ([]*api.AgentMember, error) {
 	c.MembersCall.CallCount++
 	c.MembersCall.Receives.WAN = wan
 	return c.MembersCall.Returns.Members, c.MembersCall.Returns.Error
 }
 
-func (c *AgentClient) JoinMembers() error {
+func (c *AgentClient) JoinMembers() ([]agent.JoinOutcome, error) {
+	err := c.JoinMembersCall.Returns.Error
+	if len(c.JoinMembersCall.Returns.Errors) > c.JoinMembersCall.CallCount {
+		err = c.JoinMembersCall.Returns.Errors[c.JoinMembersCall.CallCount]
+	}
 	c.JoinMembersCall.CallCount++
-	return c.JoinMembersCall.Returns.Error
+	return c.JoinMembersCall.Returns.Outcomes, err
 }
 
 func (c *AgentClient) ListKeys() ([]string, error) {
@@ -148,20 +266,47 @@ func (c *AgentClient) ListKeys() ([]string, error) {
 	return c.ListKeysCall.Returns.Keys, c.ListKeysCall.Returns.Error
 }
 
+func (c *AgentClient) Keyring() ([]*api.KeyringResponse, error) {
+	keyring := c.KeyringCall.Returns.Keyring
+	if len(c.KeyringCall.Returns.Keyrings) > c.KeyringCall.CallCount {
+		keyring = c.KeyringCall.Returns.Keyrings[c.KeyringCall.CallCount]
+	}
+	c.KeyringCall.CallCount++
+	return keyring, c.KeyringCall.Returns.Error
+}
+
 func (c *AgentClient) InstallKey(key string) error {
 	c.InstallKeyCall.CallCount++
 	c.InstallKeyCall.Receives.Key = key
+	c.InstallKeyCall.Receives.Keys = append(c.InstallKeyCall.Receives.Keys, key)
 	return c.InstallKeyCall.Returns.Error
 }
 
 func (c *AgentClient) UseKey(key string) error {
 	c.UseKeyCall.CallCount++
 	c.UseKeyCall.Receives.Key = key
+	c.UseKeyCall.Receives.Keys = append(c.UseKeyCall.Receives.Keys, key)
 	return c.UseKeyCall.Returns.Error
 }
 
 func (c *AgentClient) RemoveKey(key string) error {
 	c.RemoveKeyCall.CallCount++
 	c.RemoveKeyCall.Receives.Key = key
+	c.RemoveKeyCall.Receives.Keys = append(c.RemoveKeyCall.Receives.Keys, key)
 	return c.RemoveKeyCall.Returns.Error
 }
+
+func (c *AgentClient) RaftStats() (map[string]interface{}, error) {
+	c.RaftStatsCall.CallCount++
+	return c.RaftStatsCall.Returns.Stats, c.RaftStatsCall.Returns.Error
+}
+
+func (c *AgentClient) NodeName() (string, error) {
+	c.NodeNameCall.CallCount++
+	return c.NodeNameCall.Returns.NodeName, c.NodeNameCall.Returns.Error
+}
+
+func (c *AgentClient) RaftConfiguration() (*api.RaftConfiguration, error) {
+	c.RaftConfigurationCall.CallCount++
+	return c.RaftConfigurationCall.Returns.RaftConfiguration, c.RaftConfigurationCall.Returns.Error
+}
@@ -29,6 +29,27 @@ type AgentRunner struct {
 		}
 	}
 
+	ReloadCall struct {
+		CallCount int
+		Returns   struct {
+			Error error
+		}
+	}
+
+	ExitStatusCall struct {
+		CallCount int
+		Returns   struct {
+			ExitStatus string
+		}
+	}
+
+	CrashedCall struct {
+		CallCount int
+		Returns   struct {
+			Crashed bool
+		}
+	}
+
 	WritePIDCall struct {
 		CallCount int
 		Returns   struct {
@@ -65,3 +86,18 @@ func (r *AgentRunner) WritePID() error {
 	r.WritePIDCall.CallCount++
 	return r.WritePIDCall.Returns.Error
 }
+
+func (r *AgentRunner) ExitStatus() string {
+	r.ExitStatusCall.CallCount++
+	return r.ExitStatusCall.Returns.ExitStatus
+}
+
+func (r *AgentRunner) Crashed() bool {
+	r.CrashedCall.CallCount++
+	return r.CrashedCall.Returns.Crashed
+}
+
+func (r *AgentRunner) Reload() error {
+	r.ReloadCall.CallCount++
+	return r.ReloadCall.Returns.Error
+}
@@ -8,9 +8,39 @@ type BootstrapChecker struct {
 			Error     error
 		}
 	}
+
+	BootstrapNodeCall struct {
+		CallCount int
+		Returns   struct {
+			Name  string
+			Error error
+		}
+	}
+
+	AwaitingBootstrapPeersCall struct {
+		CallCount int
+		Receives  struct {
+			Expect int
+		}
+		Returns struct {
+			Awaiting bool
+			Error    error
+		}
+	}
 }
 
 func (b *BootstrapChecker) StartInBootstrapMode() (bool, error) {
 	b.StartInBootstrapModeCall.CallCount++
 	return b.StartInBootstrapModeCall.Returns.Bootstrap, b.StartInBootstrapModeCall.Returns.Error
 }
+
+func (b *BootstrapChecker) BootstrapNode() (string, error) {
+	b.BootstrapNodeCall.CallCount++
+	return b.BootstrapNodeCall.Returns.Name, b.BootstrapNodeCall.Returns.Error
+}
+
+func (b *BootstrapChecker) AwaitingBootstrapPeers(expect int) (bool, error) {
+	b.AwaitingBootstrapPeersCall.CallCount++
+	b.AwaitingBootstrapPeersCall.Receives.Expect = expect
+	return b.AwaitingBootstrapPeersCall.Returns.Awaiting, b.AwaitingBootstrapPeersCall.Returns.Error
+}
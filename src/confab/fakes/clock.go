@@ -1,17 +1,64 @@
 package fakes
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 type Clock struct {
+	NowCall struct {
+		CallCount int
+		Returns   struct {
+			Time time.Time
+		}
+	}
+
 	SleepCall struct {
+		sync.Mutex
+		CallCount int
+		Receives  struct {
+			Duration  time.Duration
+			Durations []time.Duration
+		}
+	}
+
+	AfterCall struct {
+		sync.Mutex
 		CallCount int
+		Stub      func(time.Duration) <-chan time.Time
 		Receives  struct {
-			Duration time.Duration
+			Durations []time.Duration
 		}
 	}
 }
 
 func (c *Clock) Sleep(duration time.Duration) {
+	c.SleepCall.Lock()
+	defer c.SleepCall.Unlock()
+
 	c.SleepCall.CallCount++
 	c.SleepCall.Receives.Duration = duration
+	c.SleepCall.Receives.Durations = append(c.SleepCall.Receives.Durations, duration)
+}
+
+func (c *Clock) Now() time.Time {
+	c.NowCall.CallCount++
+	return c.NowCall.Returns.Time
+}
+
+// After fires at once unless a stub is given.
+func (c *Clock) After(duration time.Duration) <-chan time.Time {
+	c.AfterCall.Lock()
+	c.AfterCall.CallCount++
+	c.AfterCall.Receives.Durations = append(c.AfterCall.Receives.Durations, duration)
+	stub := c.AfterCall.Stub
+	c.AfterCall.Unlock()
+
+	if stub != nil {
+		return stub(duration)
+	}
+
+	fired := make(chan time.Time, 1)
+	fired <- time.Time{}
+	return fired
 }
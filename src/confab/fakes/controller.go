@@ -38,6 +38,13 @@ type Controller struct {
 		}
 	}
 
+	WritePIDCall struct {
+		CallCount int
+		Returns   struct {
+			Error error
+		}
+	}
+
 	StopAgentCall struct {
 		CallCount int
 	}
@@ -73,6 +80,12 @@ func (c *Controller) ConfigureClient() error {
 	return c.ConfigureClientCall.Returns.Error
 }
 
+func (c *Controller) WritePID() error {
+	c.WritePIDCall.CallCount++
+
+	return c.WritePIDCall.Returns.Error
+}
+
 func (c *Controller) StopAgent() {
 	c.StopAgentCall.CallCount++
 }
@@ -0,0 +1,42 @@
+package fakes
+
+import "github.com/hashicorp/consul/api"
+
+type FakeconsulAPIACL struct {
+	InfoCall struct {
+		CallCount int
+		Receives  struct {
+			ID           string
+			QueryOptions *api.QueryOptions
+		}
+		Returns struct {
+			ACLEntry *api.ACLEntry
+			Error    error
+		}
+	}
+
+	UpdateCall struct {
+		CallCount int
+		Receives  struct {
+			ACLEntry     *api.ACLEntry
+			WriteOptions *api.WriteOptions
+		}
+		Returns struct {
+			Error error
+		}
+	}
+}
+
+func (a *FakeconsulAPIACL) Info(id string, queryOptions *api.QueryOptions) (*api.ACLEntry, *api.QueryMeta, error) {
+	a.InfoCall.CallCount++
+	a.InfoCall.Receives.ID = id
+	a.InfoCall.Receives.QueryOptions = queryOptions
+	return a.InfoCall.Returns.ACLEntry, &api.QueryMeta{}, a.InfoCall.Returns.Error
+}
+
+func (a *FakeconsulAPIACL) Update(entry *api.ACLEntry, writeOptions *api.WriteOptions) (*api.WriteMeta, error) {
+	a.UpdateCall.CallCount++
+	a.UpdateCall.Receives.ACLEntry = entry
+	a.UpdateCall.Receives.WriteOptions = writeOptions
+	return &api.WriteMeta{}, a.UpdateCall.Returns.Error
+}
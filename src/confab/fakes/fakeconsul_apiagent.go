@@ -18,6 +18,7 @@ type FakeconsulAPIAgent struct {
 		result2 error
 	}
 	JoinCall struct {
+		sync.Mutex
 		CallCount int
 		Stub      func(member string, wan bool) error
 		Receives  struct {
@@ -41,6 +42,15 @@ type FakeconsulAPIAgent struct {
 			Error error
 		}
 	}
+	ServiceDeregisterCall struct {
+		CallCount int
+		Receives  struct {
+			ServiceIDs []string
+		}
+		Returns struct {
+			Error error
+		}
+	}
 }
 
 func (fake *FakeconsulAPIAgent) Self() (map[string]map[string]interface{}, error) {
@@ -82,16 +92,27 @@ func (fake *FakeconsulAPIAgent) MembersReturns(result1 []*api.AgentMember, resul
 }
 
 func (fake *FakeconsulAPIAgent) Join(member string, wan bool) error {
+	fake.JoinCall.Lock()
 	fake.JoinCall.CallCount++
 	fake.JoinCall.Receives.Members = append(fake.JoinCall.Receives.Members, member)
 	fake.JoinCall.Receives.WAN = wan
-	if fake.JoinCall.Stub != nil {
-		return fake.JoinCall.Stub(member, wan)
+	stub := fake.JoinCall.Stub
+	err := fake.JoinCall.Returns.Error
+	fake.JoinCall.Unlock()
+
+	if stub != nil {
+		return stub(member, wan)
 	}
-	return fake.JoinCall.Returns.Error
+	return err
 }
 
 func (fake *FakeconsulAPIAgent) Leave() error {
 	fake.LeaveCall.CallCount++
 	return fake.LeaveCall.Returns.Error
 }
+
+func (fake *FakeconsulAPIAgent) ServiceDeregister(serviceID string) error {
+	fake.ServiceDeregisterCall.CallCount++
+	fake.ServiceDeregisterCall.Receives.ServiceIDs = append(fake.ServiceDeregisterCall.Receives.ServiceIDs, serviceID)
+	return fake.ServiceDeregisterCall.Returns.Error
+}
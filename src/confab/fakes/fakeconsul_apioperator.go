@@ -5,6 +5,7 @@ import "github.com/hashicorp/consul/api"
 type FakeconsulAPIOperator struct {
 	KeyringListCall struct {
 		CallCount int
+		Stub      func(*api.QueryOptions) ([]*api.KeyringResponse, error)
 		Receives  struct {
 			QueryOptions *api.QueryOptions
 		}
@@ -46,11 +47,38 @@ type FakeconsulAPIOperator struct {
 			Error error
 		}
 	}
+
+	RaftGetConfigurationCall struct {
+		CallCount int
+		Receives  struct {
+			QueryOptions *api.QueryOptions
+		}
+		Returns struct {
+			RaftConfiguration *api.RaftConfiguration
+			Error             error
+		}
+	}
+
+	RaftRemovePeerByAddressCall struct {
+		CallCount int
+		Receives  struct {
+			Address      string
+			WriteOptions *api.WriteOptions
+		}
+		Returns struct {
+			Error error
+		}
+	}
 }
 
 func (o *FakeconsulAPIOperator) KeyringList(queryOptions *api.QueryOptions) ([]*api.KeyringResponse, error) {
 	o.KeyringListCall.CallCount++
 	o.KeyringListCall.Receives.QueryOptions = queryOptions
+
+	if o.KeyringListCall.Stub != nil {
+		return o.KeyringListCall.Stub(queryOptions)
+	}
+
 	return o.KeyringListCall.Returns.KeyringResponse, o.KeyringListCall.Returns.Error
 }
 
@@ -74,3 +102,16 @@ func (o *FakeconsulAPIOperator) KeyringRemove(key string, writeOptions *api.Writ
 	o.KeyringRemoveCall.Receives.WriteOptions = writeOptions
 	return o.KeyringRemoveCall.Returns.Error
 }
+
+func (o *FakeconsulAPIOperator) RaftGetConfiguration(queryOptions *api.QueryOptions) (*api.RaftConfiguration, error) {
+	o.RaftGetConfigurationCall.CallCount++
+	o.RaftGetConfigurationCall.Receives.QueryOptions = queryOptions
+	return o.RaftGetConfigurationCall.Returns.RaftConfiguration, o.RaftGetConfigurationCall.Returns.Error
+}
+
+func (o *FakeconsulAPIOperator) RaftRemovePeerByAddress(address string, writeOptions *api.WriteOptions) error {
+	o.RaftRemovePeerByAddressCall.CallCount++
+	o.RaftRemovePeerByAddressCall.Receives.Address = address
+	o.RaftRemovePeerByAddressCall.Receives.WriteOptions = writeOptions
+	return o.RaftRemovePeerByAddressCall.Returns.Error
+}
@@ -0,0 +1,16 @@
+package fakes
+
+type Monit struct {
+	UnmonitorCall struct {
+		CallCount int
+		Returns   struct {
+			Error error
+		}
+	}
+}
+
+func (m *Monit) Unmonitor() error {
+	m.UnmonitorCall.CallCount++
+
+	return m.UnmonitorCall.Returns.Error
+}
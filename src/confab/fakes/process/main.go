@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 )
 
@@ -54,17 +55,28 @@ func main() {
 		log.Fatal("missing required config-dir flag")
 	}
 
-	writeOutput(configDir, data)
-
 	// read input options provided to us by the test
 	var inputOptions struct {
-		WaitForHUP bool
+		WaitForHUP      bool
+		ExitOnInterrupt bool
+		ExitCode        int
 	}
 
 	if optionsBytes, err := ioutil.ReadFile(filepath.Join(configDir, "options.json")); err == nil {
 		json.Unmarshal(optionsBytes, &inputOptions)
 	}
 
+	if inputOptions.ExitOnInterrupt {
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, syscall.SIGINT)
+		go func() {
+			<-interrupts
+			os.Exit(0)
+		}()
+	}
+
+	writeOutput(configDir, data)
+
 	fmt.Fprintf(os.Stdout, "some standard out")
 	fmt.Fprintf(os.Stderr, "some standard error")
 
@@ -73,6 +85,8 @@ func main() {
 			time.Sleep(time.Second)
 		}
 	}
+
+	os.Exit(inputOptions.ExitCode)
 }
 
 func writeOutput(configDir string, data outputData) {
@@ -0,0 +1,26 @@
+package fakes
+
+type ServerStatusClient struct {
+	LeaderCall struct {
+		Stub      func(address string) (string, error)
+		CallCount int
+		Receives  struct {
+			Addresses []string
+		}
+		Returns struct {
+			Leader string
+			Error  error
+		}
+	}
+}
+
+func (c *ServerStatusClient) Leader(address string) (string, error) {
+	c.LeaderCall.CallCount++
+	c.LeaderCall.Receives.Addresses = append(c.LeaderCall.Receives.Addresses, address)
+
+	if c.LeaderCall.Stub != nil {
+		return c.LeaderCall.Stub(address)
+	}
+
+	return c.LeaderCall.Returns.Leader, c.LeaderCall.Returns.Error
+}
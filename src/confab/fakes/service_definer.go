@@ -13,6 +13,27 @@ type ServiceDefiner struct {
 		}
 	}
 
+	RenderDefinitionsCall struct {
+		Receives struct {
+			Definitions []config.ServiceDefinition
+		}
+		Returns struct {
+			Files map[string][]byte
+			Error error
+		}
+	}
+
+	RemoveStaleDefinitionsCall struct {
+		Receives struct {
+			Definitions []config.ServiceDefinition
+			ConfigDir   string
+		}
+		Returns struct {
+			ServiceIDs []string
+			Error      error
+		}
+	}
+
 	WriteDefinitionsCall struct {
 		Receives struct {
 			Definitions []config.ServiceDefinition
@@ -34,3 +55,14 @@ func (d *ServiceDefiner) GenerateDefinitions(config config.Config) ([]config.Ser
 	d.GenerateDefinitionsCall.Receives.Config = config
 	return d.GenerateDefinitionsCall.Returns.Definitions, d.GenerateDefinitionsCall.Returns.Error
 }
+
+func (d *ServiceDefiner) RenderDefinitions(definitions []config.ServiceDefinition) (map[string][]byte, error) {
+	d.RenderDefinitionsCall.Receives.Definitions = definitions
+	return d.RenderDefinitionsCall.Returns.Files, d.RenderDefinitionsCall.Returns.Error
+}
+
+func (d *ServiceDefiner) RemoveStaleDefinitions(configDir string, definitions []config.ServiceDefinition) ([]string, error) {
+	d.RemoveStaleDefinitionsCall.Receives.Definitions = definitions
+	d.RemoveStaleDefinitionsCall.Receives.ConfigDir = configDir
+	return d.RemoveStaleDefinitionsCall.Returns.ServiceIDs, d.RemoveStaleDefinitionsCall.Returns.Error
+}
@@ -0,0 +1,217 @@
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
+)
+
+// Exit codes of a consul script check.
+const (
+	Passing  = 0
+	Warning  = 1
+	Critical = 2
+)
+
+func StatusName(status int) string {
+	switch status {
+	case Passing:
+		return "passing"
+	case Warning:
+		return "warning"
+	default:
+		return "critical"
+	}
+}
+
+// Probe is one thing a health check looks at. Exactly one of HTTP, TCP,
+// PIDFile or Command is set.
+type Probe struct {
+	// HTTP is a URL that must answer a GET with ExpectedStatus, or 200 when
+	// ExpectedStatus is not set.
+	HTTP           string
+	ExpectedStatus int
+
+	// TCP is an address that must accept connections.
+	TCP string
+
+	// PIDFile names a file holding the PID of a process that must be running.
+	PIDFile string
+
+	// Command is run by the shell and its exit status is used as is when it
+	// is 0 or 1, and as critical otherwise.
+	Command string
+}
+
+func (p Probe) String() string {
+	switch {
+	case p.HTTP != "":
+		return fmt.Sprintf("http %s", p.HTTP)
+	case p.TCP != "":
+		return fmt.Sprintf("tcp %s", p.TCP)
+	case p.PIDFile != "":
+		return fmt.Sprintf("pid-file %s", p.PIDFile)
+	default:
+		return fmt.Sprintf("command %s", p.Command)
+	}
+}
+
+type Result struct {
+	Probe  Probe
+	Status int
+	Output string
+}
+
+// Checker runs probes, waiting up to Timeout for each of them. Probes are
+// not limited when Timeout is 0.
+type Checker struct {
+	Probes  []Probe
+	Timeout time.Duration
+}
+
+// Check runs every probe and returns the worst of their statuses along with
+// the result of each probe. A check without probes is critical.
+func (c Checker) Check() (int, []Result) {
+	if len(c.Probes) == 0 {
+		return Critical, []Result{{Status: Critical, Output: "no probes to run"}}
+	}
+
+	status := Passing
+	var results []Result
+	for _, probe := range c.Probes {
+		result := c.run(probe)
+		if result.Status > status {
+			status = result.Status
+		}
+
+		results = append(results, result)
+	}
+
+	return status, results
+}
+
+func (c Checker) run(probe Probe) Result {
+	var status int
+	var output string
+	switch {
+	case probe.HTTP != "":
+		status, output = c.checkHTTP(probe.HTTP, probe.ExpectedStatus)
+	case probe.TCP != "":
+		status, output = c.checkTCP(probe.TCP)
+	case probe.PIDFile != "":
+		status, output = checkPIDFile(probe.PIDFile)
+	case probe.Command != "":
+		status, output = c.checkCommand(probe.Command)
+	default:
+		status, output = Critical, "probe has nothing to check"
+	}
+
+	return Result{
+		Probe:  probe,
+		Status: status,
+		Output: output,
+	}
+}
+
+func (c Checker) checkHTTP(url string, expectedStatus int) (int, string) {
+	if expectedStatus == 0 {
+		expectedStatus = http.StatusOK
+	}
+
+	client := &http.Client{Timeout: c.Timeout}
+	response, err := client.Get(url)
+	if err != nil {
+		return Critical, err.Error()
+	}
+	defer response.Body.Close()
+
+	output := fmt.Sprintf("HTTP GET %s: %s", url, response.Status)
+	switch response.StatusCode {
+	case expectedStatus:
+		return Passing, output
+	case http.StatusTooManyRequests:
+		return Warning, output
+	default:
+		return Critical, fmt.Sprintf("%s, expected %d", output, expectedStatus)
+	}
+}
+
+func (c Checker) checkTCP(address string) (int, string) {
+	connection, err := net.DialTimeout("tcp", address, c.Timeout)
+	if err != nil {
+		return Critical, err.Error()
+	}
+	connection.Close()
+
+	return Passing, fmt.Sprintf("TCP connect %s: success", address)
+}
+
+func checkPIDFile(pidFile string) (int, string) {
+	contents, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return Critical, err.Error()
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return Critical, fmt.Sprintf("pid file %s does not contain a pid", pidFile)
+	}
+
+	if !utils.IsPIDRunning(pid) {
+		return Critical, fmt.Sprintf("process %d is not running", pid)
+	}
+
+	return Passing, fmt.Sprintf("process %d is running", pid)
+}
+
+func (c Checker) checkCommand(command string) (int, string) {
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	output, err := shellCommand(ctx, command).CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return Critical, fmt.Sprintf("command timed out after %s", c.Timeout)
+	}
+
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.ExitStatus() == Warning {
+				return Warning, string(output)
+			}
+		}
+
+		return Critical, strings.TrimSpace(fmt.Sprintf("%s\n%s", output, err))
+	}
+
+	return Passing, string(output)
+}
+
+// HTTPProbe returns the probe for an HTTP probe argument, a URL optionally
+// prefixed with the expected status, as in "204:http://127.0.0.1:8080/health".
+func HTTPProbe(argument string) (Probe, error) {
+	probe := Probe{HTTP: argument}
+
+	parts := strings.SplitN(argument, ":", 2)
+	if status, err := strconv.Atoi(parts[0]); err == nil && len(parts) == 2 {
+		probe = Probe{HTTP: parts[1], ExpectedStatus: status}
+	}
+
+	if !strings.HasPrefix(probe.HTTP, "http://") && !strings.HasPrefix(probe.HTTP, "https://") {
+		return Probe{}, fmt.Errorf("invalid http probe %q: must be a URL, optionally prefixed with the expected status", argument)
+	}
+
+	return probe, nil
+}
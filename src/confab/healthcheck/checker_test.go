@@ -0,0 +1,170 @@
+package healthcheck_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/healthcheck"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Checker", func() {
+	var (
+		server   *httptest.Server
+		listener net.Listener
+		dataDir  string
+		pidFile  string
+		checker  healthcheck.Checker
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/health":
+				w.WriteHeader(http.StatusOK)
+			case "/ready":
+				w.WriteHeader(http.StatusNoContent)
+			case "/busy":
+				w.WriteHeader(http.StatusTooManyRequests)
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}))
+
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		dataDir, err = ioutil.TempDir("", "healthcheck")
+		Expect(err).NotTo(HaveOccurred())
+
+		pidFile = filepath.Join(dataDir, "service.pid")
+		Expect(ioutil.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)).To(Succeed())
+
+		checker = healthcheck.Checker{Timeout: time.Second}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		listener.Close()
+		Expect(os.RemoveAll(dataDir)).To(Succeed())
+	})
+
+	It("passes when every probe passes", func() {
+		checker.Probes = []healthcheck.Probe{
+			{HTTP: server.URL + "/health"},
+			{HTTP: server.URL + "/ready", ExpectedStatus: http.StatusNoContent},
+			{TCP: listener.Addr().String()},
+			{PIDFile: pidFile},
+			{Command: "echo ready"},
+		}
+
+		status, results := checker.Check()
+		Expect(status).To(Equal(healthcheck.Passing))
+		Expect(results).To(HaveLen(5))
+		for _, result := range results {
+			Expect(result.Status).To(Equal(healthcheck.Passing))
+		}
+		Expect(results[4].Output).To(ContainSubstring("ready"))
+	})
+
+	It("reports the worst status of its probes", func() {
+		checker.Probes = []healthcheck.Probe{
+			{HTTP: server.URL + "/health"},
+			{HTTP: server.URL + "/busy"},
+		}
+
+		status, _ := checker.Check()
+		Expect(status).To(Equal(healthcheck.Warning))
+
+		checker.Probes = append(checker.Probes, healthcheck.Probe{HTTP: server.URL + "/broken"})
+
+		status, results := checker.Check()
+		Expect(status).To(Equal(healthcheck.Critical))
+		Expect(results[2].Output).To(ContainSubstring("500 Internal Server Error, expected 200"))
+	})
+
+	It("is critical without probes", func() {
+		status, _ := checker.Check()
+		Expect(status).To(Equal(healthcheck.Critical))
+	})
+
+	It("is critical when nothing accepts connections", func() {
+		address := listener.Addr().String()
+		listener.Close()
+
+		checker.Probes = []healthcheck.Probe{{TCP: address}}
+
+		status, _ := checker.Check()
+		Expect(status).To(Equal(healthcheck.Critical))
+	})
+
+	It("is critical when the process is not running", func() {
+		Expect(ioutil.WriteFile(pidFile, []byte("-1"), 0644)).To(Succeed())
+		checker.Probes = []healthcheck.Probe{
+			{PIDFile: pidFile},
+			{PIDFile: filepath.Join(dataDir, "missing.pid")},
+		}
+
+		status, results := checker.Check()
+		Expect(status).To(Equal(healthcheck.Critical))
+		Expect(results[0].Status).To(Equal(healthcheck.Critical))
+		Expect(results[1].Status).To(Equal(healthcheck.Critical))
+	})
+
+	It("follows the consul convention for command exit statuses", func() {
+		checker.Probes = []healthcheck.Probe{{Command: "exit 1"}}
+		status, _ := checker.Check()
+		Expect(status).To(Equal(healthcheck.Warning))
+
+		checker.Probes = []healthcheck.Probe{{Command: "exit 3"}}
+		status, _ = checker.Check()
+		Expect(status).To(Equal(healthcheck.Critical))
+	})
+
+	It("is critical when a command times out", func() {
+		checker.Timeout = 100 * time.Millisecond
+		checker.Probes = []healthcheck.Probe{{Command: "sleep 5"}}
+		if Windows {
+			checker.Probes = []healthcheck.Probe{{Command: "ping -n 6 127.0.0.1"}}
+		}
+
+		status, results := checker.Check()
+		Expect(status).To(Equal(healthcheck.Critical))
+		Expect(results[0].Output).To(Equal("command timed out after 100ms"))
+	})
+
+	It("does not limit commands when there is no timeout", func() {
+		checker.Timeout = 0
+		checker.Probes = []healthcheck.Probe{{Command: "exit 0"}}
+
+		status, _ := checker.Check()
+		Expect(status).To(Equal(healthcheck.Passing))
+	})
+})
+
+var _ = Describe("HTTPProbe", func() {
+	It("parses a URL with an optional expected status", func() {
+		Expect(healthcheck.HTTPProbe("http://127.0.0.1:8080/health")).To(Equal(healthcheck.Probe{
+			HTTP: "http://127.0.0.1:8080/health",
+		}))
+
+		Expect(healthcheck.HTTPProbe("204:https://127.0.0.1:8080/health")).To(Equal(healthcheck.Probe{
+			HTTP:           "https://127.0.0.1:8080/health",
+			ExpectedStatus: 204,
+		}))
+	})
+
+	It("returns an error when the probe is not a URL", func() {
+		_, err := healthcheck.HTTPProbe("204:banana")
+		Expect(err).To(MatchError(`invalid http probe "204:banana": must be a URL, optionally prefixed with the expected status`))
+	})
+})
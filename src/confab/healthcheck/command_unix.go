@@ -0,0 +1,13 @@
+// +build !windows
+
+package healthcheck
+
+import (
+	"context"
+	"os/exec"
+)
+
+// shellCommand runs command the way consul runs script checks.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
@@ -0,0 +1,16 @@
+package healthcheck_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"runtime"
+	"testing"
+)
+
+func TestHealthcheck(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "healthcheck")
+}
+
+const Windows = runtime.GOOS == "windows"
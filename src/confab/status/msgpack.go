@@ -0,0 +1,199 @@
+package status
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// msgpackWriter encodes the few msgpack types a server RPC request needs.
+// Strings use the raw formats understood by the msgpack library consul uses.
+type msgpackWriter struct {
+	buf []byte
+}
+
+func (w *msgpackWriter) bytes() []byte {
+	return w.buf
+}
+
+func (w *msgpackWriter) writeMapHeader(n int) {
+	if n < 16 {
+		w.buf = append(w.buf, 0x80|byte(n))
+		return
+	}
+
+	w.buf = append(w.buf, 0xde, byte(n>>8), byte(n))
+}
+
+func (w *msgpackWriter) writeString(s string) {
+	if len(s) < 32 {
+		w.buf = append(w.buf, 0xa0|byte(len(s)))
+	} else {
+		w.buf = append(w.buf, 0xda, byte(len(s)>>8), byte(len(s)))
+	}
+
+	w.buf = append(w.buf, s...)
+}
+
+func (w *msgpackWriter) writeUint(n uint64) {
+	if n < 128 {
+		w.buf = append(w.buf, byte(n))
+		return
+	}
+
+	w.buf = append(w.buf, 0xcf)
+	w.buf = append(w.buf, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(w.buf[len(w.buf)-8:], n)
+}
+
+// msgpackReader decodes the scalars and flat maps of a server RPC response.
+type msgpackReader struct {
+	r *bufio.Reader
+}
+
+func (r msgpackReader) readMap() (map[string]interface{}, error) {
+	b, err := r.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	var n int
+	switch {
+	case b&0xf0 == 0x80:
+		n = int(b & 0x0f)
+	case b == 0xde:
+		size, err := r.readSize(2)
+		if err != nil {
+			return nil, err
+		}
+		n = size
+	case b == 0xdf:
+		size, err := r.readSize(4)
+		if err != nil {
+			return nil, err
+		}
+		n = size
+	default:
+		return nil, fmt.Errorf("expected a msgpack map, got type 0x%02x", b)
+	}
+
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := r.readValue()
+		if err != nil {
+			return nil, err
+		}
+
+		m[key] = value
+	}
+
+	return m, nil
+}
+
+func (r msgpackReader) readString() (string, error) {
+	value, err := r.readValue()
+	if err != nil {
+		return "", err
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("expected a msgpack string, got %T", value)
+	}
+
+	return s, nil
+}
+
+func (r msgpackReader) readValue() (interface{}, error) {
+	b, err := r.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xe0 == 0xa0:
+		return r.readRaw(int(b & 0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xd9:
+		return r.readSizedRaw(1)
+	case 0xc5, 0xda:
+		return r.readSizedRaw(2)
+	case 0xc6, 0xdb:
+		return r.readSizedRaw(4)
+	case 0xcc, 0xd0:
+		return r.readInt(1, b == 0xd0)
+	case 0xcd, 0xd1:
+		return r.readInt(2, b == 0xd1)
+	case 0xce, 0xd2:
+		return r.readInt(4, b == 0xd2)
+	case 0xcf, 0xd3:
+		return r.readInt(8, b == 0xd3)
+	default:
+		return nil, fmt.Errorf("unsupported msgpack type 0x%02x", b)
+	}
+}
+
+func (r msgpackReader) readSizedRaw(width int) (string, error) {
+	size, err := r.readSize(width)
+	if err != nil {
+		return "", err
+	}
+
+	return r.readRaw(size)
+}
+
+func (r msgpackReader) readRaw(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+func (r msgpackReader) readSize(width int) (int, error) {
+	n, err := r.readUint(width)
+	return int(n), err
+}
+
+func (r msgpackReader) readInt(width int, signed bool) (int64, error) {
+	n, err := r.readUint(width)
+	if err != nil || !signed {
+		return int64(n), err
+	}
+
+	shift := uint(64 - 8*width)
+	return int64(n<<shift) >> shift, nil
+}
+
+func (r msgpackReader) readUint(width int) (uint64, error) {
+	buf := make([]byte, width)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return 0, err
+	}
+
+	var n uint64
+	for _, b := range buf {
+		n = n<<8 | uint64(b)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,100 @@
+package status
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	serverRPCPort = "8300"
+
+	// the first byte of a connection to the server RPC port selects the
+	// protocol spoken on it
+	rpcConsul = 0x00
+	rpcTLS    = 0x03
+
+	defaultServerTimeout = 10 * time.Second
+)
+
+// ServerClient asks other servers for the cluster leader over the server RPC
+// port, as their HTTP APIs only listen on localhost. It speaks just enough of
+// consul's msgpack RPC protocol to call Status.Leader, over TLS when TLSConfig
+// is set.
+type ServerClient struct {
+	TLSConfig *tls.Config
+	Timeout   time.Duration
+}
+
+// Leader returns the leader known to the server at address, which is given
+// the server RPC port unless it includes one.
+func (c ServerClient) Leader(address string) (string, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, serverRPCPort)
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultServerTimeout
+	}
+
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+
+	if c.TLSConfig != nil {
+		if _, err := conn.Write([]byte{rpcTLS}); err != nil {
+			return "", err
+		}
+
+		tlsConn := tls.Client(conn, c.TLSConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return "", err
+		}
+		conn = tlsConn
+	}
+
+	if _, err := conn.Write([]byte{rpcConsul}); err != nil {
+		return "", err
+	}
+
+	return call(conn, "Status.Leader")
+}
+
+// call sends a request with an empty body and reads a string reply, framed as
+// net/rpc over msgpack: a request header map followed by the body, answered by
+// a response header map followed by the reply.
+func call(conn net.Conn, method string) (string, error) {
+	var request msgpackWriter
+	request.writeMapHeader(2)
+	request.writeString("ServiceMethod")
+	request.writeString(method)
+	request.writeString("Seq")
+	request.writeUint(0)
+	request.writeMapHeader(0)
+
+	if _, err := conn.Write(request.bytes()); err != nil {
+		return "", err
+	}
+
+	reader := msgpackReader{r: bufio.NewReader(conn)}
+
+	header, err := reader.readMap()
+	if err != nil {
+		return "", err
+	}
+
+	if message, ok := header["Error"].(string); ok && message != "" {
+		return "", errors.New(message)
+	}
+
+	return reader.readString()
+}
@@ -0,0 +1,137 @@
+package status_test
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"time"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/status"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ServerClient", func() {
+	var (
+		listener net.Listener
+		requests chan []byte
+		client   status.ServerClient
+	)
+
+	str := func(s string) []byte {
+		return append([]byte{0xa0 | byte(len(s))}, s...)
+	}
+
+	join := func(parts ...[]byte) []byte {
+		var buf []byte
+		for _, part := range parts {
+			buf = append(buf, part...)
+		}
+		return buf
+	}
+
+	request := join(
+		[]byte{0x00, 0x82},
+		str("ServiceMethod"), str("Status.Leader"),
+		str("Seq"), []byte{0x00},
+		[]byte{0x80},
+	)
+
+	response := func(errorMessage string, reply []byte) []byte {
+		return join(
+			[]byte{0x83},
+			str("ServiceMethod"), str("Status.Leader"),
+			str("Seq"), []byte{0x00},
+			str("Error"), str(errorMessage),
+			reply,
+		)
+	}
+
+	serve := func(size int, reply []byte) {
+		go func() {
+			defer GinkgoRecover()
+
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			buf := make([]byte, size)
+			_, err = io.ReadFull(conn, buf)
+			requests <- buf
+			if err != nil {
+				return
+			}
+
+			_, err = conn.Write(reply)
+			Expect(err).NotTo(HaveOccurred())
+		}()
+	}
+
+	BeforeEach(func() {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+
+		requests = make(chan []byte, 1)
+		client = status.ServerClient{Timeout: 5 * time.Second}
+	})
+
+	AfterEach(func() {
+		listener.Close()
+	})
+
+	Describe("Leader", func() {
+		It("asks the server for the leader over msgpack rpc", func() {
+			serve(len(request), response("", str("10.0.0.2:8300")))
+
+			leader, err := client.Leader(listener.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leader).To(Equal("10.0.0.2:8300"))
+			Expect(<-requests).To(Equal(request))
+		})
+
+		It("returns an empty leader when the server knows none", func() {
+			serve(len(request), response("", str("")))
+
+			leader, err := client.Leader(listener.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(leader).To(BeEmpty())
+		})
+
+		It("selects tls before the handshake when a tls config is given", func() {
+			client.TLSConfig = &tls.Config{ServerName: "server.dc1.consul"}
+			serve(1, nil)
+
+			_, err := client.Leader(listener.Addr().String())
+			Expect(err).To(HaveOccurred())
+			Expect(<-requests).To(Equal([]byte{0x03}))
+		})
+
+		Context("failure cases", func() {
+			It("returns the error reported by the server", func() {
+				serve(len(request), response("rpc: can't find method Status.Leader", []byte{0x80}))
+
+				_, err := client.Leader(listener.Addr().String())
+				Expect(err).To(MatchError("rpc: can't find method Status.Leader"))
+			})
+
+			It("returns an error when the response is not msgpack rpc", func() {
+				serve(len(request), []byte("HTTP/1.1 400 Bad Request\r\n"))
+
+				_, err := client.Leader(listener.Addr().String())
+				Expect(err).To(MatchError("expected a msgpack map, got type 0x48"))
+			})
+
+			It("returns an error when the server cannot be reached", func() {
+				address := listener.Addr().String()
+				listener.Close()
+
+				_, err := client.Leader(address)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+})
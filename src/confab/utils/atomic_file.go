@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileOwner is the user and group that own a file.
+type FileOwner struct {
+	UID int
+	GID int
+}
+
+// WriteFileAtomically writes contents to path through a temporary file in
+// the same directory, which is synced and renamed over path before the
+// directory is synced, so that path holds either its old or its new contents
+// after a crash. The file is given mode, and owner unless owner is nil. When
+// path already holds contents it is not rewritten, only given mode and owner,
+// and WriteFileAtomically reports that it did not write the file.
+func WriteFileAtomically(path string, contents []byte, mode os.FileMode, owner *FileOwner) (bool, error) {
+	existing, err := ioutil.ReadFile(path)
+	if err == nil && bytes.Equal(existing, contents) {
+		return false, setPermissions(path, mode, owner)
+	}
+
+	dir := filepath.Dir(path)
+
+	// the temporary file does not end in .json, so consul does not load it
+	file, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(contents); err != nil {
+		file.Close()
+		return false, err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return false, err
+	}
+
+	if err := file.Close(); err != nil {
+		return false, err
+	}
+
+	if err := setPermissions(file.Name(), mode, owner); err != nil {
+		return false, err
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return false, err
+	}
+
+	return true, syncDir(dir)
+}
+
+func setPermissions(path string, mode os.FileMode, owner *FileOwner) error {
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+
+	if owner == nil {
+		return nil
+	}
+
+	return os.Chown(path, owner.UID, owner.GID)
+}
@@ -0,0 +1,102 @@
+package utils_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/cloudfoundry-incubator/consul-release/src/confab/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteFileAtomically", func() {
+	var (
+		dir  string
+		path string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "atomic")
+		Expect(err).NotTo(HaveOccurred())
+
+		path = filepath.Join(dir, "config.json")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("writes the file with the given mode without leaving temporary files", func() {
+		written, err := utils.WriteFileAtomically(path, []byte(`{"a":1}`), 0640, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(written).To(BeTrue())
+
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(contents)).To(Equal(`{"a":1}`))
+
+		if runtime.GOOS != "windows" {
+			info, err := os.Stat(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0640)))
+		}
+
+		files, err := ioutil.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+	})
+
+	It("replaces a file with different contents", func() {
+		Expect(ioutil.WriteFile(path, []byte(`{"a":1}`), 0640)).To(Succeed())
+
+		written, err := utils.WriteFileAtomically(path, []byte(`{"a":2}`), 0640, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(written).To(BeTrue())
+
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(contents)).To(Equal(`{"a":2}`))
+	})
+
+	It("does not rewrite a file that already has the contents but fixes its mode", func() {
+		if runtime.GOOS == "windows" {
+			Skip("file modes are not supported on Windows")
+		}
+
+		Expect(ioutil.WriteFile(path, []byte(`{"a":1}`), 0777)).To(Succeed())
+		Expect(os.Chmod(path, 0777)).To(Succeed())
+
+		modTime := time.Now().Add(-time.Hour)
+		Expect(os.Chtimes(path, modTime, modTime)).To(Succeed())
+
+		written, err := utils.WriteFileAtomically(path, []byte(`{"a":1}`), 0640, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(written).To(BeFalse())
+
+		info, err := os.Stat(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.ModTime().Unix()).To(Equal(modTime.Unix()))
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0640)))
+	})
+
+	It("gives the file the owner of the directory", func() {
+		owner := utils.DirOwner(dir)
+		if owner == nil {
+			Skip("files have no owners on this platform")
+		}
+
+		_, err := utils.WriteFileAtomically(path, []byte(`{}`), 0640, owner)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(utils.DirOwner(path)).To(Equal(owner))
+	})
+
+	It("returns an error when the directory does not exist", func() {
+		_, err := utils.WriteFileAtomically(filepath.Join(dir, "missing", "config.json"), []byte(`{}`), 0640, nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
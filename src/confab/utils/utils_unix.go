@@ -13,3 +13,32 @@ func IsPIDRunning(pid int) bool {
 	process, _ := os.FindProcess(pid)
 	return process.Signal(syscall.Signal(0)) == nil
 }
+
+// DirOwner returns the owner of dir, so that files written to it can be given
+// the same owner, or nil when dir cannot be read.
+func DirOwner(dir string) *FileOwner {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	return &FileOwner{
+		UID: int(stat.Uid),
+		GID: int(stat.Gid),
+	}
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -14,3 +14,13 @@ func IsPIDRunning(pid int) bool {
 	process.Release()
 	return true
 }
+
+// DirOwner returns nil, as files on Windows are not given owners.
+func DirOwner(dir string) *FileOwner {
+	return nil
+}
+
+// syncDir does nothing, as directories cannot be synced on Windows.
+func syncDir(dir string) error {
+	return nil
+}